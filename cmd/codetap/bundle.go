@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"codetap/internal/adapter/bundle"
+	"codetap/internal/adapter/commit"
+	"codetap/internal/adapter/downloader"
+	"codetap/internal/adapter/extractor"
+	"codetap/internal/adapter/logger"
+	"codetap/internal/adapter/platform"
+	"codetap/internal/adapter/tlsconfig"
+)
+
+const bundleUsage = `Carry VS Code Servers to air-gapped machines.
+
+Usage:
+  codetap bundle export [flags] -o BUNDLE    Download servers and pack them into a bundle
+  codetap bundle import [flags] BUNDLE       Add the servers in a bundle to ~/.codetap
+
+Examples:
+  codetap bundle export --commit 1.109.5 --arch x64,arm64 --alpine -o bundle.tar
+  codetap bundle import --extract /media/usb/bundle.tar
+
+Run "codetap bundle COMMAND --help" for command-specific flags.
+`
+
+func bundleCmd(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, bundleUsage)
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "export":
+		bundleExportCmd(args[1:])
+	case "import":
+		bundleImportCmd(args[1:])
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stderr, bundleUsage)
+	default:
+		fmt.Fprintf(os.Stderr, "codetap bundle: unknown command %q\n\n", args[0])
+		fmt.Fprint(os.Stderr, bundleUsage)
+		os.Exit(1)
+	}
+}
+
+func bundleExportCmd(args []string) {
+	fs := flag.NewFlagSet("codetap bundle export", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `Download VS Code Servers and pack them into a bundle for offline machines.
+
+The bundle is a tar archive of the server tarballs, a manifest.json and a
+SHA256SUMS file. Downloads go through ~/.codetap/cache and honor the
+CODETAP_DOWNLOAD_URL, CODETAP_UPDATE_API and CODETAP_CA_BUNDLE mirror
+settings.
+
+Usage:
+  codetap bundle export [flags] -o BUNDLE
+
+Flags:`)
+		printFlags(fs)
+	}
+	var commits, arches listFlag
+	fs.Var(&commits, "commit", "version, commit hash, or \"latest\" to pack; repeatable (default: latest)")
+	fs.Var(&arches, "arch", "architectures to pack, x64 and/or arm64; repeatable (default: this machine's)")
+	alpine := fs.Bool("alpine", false, "also pack the Alpine (musl) builds")
+	insecure := fs.Bool("insecure-skip-verify", false, "pack tarballs whose SHA-256 the update API does not publish")
+	var output string
+	fs.StringVar(&output, "o", "", "write the bundle to `FILE` (\"-\" for stdout)")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	if output == "" {
+		fatal(errors.New("give the bundle file with -o"))
+	}
+
+	log := logger.NewStderr()
+	plat, err := platform.New()
+	if err != nil {
+		fatal(err)
+	}
+	if len(arches) == 0 {
+		arch, err := plat.DetectArch()
+		if err != nil {
+			fatal(err)
+		}
+		arches = listFlag{arch}
+	}
+	for _, arch := range arches {
+		if arch != "x64" && arch != "arm64" {
+			fatal(fmt.Errorf("unsupported --arch %q: want x64 or arm64", arch))
+		}
+	}
+	if len(commits) == 0 {
+		commits = listFlag{"latest"}
+	}
+
+	mirror := plat.ResolveMirror(platform.Mirror{})
+	if mirror.DownloadURL != "" {
+		if err := downloader.CheckURLTemplate(mirror.DownloadURL); err != nil {
+			fatal(err)
+		}
+	}
+	tlsConfig, err := tlsconfig.Load(mirror.CABundle, mirror.ClientCert, mirror.ClientKey)
+	if err != nil {
+		fatal(err)
+	}
+	resolver := commit.NewResolver(arches[0], commit.Options{BaseURL: mirror.UpdateAPI, TLS: tlsConfig})
+	dl := downloader.NewHTTPDownloader(plat.CacheDir(), downloader.Options{
+		InsecureSkipVerify: *insecure,
+		DownloadURL:        mirror.DownloadURL,
+		UpdateAPI:          mirror.UpdateAPI,
+		TLS:                tlsConfig,
+	}, log)
+
+	m := bundle.Manifest{Created: time.Now().UTC().Truncate(time.Second), Codetap: version}
+	seen := map[string]bool{}
+	for _, raw := range commits {
+		hash, err := resolver.Resolve(raw)
+		if err != nil {
+			fatal(err)
+		}
+		for _, arch := range arches {
+			artifacts := []string{downloader.ArtifactName(arch, false)}
+			if *alpine {
+				artifacts = append(artifacts, downloader.ArtifactName(arch, true))
+			}
+			for _, artifact := range artifacts {
+				if seen[hash+artifact] {
+					continue
+				}
+				seen[hash+artifact] = true
+				path, sum, err := dl.Tarball(hash, artifact)
+				if err != nil {
+					fatal(err)
+				}
+				if sum == "" {
+					log.Error("packing unverified tarball; the bundle records its current checksum", "path", path)
+				}
+				m.Servers = append(m.Servers, bundle.Server{Commit: hash, Artifact: artifact, SHA256: sum, Unverified: sum == "", Path: path})
+			}
+		}
+	}
+
+	if err := writeBundle(output, m); err != nil {
+		fatal(err)
+	}
+	log.Info("bundle written", "path", output, "servers", len(m.Servers))
+}
+
+// writeBundle writes the bundle to a temporary file next to path and renames
+// it into place, so that an interrupted export leaves no truncated bundle.
+func writeBundle(path string, m bundle.Manifest) error {
+	if path == "-" {
+		return bundle.Write(os.Stdout, m)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := bundle.Write(f, m); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func bundleImportCmd(args []string) {
+	fs := flag.NewFlagSet("codetap bundle import", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `Add the servers in a bundle to ~/.codetap/cache.
+
+Every tarball is checked against the bundle's manifest before it is cached.
+With --extract, the servers built for this machine are also unpacked into
+~/.codetap/repository, so the first "codetap run" starts at once.
+
+Usage:
+  codetap bundle import [flags] BUNDLE   ("-" reads the bundle from stdin)
+
+Flags:`)
+		printFlags(fs)
+	}
+	extract := fs.Bool("extract", false, "also extract the servers built for this machine")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	log := logger.NewStderr()
+	plat, err := platform.New()
+	if err != nil {
+		fatal(err)
+	}
+	arch, err := plat.DetectArch()
+	if err != nil {
+		fatal(err)
+	}
+	local := downloader.LocalArtifact(arch)
+
+	var in io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	cacheDir := plat.CacheDir()
+	var toExtract []bundle.Server
+	_, err = bundle.Read(in, func(s bundle.Server, r io.Reader) error {
+		path, err := downloader.Store(cacheDir, s.Commit, s.Artifact, r, s.SHA256, !s.Unverified, log)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("imported %s %s\n", s.Commit[:12], s.Artifact)
+		if s.Artifact == local {
+			s.Path = path
+			toExtract = append(toExtract, s)
+		}
+		return nil
+	})
+	if err != nil {
+		fatal(err)
+	}
+
+	if !*extract {
+		return
+	}
+	if len(toExtract) == 0 {
+		log.Info("bundle holds no server for this machine, nothing extracted", "artifact", local)
+		return
+	}
+	ext := extractor.NewTarExtractor(plat.RepositoryDir(), log)
+	ext.SetLayers(plat.ResolveRepositoryPath(""))
+	for _, s := range toExtract {
+		if ext.IsProvisioned(s.Commit) {
+			continue
+		}
+		if err := ext.Extract(s.Path, ext.ServerDir(s.Commit)); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("extracted %s %s\n", s.Commit[:12], s.Artifact)
+	}
+}
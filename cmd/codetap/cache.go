@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"codetap/internal/adapter/cache"
+	"codetap/internal/adapter/downloader"
+	"codetap/internal/adapter/logger"
+	"codetap/internal/adapter/platform"
+	"codetap/internal/adapter/store"
+	"codetap/internal/adapter/token"
+	"codetap/internal/app"
+	"codetap/internal/domain"
+)
+
+const cacheUsage = `Manage downloaded and extracted VS Code Servers in ~/.codetap.
+
+Usage:
+  codetap cache list [flags]                 List cached servers
+  codetap cache prune [flags]                Remove servers not used recently
+  codetap cache rm [flags] COMMIT...         Remove servers by commit (or prefix)
+
+A server that a live session is running is never removed. If a session does
+not answer, prune and rm refuse to remove anything unless given --force.
+
+Examples:
+  codetap cache prune --keep 3 --older-than 30d
+  codetap cache rm 072586267e68
+
+Run "codetap cache COMMAND --help" for command-specific flags.
+`
+
+func cacheCmd(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, cacheUsage)
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "list", "ls":
+		cacheListCmd(args[1:])
+	case "prune":
+		cachePruneCmd(args[1:])
+	case "rm", "remove":
+		cacheRmCmd(args[1:])
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stderr, cacheUsage)
+	default:
+		fmt.Fprintf(os.Stderr, "codetap cache: unknown command %q\n\n", args[0])
+		fmt.Fprint(os.Stderr, cacheUsage)
+		os.Exit(1)
+	}
+}
+
+// cacheService wires a Service for the cache commands: the server cache plus
+// the socket store to find the commits live sessions are running.
+func cacheService(socketDir string) *app.Service {
+	plat, err := platform.New()
+	if err != nil {
+		fatal(err)
+	}
+	st := store.NewFileStore(plat.ResolveSocketDir(socketDir))
+	sc := cache.New(plat.RepositoryDir(), plat.CacheDir())
+	return app.NewService(nil, nil, nil, sc, nil, st, token.NewRandomGenerator(), logger.NewStderr())
+}
+
+func cacheListCmd(args []string) {
+	fs := flag.NewFlagSet("codetap cache list", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `List cached VS Code Servers, most recently used first.
+
+Usage:
+  codetap cache list [flags]
+
+Flags:`)
+		printFlags(fs)
+	}
+	socketDir := fs.String("socket-dir", "", "socket directory to look for live sessions in (default: /dev/shm/codetap)")
+	jsonOut := fs.Bool("json", false, "print a JSON array of cache entries")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+
+	entries, err := cacheService(*socketDir).CacheList()
+	if err != nil {
+		fatal(err)
+	}
+	if *jsonOut {
+		if entries == nil {
+			entries = []domain.CacheEntry{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("No cached servers.")
+		return
+	}
+	if err := writeCacheTable(os.Stdout, entries); err != nil {
+		fatal(err)
+	}
+}
+
+func cachePruneCmd(args []string) {
+	fs := flag.NewFlagSet("codetap cache prune", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `Remove cached servers that have not been used recently.
+
+A server is removed if it is not among the --keep most recently used ones
+and, with --older-than, has not been used for that long. Servers in use by
+a live session are always kept.
+
+Usage:
+  codetap cache prune [flags]
+
+Flags:`)
+		printFlags(fs)
+	}
+	socketDir := fs.String("socket-dir", "", "socket directory to look for live sessions in (default: /dev/shm/codetap)")
+	keep := fs.Int("keep", 0, "keep this many most recently used servers")
+	olderThan := fs.String("older-than", "", "only remove servers unused for this long, e.g. 30d or 12h")
+	dryRun := fs.Bool("dry-run", false, "print what would be removed without removing it")
+	force := fs.Bool("force", false, "remove servers even if a session does not answer and may be running them")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	if *keep == 0 && *olderThan == "" {
+		fatal(errors.New("give --keep, --older-than or both"))
+	}
+	age, err := parseAge(*olderThan)
+	if err != nil {
+		fatal(fmt.Errorf("--older-than: %w", err))
+	}
+
+	removed, err := cacheService(*socketDir).CachePrune(app.PruneOptions{Keep: *keep, OlderThan: age, DryRun: *dryRun, Force: *force})
+	verb := "removed"
+	if *dryRun {
+		verb = "would remove"
+	}
+	var freed int64
+	for _, e := range removed {
+		freed += e.Size
+		fmt.Printf("%s %s %s (%s)\n", verb, e.Commit[:12], orDash(e.Version), downloader.FormatBytes(e.Size))
+	}
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("%s %d servers, %s\n", verb, len(removed), downloader.FormatBytes(freed))
+}
+
+func cacheRmCmd(args []string) {
+	fs := flag.NewFlagSet("codetap cache rm", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `Remove cached servers by commit or unique commit prefix.
+
+Usage:
+  codetap cache rm [flags] COMMIT...
+
+Flags:`)
+		printFlags(fs)
+	}
+	socketDir := fs.String("socket-dir", "", "socket directory to look for live sessions in (default: /dev/shm/codetap)")
+	force := fs.Bool("force", false, "remove servers even if a session does not answer and may be running them")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	svc := cacheService(*socketDir)
+	failed := false
+	for _, commit := range fs.Args() {
+		e, err := svc.CacheRemove(commit, *force)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "codetap: %v\n", err)
+			failed = true
+			continue
+		}
+		fmt.Printf("removed %s %s (%s)\n", e.Commit[:12], orDash(e.Version), downloader.FormatBytes(e.Size))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func writeCacheTable(out io.Writer, entries []domain.CacheEntry) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COMMIT\tVERSION\tSIZE\tTARBALL\tLAST USED\tIN USE")
+	for _, e := range entries {
+		lastUsed := "-"
+		if !e.LastUsed.IsZero() {
+			lastUsed = e.LastUsed.Local().Format(time.DateTime)
+		}
+		tarball := "-"
+		if e.TarballSize > 0 {
+			tarball = downloader.FormatBytes(e.TarballSize)
+		}
+		size := downloader.FormatBytes(e.Size)
+		if !e.Extracted {
+			size += " (not extracted)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Commit[:12], orDash(e.Version), size, tarball, lastUsed, orDash(strings.Join(e.InUse, ",")))
+	}
+	return w.Flush()
+}
+
+// parseAge parses a duration that may also be given in days, e.g. "30d".
+// An empty string is zero.
+func parseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
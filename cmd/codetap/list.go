@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"codetap/internal/adapter/logger"
+	"codetap/internal/adapter/platform"
+	"codetap/internal/adapter/store"
+	"codetap/internal/adapter/token"
+	"codetap/internal/app"
+	"codetap/internal/domain"
+)
+
+func listCmd(args []string) {
+	fs := flag.NewFlagSet("codetap list", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `List all discovered CodeTap sessions.
+
+The default output is a table. --json, --jsonl and --format print every
+field of each entry (name, path, metadata, health, alive) for scripts.
+
+Usage:
+  codetap list [flags]
+
+--watch keeps the table on screen and redraws it when sessions appear,
+disappear, restart or die.
+
+Examples:
+  codetap list --json
+  codetap list --alive --format '{{.Name}} {{.Metadata.Commit}}'
+  codetap list --watch --selector project=foo
+
+Flags:`)
+		printFlags(fs)
+	}
+
+	socketDir := fs.String("socket-dir", "", "socket directory (default: /dev/shm/codetap)")
+	selector := fs.String("selector", "", "only list sessions whose labels match, e.g. project=foo,env!=ci")
+	jsonOut := fs.Bool("json", false, "print a JSON array of sessions")
+	jsonlOut := fs.Bool("jsonl", false, "print one JSON object per session and line")
+	format := fs.String("format", "", "print each session with a Go text/template, e.g. '{{.Name}} {{.Metadata.Commit}}'")
+	aliveOnly := fs.Bool("alive", false, "only list sessions that answer INFO")
+	deadOnly := fs.Bool("dead", false, "only list sessions that do not answer INFO")
+	watch := fs.Bool("watch", false, "redraw the table whenever sessions change")
+	interval := fs.Duration("interval", 10*time.Second, "with --watch, refresh health at least this often")
+	if err := fs.Parse(args); err != nil {
+		fatal(err)
+	}
+	sel, err := app.ParseSelector(*selector)
+	if err != nil {
+		fatal(err)
+	}
+	if *aliveOnly && *deadOnly {
+		fatal(errors.New("--alive and --dead are mutually exclusive"))
+	}
+
+	printEntries, err := listPrinter(*jsonOut, *jsonlOut, *format)
+	if err != nil {
+		fatal(err)
+	}
+	if *watch && (*jsonOut || *jsonlOut || *format != "") {
+		fatal(errors.New("--watch only supports the table output"))
+	}
+	if *interval <= 0 {
+		fatal(errors.New("--interval must be positive"))
+	}
+
+	plat, err := platform.New()
+	if err != nil {
+		fatal(err)
+	}
+
+	sockDir := plat.ResolveSocketDir(*socketDir)
+	st := store.NewFileStore(sockDir)
+	log := logger.NewStderr()
+	tg := token.NewRandomGenerator()
+
+	svc := app.NewService(nil, nil, nil, nil, nil, st, tg, log)
+
+	if *watch {
+		filter := func(entries []domain.SocketEntry) []domain.SocketEntry {
+			return filterAlive(sel.Filter(entries), *aliveOnly, *deadOnly)
+		}
+		watchList(svc, st, filter, *interval, log)
+		return
+	}
+
+	entries, err := svc.List()
+	if err != nil {
+		fatal(err)
+	}
+	entries = filterAlive(sel.Filter(entries), *aliveOnly, *deadOnly)
+
+	if err := printEntries(os.Stdout, entries); err != nil {
+		fatal(err)
+	}
+}
+
+// listPrinter picks the output mode of codetap list. At most one of the
+// machine-readable modes may be given; without any the table is printed.
+func listPrinter(jsonOut, jsonlOut bool, format string) (func(io.Writer, []domain.SocketEntry) error, error) {
+	modes := 0
+	for _, set := range []bool{jsonOut, jsonlOut, format != ""} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return nil, errors.New("--json, --jsonl and --format are mutually exclusive")
+	}
+
+	switch {
+	case jsonOut:
+		return printJSON, nil
+	case jsonlOut:
+		return printJSONL, nil
+	case format != "":
+		tmpl, err := template.New("format").Parse(format)
+		if err != nil {
+			return nil, fmt.Errorf("--format: %w", err)
+		}
+		return func(w io.Writer, entries []domain.SocketEntry) error {
+			return printTemplate(w, tmpl, entries)
+		}, nil
+	default:
+		return printTable, nil
+	}
+}
+
+// filterAlive applies --alive and --dead.
+func filterAlive(entries []domain.SocketEntry, aliveOnly, deadOnly bool) []domain.SocketEntry {
+	if !aliveOnly && !deadOnly {
+		return entries
+	}
+	var out []domain.SocketEntry
+	for _, e := range entries {
+		if e.Alive == aliveOnly {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// printJSON writes all entries as one indented JSON array; "[]" when empty.
+func printJSON(w io.Writer, entries []domain.SocketEntry) error {
+	if entries == nil {
+		entries = []domain.SocketEntry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// printJSONL writes one compact JSON object per entry and line.
+func printJSONL(w io.Writer, entries []domain.SocketEntry) error {
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printTemplate executes tmpl once per entry, each followed by a newline.
+func printTemplate(w io.Writer, tmpl *template.Template, entries []domain.SocketEntry) error {
+	for _, e := range entries {
+		if err := tmpl.Execute(w, e); err != nil {
+			return fmt.Errorf("--format: %w", err)
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printTable writes the human-readable session table.
+func printTable(out io.Writer, entries []domain.SocketEntry) error {
+	if len(entries) == 0 {
+		_, err := fmt.Fprintln(out, "No sessions found.")
+		return err
+	}
+	return writeTable(out, entries, nil)
+}
+
+// writeTable writes one table row per entry. With marks, each row starts
+// with marks[i] in an extra leading column.
+func writeTable(out io.Writer, entries []domain.SocketEntry, marks []string) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	if marks != nil {
+		fmt.Fprint(w, " \t")
+	}
+	fmt.Fprintln(w, "NAME\tCOMMIT\tFOLDER\tPID\tUSER\tHOST\tSTATUS\tSTARTED\tLABELS")
+	for i, e := range entries {
+		if marks != nil {
+			fmt.Fprintf(w, "%s\t", marks[i])
+		}
+		status := "dead"
+		switch e.Probe {
+		case domain.ProbeTimeout:
+			status = "hung"
+		case domain.ProbeError:
+			status = "error"
+		}
+		started := "-"
+		commitShort := "-"
+		folder := "-"
+		pid := 0
+		owner := "-"
+		host := "-"
+		labels := "-"
+		if e.Alive {
+			status = "alive"
+			if e.Health.State != "" {
+				status = e.Health.State
+			}
+			if e.Metadata.Commit != "" {
+				commitShort = e.Metadata.Commit
+			}
+			if len(commitShort) > 12 {
+				commitShort = commitShort[:12]
+			}
+			folder = e.Metadata.Folder
+			pid = e.Metadata.PID
+			switch {
+			case e.Metadata.User != "":
+				owner = e.Metadata.User
+			case e.Metadata.UID != nil:
+				owner = strconv.Itoa(*e.Metadata.UID)
+			}
+			// A container ID says more than the container's random hostname.
+			switch {
+			case len(e.Metadata.ContainerID) >= 12:
+				host = e.Metadata.ContainerRuntime + ":" + e.Metadata.ContainerID[:12]
+			case e.Metadata.Hostname != "":
+				host = e.Metadata.Hostname
+			}
+			if len(e.Metadata.Labels) > 0 {
+				labels = app.FormatLabels(e.Metadata.Labels)
+			}
+			if !e.Metadata.StartedAt.IsZero() {
+				started = e.Metadata.StartedAt.Format(time.DateTime)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			e.Name, commitShort, folder, pid, owner, host, status, started, labels)
+	}
+	return w.Flush()
+}
@@ -1,27 +1,30 @@
 package main
 
 import (
-	"bufio"
 	"crypto/rand"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"net"
 	"os"
+	"os/user"
+	"strconv"
 	"strings"
 	"sync"
-	"text/tabwriter"
 	"time"
 
+	"codetap/internal/adapter/cache"
 	"codetap/internal/adapter/commit"
 	"codetap/internal/adapter/downloader"
 	"codetap/internal/adapter/extractor"
 	"codetap/internal/adapter/logger"
+	"codetap/internal/adapter/peercred"
 	"codetap/internal/adapter/platform"
 	"codetap/internal/adapter/relay"
 	"codetap/internal/adapter/server"
 	"codetap/internal/adapter/store"
+	"codetap/internal/adapter/tlsconfig"
 	"codetap/internal/adapter/token"
+	"codetap/internal/adapter/vscodeserver"
 	"codetap/internal/app"
 	"codetap/internal/domain"
 )
@@ -36,6 +39,9 @@ Usage:
   codetap relay [flags] -- CMD...    Relay a remote session over stdio
   codetap list [flags]               List discovered sessions
   codetap clean [flags]              Remove stale sessions
+  codetap stop [flags] [NAME...]     Stop running sessions
+  codetap cache COMMAND              List, prune or remove cached servers
+  codetap bundle COMMAND             Export or import servers for offline machines
 
 Running with no subcommand prints this help. Flags without a subcommand
 default to "codetap run" (e.g. codetap --commit abc123).
@@ -60,14 +66,23 @@ Run "codetap COMMAND --help" for command-specific flags.
 `
 
 // printFlags formats flag defaults with -- prefix instead of Go's default single -.
+// Single-letter shorthands such as -o keep a single dash.
 func printFlags(fs *flag.FlagSet) {
 	fs.VisitAll(func(f *flag.Flag) {
+		prefix, width := "--", 20
+		if len(f.Name) == 1 {
+			prefix, width = "-", 21
+		}
 		isBool := f.DefValue == "false" || f.DefValue == "true"
 		if isBool {
-			fmt.Fprintf(os.Stderr, "  --%-20s %s\n", f.Name, f.Usage)
+			fmt.Fprintf(os.Stderr, "  %s%-*s %s\n", prefix, width, f.Name, f.Usage)
 		} else {
-			label := f.Name + " " + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
-			fmt.Fprintf(os.Stderr, "  --%-20s %s\n", label, f.Usage)
+			// A `name` in backquotes in the usage overrides the placeholder.
+			arg, usage := strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_")), f.Usage
+			if strings.Contains(f.Usage, "`") {
+				arg, usage = flag.UnquoteUsage(f)
+			}
+			fmt.Fprintf(os.Stderr, "  %s%-*s %s\n", prefix, width, f.Name+" "+arg, usage)
 		}
 	})
 }
@@ -93,6 +108,12 @@ func main() {
 		listCmd(os.Args[2:])
 	case "clean":
 		cleanCmd(os.Args[2:])
+	case "stop":
+		stopCmd(os.Args[2:])
+	case "cache":
+		cacheCmd(os.Args[2:])
+	case "bundle":
+		bundleCmd(os.Args[2:])
 	case "relay":
 		relayCmd(os.Args[2:])
 	default:
@@ -119,6 +140,11 @@ The --commit value can be a 40-char hex hash, a version like "1.109.5", or
 "latest". If omitted, it is auto-resolved from: --commit flag > CODETAP_COMMIT
 env > ~/.codetap/.commit > local "code --version" > latest stable from Microsoft.
 
+In networks that cannot reach Microsoft, point --download-url (or
+CODETAP_DOWNLOAD_URL) and CODETAP_UPDATE_API at an internal mirror. Servers
+baked into read-only volumes are found through --repository-path (or
+CODETAP_REPOSITORY_PATH) before anything is downloaded.
+
 Flags:`)
 		printFlags(fs)
 	}
@@ -128,10 +154,46 @@ Flags:`)
 	folder := fs.String("folder", "", "workspace folder path (default: cwd)")
 	socketDir := fs.String("socket-dir", "", "socket directory (default: /dev/shm/codetap)")
 	stdio := fs.Bool("stdio", false, "relay traffic over stdin/stdout instead of /dev/shm")
+	var allowUIDs, allowGroups listFlag
+	fs.Var(&allowUIDs, "allow-uid", "additional user (name or uid) allowed to connect; repeatable")
+	fs.Var(&allowGroups, "allow-group", "group (name or gid) allowed to connect; repeatable")
+	shareGroup := fs.String("share-group", "", "share the socket directory with a group (name or gid) instead of keeping it private")
+	var labelFlags listFlag
+	fs.Var(&labelFlags, "label", "session label key=value, reported by INFO; repeatable")
+	leaseTimeout := fs.Duration("lease-timeout", 0, "expire a keepalive lease after this much client silence (default: 3 keepalive intervals)")
+	noTakeover := fs.Bool("no-takeover", false, "refuse CONNECT force; clients on other versions cannot evict existing leases")
+	takeoverGrace := fs.Duration("takeover-grace", 10*time.Second, "how long evicted clients are warned before a forced takeover closes them")
+	idleTimeout := fs.Duration("idle-timeout", 0, "stop code-server after this long without leases; the next CONNECT restarts it (0 disables)")
+	lazy := fs.Bool("lazy", false, "open the control socket without starting code-server; the first CONNECT starts the commit it asks for")
+	multiVersion := fs.Bool("multi-version", false, "serve clients on other commits from additional code-servers instead of restarting")
+	verify := fs.Bool("verify", false, "re-check the SHA-256 of a cached server tarball before extracting it")
+	insecure := fs.Bool("insecure-skip-verify", false, "use server tarballs whose SHA-256 the update API does not publish")
+	noReuse := fs.Bool("no-reuse", false, "do not reuse servers that Remote-SSH or Dev Containers installed in ~/.vscode-server")
+	repoPath := fs.String("repository-path", "", "read-only server repositories to search before ~/.codetap/repository, separated by ':' (default: CODETAP_REPOSITORY_PATH)")
+	dlConnectTimeout := fs.Duration("download-connect-timeout", 30*time.Second, "give up connecting to the download server after this long")
+	dlIdleTimeout := fs.Duration("download-idle-timeout", 60*time.Second, "abort a download attempt after this long without data")
+	dlRetries := fs.Int("download-retries", 5, "retry an interrupted download this often, resuming where it stopped")
+	var mirrorFlags platform.Mirror
+	fs.StringVar(&mirrorFlags.DownloadURL, "download-url", "", "tarball URL template with {commit}, {artifact} and {quality}, e.g. for an internal mirror")
+	fs.StringVar(&mirrorFlags.CABundle, "ca-bundle", "", "PEM file of additional CAs to trust for downloads and the update API")
+	fs.StringVar(&mirrorFlags.ClientCert, "client-cert", "", "PEM client certificate presented to the download mirror")
+	fs.StringVar(&mirrorFlags.ClientKey, "client-key", "", "PEM key for --client-cert (default: read from the certificate file)")
 	if err := fs.Parse(args); err != nil {
 		fatal(err)
 	}
 
+	if *shareGroup != "" {
+		allowGroups = append(allowGroups, *shareGroup)
+	}
+	access, err := accessPolicy(allowUIDs, allowGroups)
+	if err != nil {
+		fatal(err)
+	}
+	labels, err := app.ParseLabels(labelFlags)
+	if err != nil {
+		fatal(err)
+	}
+
 	log := logger.NewStderr()
 
 	plat, err := platform.New()
@@ -150,7 +212,18 @@ Flags:`)
 		fatal(err)
 	}
 
-	resolver := commit.NewResolver(arch)
+	mirror := plat.ResolveMirror(mirrorFlags)
+	if mirror.DownloadURL != "" {
+		if err := downloader.CheckURLTemplate(mirror.DownloadURL); err != nil {
+			fatal(err)
+		}
+	}
+	tlsConfig, err := tlsconfig.Load(mirror.CABundle, mirror.ClientCert, mirror.ClientKey)
+	if err != nil {
+		fatal(err)
+	}
+
+	resolver := commit.NewResolver(arch, commit.Options{BaseURL: mirror.UpdateAPI, TLS: tlsConfig})
 
 	resolvedCommit, err := resolver.Resolve(rawCommit)
 	if err != nil {
@@ -164,9 +237,9 @@ Flags:`)
 		}
 	}
 
-	if resolvedCommit == "" && !*stdio {
-		// Only fetch latest in direct mode; stdio mode defers to init phase
-		log.Info("no commit specified, fetching latest stable from Microsoft")
+	if resolvedCommit == "" && !*stdio && !*lazy {
+		// Only fetch latest in direct mode; stdio and lazy modes defer to the client
+		log.Info("no commit specified, fetching latest stable from the update API")
 		resolvedCommit, err = resolver.Resolve("latest")
 		if err != nil {
 			fatal(fmt.Errorf("auto-resolve commit: %w\n\nTo run offline, provide --commit, set CODETAP_COMMIT, or write a value to ~/.codetap/.commit", err))
@@ -188,25 +261,62 @@ Flags:`)
 	cacheDir := plat.CacheDir()
 	repoDir := plat.RepositoryDir()
 
-	dl := downloader.NewHTTPDownloader(cacheDir, log)
+	if *dlRetries == 0 {
+		*dlRetries = -1 // Options treats zero as "default"
+	}
+	httpDL := downloader.NewHTTPDownloader(cacheDir, downloader.Options{
+		Verify:             *verify,
+		InsecureSkipVerify: *insecure,
+		ConnectTimeout:     *dlConnectTimeout,
+		IdleTimeout:        *dlIdleTimeout,
+		Retries:            *dlRetries,
+		DownloadURL:        mirror.DownloadURL,
+		UpdateAPI:          mirror.UpdateAPI,
+		TLS:                tlsConfig,
+	}, log)
+	var dl domain.Downloader = httpDL
+	if *stdio {
+		// Fetch the server from the relay host's cache if it offers it.
+		dl = downloader.NewRelayDownloader(cacheDir, os.Stdin, os.Stdout, httpDL, log)
+	}
 	ext := extractor.NewTarExtractor(repoDir, log)
+	ext.SetLayers(plat.ResolveRepositoryPath(*repoPath))
+	sc := cache.New(repoDir, cacheDir)
 	runner := server.NewProcessRunner(log)
-	st := store.NewFileStore(sockDir)
+	st, err := newStore(sockDir, *shareGroup)
+	if err != nil {
+		fatal(err)
+	}
 	tg := token.NewRandomGenerator()
 
-	svc := app.NewService(dl, ext, ext, runner, st, tg, log)
+	var prov domain.Provisioner = ext
+	if !*noReuse {
+		prov = vscodeserver.New(ext, plat.VSCodeServerDir(), log)
+	}
+
+	svc := app.NewService(dl, ext, prov, sc, runner, st, tg, log)
 
 	cfg := app.Config{
-		Name:      resolvedName,
-		Commit:    resolvedCommit,
-		Arch:      arch,
-		Folder:    resolvedFolder,
-		SocketDir: sockDir,
+		Name:          resolvedName,
+		Commit:        resolvedCommit,
+		Arch:          arch,
+		Folder:        resolvedFolder,
+		SocketDir:     sockDir,
+		Access:        access,
+		Version:       version,
+		Host:          plat.DetectHost(),
+		Labels:        labels,
+		LeaseTimeout:  *leaseTimeout,
+		NoTakeover:    *noTakeover,
+		TakeoverGrace: *takeoverGrace,
+		MultiVersion:  *multiVersion,
+		Lazy:          *lazy,
+		IdleTimeout:   *idleTimeout,
 	}
 
 	if *stdio {
 		fallback := func() (string, error) {
-			log.Info("no commit from relay, fetching latest stable from Microsoft")
+			log.Info("no commit from relay, fetching latest stable from the update API")
 			c, err := resolver.Resolve("latest")
 			if err != nil {
 				return "", fmt.Errorf("auto-resolve commit: %w", err)
@@ -224,22 +334,35 @@ Flags:`)
 	}
 }
 
-func listCmd(args []string) {
-	fs := flag.NewFlagSet("codetap list", flag.ExitOnError)
+func cleanCmd(args []string) {
+	fs := flag.NewFlagSet("codetap clean", flag.ExitOnError)
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, `List all discovered CodeTap sessions.
+		fmt.Fprintln(os.Stderr, `Remove stale sessions whose control sockets are no longer alive.
+
+Also reports orphaned code-server processes: servers started on a socket in
+the socket directory whose session has no live control socket, e.g. after
+codetap was killed with SIGKILL. --kill terminates their process groups.
 
 Usage:
-  codetap list [flags]
+  codetap clean [flags]
 
 Flags:`)
 		printFlags(fs)
 	}
 
 	socketDir := fs.String("socket-dir", "", "socket directory (default: /dev/shm/codetap)")
+	selector := fs.String("selector", "", "only remove sessions whose labels match; stale sessions have no labels, so only selectors like !key are accepted")
+	force := fs.Bool("force", false, "also remove sessions that time out or answer with an error, not only those with nothing listening")
+	kill := fs.Bool("kill", false, "terminate the process groups of orphaned code-servers")
 	if err := fs.Parse(args); err != nil {
 		fatal(err)
 	}
+	sel, err := app.ParseSelector(*selector)
+	if err != nil {
+		fatal(err)
+	}
+
+	log := logger.NewStderr()
 
 	plat, err := platform.New()
 	if err != nil {
@@ -248,63 +371,49 @@ Flags:`)
 
 	sockDir := plat.ResolveSocketDir(*socketDir)
 	st := store.NewFileStore(sockDir)
-	log := logger.NewStderr()
 	tg := token.NewRandomGenerator()
 
-	svc := app.NewService(nil, nil, nil, nil, st, tg, log)
+	svc := app.NewService(nil, nil, nil, nil, nil, st, tg, log)
 
-	entries, err := svc.List()
-	if err != nil {
+	if err := svc.Clean(app.CleanOptions{Selector: sel, Force: *force, Kill: *kill}); err != nil {
 		fatal(err)
 	}
-
-	if len(entries) == 0 {
-		fmt.Println("No sessions found.")
-		return
-	}
-
-	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
-	fmt.Fprintln(w, "NAME\tCOMMIT\tFOLDER\tPID\tSTATUS\tSTARTED")
-	for _, e := range entries {
-		status := "dead"
-		started := "-"
-		commitShort := "-"
-		folder := "-"
-		pid := 0
-		if e.Alive {
-			status = "alive"
-			commitShort = e.Metadata.Commit
-			if len(commitShort) > 12 {
-				commitShort = commitShort[:12]
-			}
-			folder = e.Metadata.Folder
-			pid = e.Metadata.PID
-			if !e.Metadata.StartedAt.IsZero() {
-				started = e.Metadata.StartedAt.Format(time.DateTime)
-			}
-		}
-		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n",
-			e.Name, commitShort, folder, pid, status, started)
-	}
-	w.Flush()
 }
 
-func cleanCmd(args []string) {
-	fs := flag.NewFlagSet("codetap clean", flag.ExitOnError)
+func stopCmd(args []string) {
+	fs := flag.NewFlagSet("codetap stop", flag.ExitOnError)
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, `Remove stale sessions whose control sockets are no longer alive.
+		fmt.Fprintln(os.Stderr, `Stop running sessions by name or by label selector.
+
+Each session's codetap process receives SIGTERM, shuts down its code-server
+and removes its sockets. Sessions running in another PID namespace (e.g.
+inside a container) must be stopped from there.
 
 Usage:
-  codetap clean [flags]
+  codetap stop [flags] [NAME...]
+
+Examples:
+  codetap stop myproject
+  codetap stop --selector project=foo,env!=ci
 
 Flags:`)
 		printFlags(fs)
 	}
 
 	socketDir := fs.String("socket-dir", "", "socket directory (default: /dev/shm/codetap)")
+	selector := fs.String("selector", "", "stop every live session whose labels match, e.g. project=foo")
 	if err := fs.Parse(args); err != nil {
 		fatal(err)
 	}
+	names := fs.Args()
+	if len(names) == 0 && *selector == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	sel, err := app.ParseSelector(*selector)
+	if err != nil {
+		fatal(err)
+	}
 
 	log := logger.NewStderr()
 
@@ -317,10 +426,35 @@ Flags:`)
 	st := store.NewFileStore(sockDir)
 	tg := token.NewRandomGenerator()
 
-	svc := app.NewService(nil, nil, nil, nil, st, tg, log)
+	svc := app.NewService(nil, nil, nil, nil, nil, st, tg, log)
 
-	if err := svc.Clean(); err != nil {
-		fatal(err)
+	if *selector != "" {
+		entries, err := svc.List()
+		if err != nil {
+			fatal(err)
+		}
+		for _, e := range sel.Filter(entries) {
+			if e.Alive {
+				names = append(names, e.Name)
+			}
+		}
+		if len(names) == 0 {
+			log.Info("no running sessions match", "selector", *selector)
+			return
+		}
+	}
+
+	failed := false
+	for _, name := range names {
+		if err := svc.Stop(name); err != nil {
+			log.Error("stop failed", "name", name, "err", err)
+			failed = true
+			continue
+		}
+		log.Info("session stopped", "name", name)
+	}
+	if failed {
+		os.Exit(1)
 	}
 }
 
@@ -352,10 +486,30 @@ Flags:`)
 	name := fs.String("name", "", "session name (default: hostname)")
 	folder := fs.String("folder", "", "workspace folder for metadata (default: cwd)")
 	socketDir := fs.String("socket-dir", "", "socket directory (default: /dev/shm/codetap)")
+	var allowUIDs, allowGroups listFlag
+	fs.Var(&allowUIDs, "allow-uid", "additional user (name or uid) allowed to connect; repeatable")
+	fs.Var(&allowGroups, "allow-group", "group (name or gid) allowed to connect; repeatable")
+	shareGroup := fs.String("share-group", "", "share the socket directory with a group (name or gid) instead of keeping it private")
+	var labelFlags listFlag
+	fs.Var(&labelFlags, "label", "session label key=value, reported by INFO; repeatable")
+	noSupply := fs.Bool("no-supply", false, "do not supply server tarballs from the host cache to the remote")
+	insecure := fs.Bool("insecure-skip-verify", false, "supply server tarballs whose SHA-256 the update API does not publish")
 	if err := fs.Parse(args); err != nil {
 		fatal(err)
 	}
 
+	if *shareGroup != "" {
+		allowGroups = append(allowGroups, *shareGroup)
+	}
+	access, err := accessPolicy(allowUIDs, allowGroups)
+	if err != nil {
+		fatal(err)
+	}
+	labels, err := app.ParseLabels(labelFlags)
+	if err != nil {
+		fatal(err)
+	}
+
 	remaining := fs.Args()
 	if len(remaining) == 0 {
 		fs.Usage()
@@ -384,7 +538,10 @@ Flags:`)
 		resolvedName = defaultName()
 	}
 
-	st := store.NewFileStore(sockDir)
+	st, err := newStore(sockDir, *shareGroup)
+	if err != nil {
+		fatal(err)
+	}
 	if err := st.EnsureDir(); err != nil {
 		fatal(err)
 	}
@@ -399,6 +556,29 @@ Flags:`)
 
 	arch, _ := plat.DetectArch()
 
+	// Remotes without network access can fetch the server from our cache,
+	// which is filled from the same sources as "codetap run" would use.
+	var supply relay.SupplyFunc
+	if !*noSupply {
+		mirror := plat.ResolveMirror(platform.Mirror{})
+		if mirror.DownloadURL != "" {
+			if err := downloader.CheckURLTemplate(mirror.DownloadURL); err != nil {
+				fatal(err)
+			}
+		}
+		tlsConfig, err := tlsconfig.Load(mirror.CABundle, mirror.ClientCert, mirror.ClientKey)
+		if err != nil {
+			fatal(err)
+		}
+		dl := downloader.NewHTTPDownloader(plat.CacheDir(), downloader.Options{
+			InsecureSkipVerify: *insecure,
+			DownloadURL:        mirror.DownloadURL,
+			UpdateAPI:          mirror.UpdateAPI,
+			TLS:                tlsConfig,
+		}, log)
+		supply = dl.Tarball
+	}
+
 	// Clean stale socket files.
 	_ = os.Remove(ctlSocketPath)
 	_ = os.Remove(socketPath)
@@ -408,6 +588,10 @@ Flags:`)
 	if err != nil {
 		fatal(fmt.Errorf("listen ctl socket: %w", err))
 	}
+	if err := st.PrepareSocket(ctlSocketPath); err != nil {
+		_ = ctlListener.Close()
+		fatal(fmt.Errorf("prepare ctl socket: %w", err))
+	}
 
 	defer func() {
 		log.Info("cleaning up relay session", "name", resolvedName)
@@ -421,13 +605,18 @@ Flags:`)
 	commitCh := make(chan string, 1)
 	var commitOnce sync.Once
 
-	// Relay session metadata for INFO queries.
+	// Relay session metadata for INFO and HEALTH queries.
 	relayMeta := &relayState{
-		name:      resolvedName,
-		arch:      arch,
-		folder:    resolvedFolder,
-		pid:       os.Getpid(),
-		startedAt: time.Now(),
+		name:       resolvedName,
+		arch:       arch,
+		folder:     resolvedFolder,
+		pid:        os.Getpid(),
+		startedAt:  time.Now(),
+		socketPath: socketPath,
+		access:     access,
+		version:    version,
+		host:       plat.DetectHost(),
+		labels:     labels,
 	}
 
 	// Accept control connections in background.
@@ -454,101 +643,93 @@ Flags:`)
 		relayMeta.mu.Lock()
 		relayMeta.commit = ackedCommit
 		relayMeta.mu.Unlock()
+		// HostSide has created the data socket listener by now.
+		if err := st.PrepareSocket(socketPath); err != nil {
+			log.Error("prepare data socket failed", "socket", socketPath, "err", err)
+		}
+	}
+
+	authorize := func(conn net.Conn) error {
+		_, err := peercred.Authorize(conn, access)
+		return err
 	}
 
-	if err := relay.HostSide(socketPath, remaining, clientCommit, onInit, log); err != nil {
+	if err := relay.HostSide(socketPath, remaining, clientCommit, onInit, authorize, supply, log); err != nil {
 		fatal(err)
 	}
 }
 
-// relayState holds metadata for a relay session's control socket.
-type relayState struct {
-	mu        sync.Mutex
-	name      string
-	commit    string
-	arch      string
-	folder    string
-	pid       int
-	startedAt time.Time
-}
+// listFlag collects a repeatable flag whose values may also be comma-separated.
+type listFlag []string
 
-// handleRelayCtlConn handles INFO and CONNECT on the relay's control socket.
-func handleRelayCtlConn(conn net.Conn, state *relayState, commitCh chan string, commitOnce *sync.Once, log domain.Logger) {
-	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
 
-	reader := bufio.NewReader(conn)
-	line, err := reader.ReadString('\n')
-	if err != nil {
-		_ = conn.Close()
-		return
-	}
-	line = strings.TrimSpace(line)
-
-	switch {
-	case line == "CTAP1 INFO":
-		state.mu.Lock()
-		info := struct {
-			Name      string `json:"name"`
-			Commit    string `json:"commit"`
-			Arch      string `json:"arch"`
-			Folder    string `json:"folder"`
-			PID       int    `json:"pid"`
-			StartedAt string `json:"started_at"`
-		}{
-			Name:      state.name,
-			Commit:    state.commit,
-			Arch:      state.arch,
-			Folder:    state.folder,
-			PID:       state.pid,
-			StartedAt: state.startedAt.Format(time.RFC3339),
+func (l *listFlag) Set(v string) error {
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
 		}
-		state.mu.Unlock()
-		data, _ := json.Marshal(info)
-		_, _ = conn.Write(append(data, '\n'))
-		_ = conn.Close()
-
-	case strings.HasPrefix(line, "CTAP1 CONNECT "):
-		parts := strings.Fields(line)
-		if len(parts) != 4 {
-			_, _ = fmt.Fprintf(conn, "ERR invalid CONNECT syntax\n")
-			_ = conn.Close()
-			return
+	}
+	return nil
+}
+
+// accessPolicy builds the control socket policy: the session owner plus any
+// users and groups given by --allow-uid, --allow-group and --share-group.
+func accessPolicy(users, groups []string) (domain.AccessPolicy, error) {
+	policy := domain.AccessPolicy{UIDs: []int{os.Getuid()}}
+	for _, u := range users {
+		uid, err := lookupUID(u)
+		if err != nil {
+			return policy, fmt.Errorf("--allow-uid %q: %w", u, err)
 		}
-		clientCommit := parts[2]
-
-		// Send commit to relay main goroutine (only first CONNECT).
-		commitOnce.Do(func() {
-			state.mu.Lock()
-			state.commit = clientCommit
-			state.mu.Unlock()
-			commitCh <- clientCommit
-		})
-
-		// Reject mismatched commits — relay cannot switch versions.
-		state.mu.Lock()
-		established := state.commit
-		state.mu.Unlock()
-		if established != "" && clientCommit != established {
-			_, _ = fmt.Fprintf(conn, "ERR version mismatch: %s running in relay mode\n", established)
-			_ = conn.Close()
-			return
+		policy.UIDs = append(policy.UIDs, uid)
+	}
+	for _, g := range groups {
+		gid, err := lookupGID(g)
+		if err != nil {
+			return policy, fmt.Errorf("group %q: %w", g, err)
 		}
+		policy.GIDs = append(policy.GIDs, gid)
+	}
+	return policy, nil
+}
 
-		// Relay mode has no connection token — respond with empty token.
-		// Keep connection open as lease (extension expects it).
-		_ = conn.SetReadDeadline(time.Time{})
-		_, _ = fmt.Fprintf(conn, "OK\n")
-		log.Info("relay lease granted", "client", parts[3], "commit", clientCommit)
+// newStore returns the per-user socket store, shared with group if non-empty.
+func newStore(sockDir, group string) (*store.FileStore, error) {
+	if group == "" {
+		return store.NewFileStore(sockDir), nil
+	}
+	gid, err := lookupGID(group)
+	if err != nil {
+		return nil, fmt.Errorf("--share-group %q: %w", group, err)
+	}
+	return store.NewSharedFileStore(sockDir, gid), nil
+}
 
-		// Hold connection open until client disconnects.
-		buf := make([]byte, 1)
-		_, _ = conn.Read(buf)
-		_ = conn.Close()
+// lookupUID accepts a numeric uid or a user name from /etc/passwd.
+func lookupUID(u string) (int, error) {
+	if uid, err := strconv.Atoi(u); err == nil {
+		return uid, nil
+	}
+	usr, err := user.Lookup(u)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(usr.Uid)
+}
 
-	default:
-		_, _ = fmt.Fprintf(conn, "ERR unknown command\n")
-		_ = conn.Close()
+// lookupGID accepts a numeric gid or a group name from /etc/group.
+func lookupGID(g string) (int, error) {
+	if gid, err := strconv.Atoi(g); err == nil {
+		return gid, nil
+	}
+	grp, err := user.LookupGroup(g)
+	if err != nil {
+		return 0, err
 	}
+	return strconv.Atoi(grp.Gid)
 }
 
 func defaultName() string {
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"codetap/internal/adapter/ctap2"
+	"codetap/internal/adapter/peercred"
+	"codetap/internal/app"
+	"codetap/internal/domain"
+)
+
+// relayState holds metadata for a relay session's control socket.
+type relayState struct {
+	mu         sync.Mutex
+	name       string
+	commit     string
+	arch       string
+	folder     string
+	pid        int
+	startedAt  time.Time
+	socketPath string
+	access     domain.AccessPolicy
+	version    string          // codetap build
+	host       domain.HostInfo // machine running the relay, not the remote end
+	labels     map[string]string
+	leases     int // open CONNECT connections
+}
+
+// dataSocket returns the socket path clients connect to for a session.
+func (st *relayState) dataSocket() string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.socketPath
+}
+
+// addLease adjusts the number of leases reported by INFO.
+func (st *relayState) addLease(delta int) {
+	st.mu.Lock()
+	st.leases += delta
+	st.mu.Unlock()
+}
+
+// handleRelayCtlConn handles INFO, HEALTH and CONNECT on the relay's control
+// socket, or a CTAP2 session if the first line is "CTAP2".
+func handleRelayCtlConn(conn net.Conn, state *relayState, commitCh chan string, commitOnce *sync.Once, log domain.Logger) {
+	peer, authErr := peercred.Authorize(conn, state.access)
+	if authErr != nil {
+		log.Error("control connection refused", "pid", peer.PID, "uid", peer.UID, "gid", peer.GID, "err", authErr)
+		_, _ = fmt.Fprintf(conn, "ERR permission denied\n")
+		_ = conn.Close()
+		return
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	line = strings.TrimSpace(line)
+
+	switch {
+	case line == "CTAP1 INFO":
+		data, _ := json.Marshal(relayInfo(state))
+		_, _ = conn.Write(append(data, '\n'))
+		_ = conn.Close()
+
+	case line == "CTAP1 HEALTH":
+		data, _ := json.Marshal(relayHealth(state))
+		_, _ = conn.Write(append(data, '\n'))
+		_ = conn.Close()
+
+	case strings.HasPrefix(line, "CTAP1 CONNECT "):
+		// Trailing keepalive=<secs> and force options are accepted but have no
+		// effect: relay leases never block anything and the relay cannot
+		// switch versions, so there is nothing to ping or take over.
+		parts := strings.Fields(line)
+		if len(parts) < 4 || len(parts) > 6 {
+			_, _ = fmt.Fprintf(conn, "ERR invalid CONNECT syntax\n")
+			_ = conn.Close()
+			return
+		}
+
+		if cerr := relayConnect(state, parts[2], commitCh, commitOnce); cerr != nil {
+			_, _ = fmt.Fprintf(conn, "ERR %s\n", cerr.Message)
+			_ = conn.Close()
+			return
+		}
+
+		// Relay mode has no connection token — respond with empty token.
+		// Keep connection open as lease (extension expects it).
+		_ = conn.SetReadDeadline(time.Time{})
+		_, _ = fmt.Fprintf(conn, "OK\n")
+		log.Info("relay lease granted", "client", parts[3], "commit", parts[2], "pid", peer.PID, "uid", peer.UID)
+
+		// Hold connection open until client disconnects.
+		state.addLease(1)
+		buf := make([]byte, 1)
+		_, _ = conn.Read(buf)
+		_ = conn.Close()
+		state.addLease(-1)
+
+	case line == ctap2.Hello:
+		serveRelayCTAP2(conn, peer, reader, state, commitCh, commitOnce, log)
+
+	default:
+		_, _ = fmt.Fprintf(conn, "ERR unknown command\n")
+		_ = conn.Close()
+	}
+}
+
+// serveRelayCTAP2 speaks JSON-RPC 2.0 on a relay control connection. Leases
+// granted by "connect" last until the client closes the connection.
+func serveRelayCTAP2(conn net.Conn, peer domain.PeerCred, reader *bufio.Reader, state *relayState, commitCh chan string, commitOnce *sync.Once, log domain.Logger) {
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Time{})
+
+	leased := false
+	defer func() {
+		if leased {
+			state.addLease(-1)
+		}
+	}()
+
+	rc := ctap2.NewConn(reader, conn)
+	_ = rc.Notify("hello", app.HelloParams{Protocol: ctap2.Hello, Methods: app.CTAP2Methods})
+
+	for {
+		req, err := rc.ReadRequest()
+		if err != nil {
+			return
+		}
+
+		switch req.Method {
+		case "info":
+			_ = rc.Reply(req.ID, relayInfo(state))
+
+		case "health":
+			_ = rc.Reply(req.ID, relayHealth(state))
+
+		case "connect":
+			var p app.ConnectParams
+			if perr := ctap2.DecodeParams(req, &p); perr != nil {
+				_ = rc.ReplyError(req.ID, perr)
+				continue
+			}
+			if p.Commit == "" || p.ClientID == "" {
+				_ = rc.ReplyError(req.ID, ctap2.Errorf(ctap2.CodeInvalidParams, "connect requires commit and client_id"))
+				continue
+			}
+			if cerr := relayConnect(state, p.Commit, commitCh, commitOnce); cerr != nil {
+				_ = rc.ReplyError(req.ID, cerr)
+				continue
+			}
+			if !leased {
+				leased = true
+				state.addLease(1)
+			}
+			log.Info("relay lease granted", "client", p.ClientID, "commit", p.Commit, "pid", peer.PID, "uid", peer.UID)
+			_ = rc.Reply(req.ID, app.ConnectResult{Commit: p.Commit, Socket: state.dataSocket()})
+
+		default:
+			if !req.IsNotification() {
+				_ = rc.ReplyError(req.ID, ctap2.Errorf(ctap2.CodeMethodNotFound, "method %q not found", req.Method))
+			}
+		}
+	}
+}
+
+// relayConnect hands the first client's commit to the relay main goroutine
+// and rejects later clients that ask for a different commit.
+func relayConnect(state *relayState, clientCommit string, commitCh chan string, commitOnce *sync.Once) *ctap2.Error {
+	// Send commit to relay main goroutine (only first CONNECT).
+	commitOnce.Do(func() {
+		state.mu.Lock()
+		state.commit = clientCommit
+		state.mu.Unlock()
+		commitCh <- clientCommit
+	})
+
+	// Reject mismatched commits — relay cannot switch versions.
+	state.mu.Lock()
+	established, clients := state.commit, state.leases
+	state.mu.Unlock()
+	if established != "" && clientCommit != established {
+		return &ctap2.Error{
+			Code:    ctap2.CodeVersionMismatch,
+			Message: fmt.Sprintf("version mismatch: %s running in relay mode", established),
+			Data:    app.VersionMismatch{Commit: established, Clients: clients},
+		}
+	}
+	return nil
+}
+
+// relayInfo snapshots the relay session metadata served by INFO.
+func relayInfo(state *relayState) app.InfoResponse {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	status := domain.StatusRunning
+	if state.commit == "" {
+		// Remote is not spawned until the first CONNECT supplies a commit.
+		status = domain.StatusWaiting
+	}
+	return app.InfoResponse{
+		Name:      state.name,
+		Commit:    state.commit,
+		Arch:      state.arch,
+		Folder:    state.folder,
+		PID:       state.pid,
+		StartedAt: state.startedAt.Format(time.RFC3339),
+		Status:    status,
+		Version:   state.version,
+		Leases:    state.leases,
+		Labels:    state.labels,
+		HostInfo:  state.host,
+	}
+}
+
+// relayHealth reports readiness of a relay session. The remote code-server
+// process is not visible from the host, so the probe travels through the mux
+// to the remote data socket and stands in for the process check.
+func relayHealth(state *relayState) app.HealthResponse {
+	state.mu.Lock()
+	commit := state.commit
+	socketPath := state.socketPath
+	startedAt := state.startedAt
+	state.mu.Unlock()
+
+	health := app.HealthResponse{
+		UptimeS: int64(time.Since(startedAt).Seconds()),
+	}
+
+	if commit == "" {
+		// Remote is not spawned until the first CONNECT supplies a commit.
+		health.State = domain.HealthStarting
+		return health
+	}
+
+	latency, err := app.ProbeServer(socketPath)
+	health.ProbeMS = latency.Milliseconds()
+	health.SocketOK = err == nil
+	health.ProcessAlive = health.SocketOK
+	if err != nil {
+		health.State = domain.HealthDegraded
+		health.Error = err.Error()
+	} else {
+		health.State = domain.HealthReady
+	}
+	return health
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"codetap/internal/adapter/store"
+	"codetap/internal/app"
+	"codetap/internal/domain"
+)
+
+// highlightFor is how long a changed session stays highlighted in --watch.
+const highlightFor = 5 * time.Second
+
+// watchDebounce coalesces bursts of changes, such as a session creating its
+// control and data sockets, into one redraw.
+const watchDebounce = 100 * time.Millisecond
+
+// Changes highlighted by codetap list --watch.
+const (
+	changeNew       = "new"
+	changeDied      = "died"
+	changeRestarted = "restarted"
+	changeRemoved   = "removed"
+)
+
+// changeMarks are the row markers and ANSI colors for each change.
+var changeMarks = map[string]struct {
+	mark  string
+	color string
+}{
+	changeNew:       {"+", "\x1b[32m"},
+	changeDied:      {"x", "\x1b[31m"},
+	changeRestarted: {"~", "\x1b[33m"},
+	changeRemoved:   {"-", "\x1b[31m"},
+}
+
+type change struct {
+	kind  string
+	at    time.Time
+	entry domain.SocketEntry // last known entry, kept for removed sessions
+}
+
+// watchList redraws the session table until interrupted. A refresh happens
+// when a control socket appears or disappears (inotify on the socket
+// directory), when a live session sends a CTAP2 notification or drops its
+// subscription, and at least every interval to pick up health changes.
+func watchList(svc *app.Service, st *store.FileStore, filter func([]domain.SocketEntry) []domain.SocketEntry, interval time.Duration, log domain.Logger) {
+	trigger := make(chan struct{}, 1)
+	poke := func() {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+
+	// Only an existing socket directory is watched; listing must not create
+	// it. Until it appears, or if the watch stops, the ticker polls and
+	// retries the watch.
+	dirChanges, err := st.Watch(make(chan struct{}))
+	if err != nil {
+		log.Info("socket directory watch unavailable, polling", "interval", interval, "err", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	color := isTerminal(os.Stdout)
+	subs := map[string]func(){} // session name → stop subscription
+	changes := map[string]change{}
+	var prev map[string]domain.SocketEntry
+
+	for {
+		entries, err := svc.List()
+		if err != nil {
+			fatal(err)
+		}
+		entries = filter(entries)
+		now := time.Now()
+
+		cur := make(map[string]domain.SocketEntry, len(entries))
+		for _, e := range entries {
+			cur[e.Name] = e
+			if prev == nil {
+				continue // first draw: nothing to compare against
+			}
+			if kind := classifyChange(prev[e.Name], e); kind != "" {
+				changes[e.Name] = change{kind: kind, at: now}
+				time.AfterFunc(highlightFor, poke)
+			}
+		}
+		for name, p := range prev {
+			if _, ok := cur[name]; !ok {
+				p.Alive = false
+				changes[name] = change{kind: changeRemoved, at: now, entry: p}
+				time.AfterFunc(highlightFor, poke)
+			}
+		}
+
+		// Subscribe to live sessions; a notification or a dropped connection
+		// means something worth a refresh happened.
+		for name, e := range cur {
+			if _, ok := subs[name]; ok || !e.Alive {
+				continue
+			}
+			events, stop, err := app.SubscribeCtl(st.CtlSocketPath(name))
+			if err != nil {
+				continue
+			}
+			subs[name] = stop
+			go func() {
+				for range events {
+					poke()
+				}
+				poke()
+			}()
+		}
+		for name, stop := range subs {
+			if e, ok := cur[name]; !ok || !e.Alive {
+				stop()
+				delete(subs, name)
+			}
+		}
+
+		renderWatch(entries, changes, now, color)
+		prev = cur
+
+		select {
+		case <-trigger:
+		case _, ok := <-dirChanges:
+			if !ok {
+				log.Info("socket directory watch stopped, polling", "interval", interval)
+				dirChanges = nil
+			}
+		case <-ticker.C:
+			if dirChanges == nil {
+				dirChanges, _ = st.Watch(make(chan struct{}))
+			}
+		}
+		time.Sleep(watchDebounce)
+		select {
+		case <-trigger:
+		default:
+		}
+	}
+}
+
+// classifyChange compares a session with its previous state. A zero prev
+// means the session was not listed before.
+func classifyChange(prev, cur domain.SocketEntry) string {
+	switch {
+	case prev.Name == "":
+		return changeNew
+	case prev.Alive && !cur.Alive:
+		return changeDied
+	case !prev.Alive && cur.Alive:
+		return changeNew
+	case cur.Alive && (prev.Metadata.Commit != cur.Metadata.Commit ||
+		!prev.Metadata.StartedAt.Equal(cur.Metadata.StartedAt)):
+		return changeRestarted
+	}
+	return ""
+}
+
+// renderWatch clears the screen and draws the table with change markers.
+// Removed sessions stay listed until their highlight expires.
+func renderWatch(entries []domain.SocketEntry, changes map[string]change, now time.Time, color bool) {
+	rows := append([]domain.SocketEntry(nil), entries...)
+	var removed []domain.SocketEntry
+	for name, c := range changes {
+		switch {
+		case now.Sub(c.at) >= highlightFor:
+			delete(changes, name)
+		case c.kind == changeRemoved:
+			removed = append(removed, c.entry)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Name < removed[j].Name })
+	rows = append(rows, removed...)
+
+	marks := make([]string, len(rows))
+	for i, e := range rows {
+		marks[i] = " "
+		if c, ok := changes[e.Name]; ok {
+			marks[i] = changeMarks[c.kind].mark
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&buf, "codetap list --watch    %s    (+ new, x died, ~ restarted, - removed)\n\n", now.Format(time.TimeOnly))
+	if len(rows) == 0 {
+		buf.WriteString("No sessions found.\n")
+		_, _ = os.Stdout.Write(buf.Bytes())
+		return
+	}
+
+	var table bytes.Buffer
+	_ = writeTable(&table, rows, marks)
+	lines := strings.SplitAfter(table.String(), "\n")
+	for i, line := range lines {
+		// Line 0 is the header; line i is rows[i-1].
+		if color && i > 0 && i <= len(rows) {
+			if c, ok := changes[rows[i-1].Name]; ok {
+				line = changeMarks[c.kind].color + strings.TrimSuffix(line, "\n") + "\x1b[0m\n"
+			}
+		}
+		buf.WriteString(line)
+	}
+	_, _ = os.Stdout.Write(buf.Bytes())
+}
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -8,6 +8,8 @@ import { Session, SessionLocation } from './types';
 
 const AUTHORITY = 'codetap';
 const CLIENT_ID = `vscode-${crypto.randomBytes(4).toString('hex')}`;
+// Ask the server to ping lease connections so a suspended host's leases expire.
+const KEEPALIVE_SECS = 30;
 
 // Keep lease connections alive for the lifetime of the extension.
 const leases: net.Socket[] = [];
@@ -64,12 +66,12 @@ export function activate(context: vscode.ExtensionContext) {
 			}
 
 			try {
-				const { token, conn } = await ctapConnect(
+				const { token, socket, conn } = await ctapConnect(
 					session.ctlSocketPath,
 					session.metadata.commit,
 				);
 				leases.push(conn);
-				resolver.setSession(session.name, session.socketPath, token);
+				resolver.setSession(authorityName(session.name), socket ?? session.socketPath, token);
 			} catch (err: unknown) {
 				const msg = err instanceof Error ? err.message : String(err);
 				vscode.window.showErrorMessage(`CodeTap connect failed: ${msg}`);
@@ -78,7 +80,7 @@ export function activate(context: vscode.ExtensionContext) {
 
 			const uri = vscode.Uri.from({
 				scheme: 'vscode-remote',
-				authority: `${AUTHORITY}+${session.name}`,
+				authority: `${AUTHORITY}+${authorityName(session.name)}`,
 				path: folder,
 			});
 			await vscode.commands.executeCommand('vscode.openFolder', uri, {
@@ -93,6 +95,11 @@ export function activate(context: vscode.ExtensionContext) {
 	context.subscriptions.push({ dispose: () => watcher.stop() });
 }
 
+// Shared sessions are named "<uid>/<name>"; "/" is not valid in an authority.
+function authorityName(name: string): string {
+	return name.replace(/\//g, '-');
+}
+
 export function deactivate() {
 	for (const c of leases) c.destroy();
 	leases.length = 0;
@@ -100,27 +107,69 @@ export function deactivate() {
 
 /**
  * Send CTAP1 CONNECT to the control socket. Returns the token and keeps
- * the connection alive as a lease.
+ * the connection alive as a lease, answering the server's keepalive PINGs
+ * and surfacing TAKEOVER warnings.
+ * Servers that predate keepalive reject the extra field; retry without it.
  */
-function ctapConnect(
+async function ctapConnect(
+	ctlSocketPath: string,
+	commit: string,
+): Promise<{ token: string; socket?: string; conn: net.Socket }> {
+	try {
+		return await ctapConnectOnce(ctlSocketPath, commit, KEEPALIVE_SECS);
+	} catch (err: unknown) {
+		if (err instanceof Error && err.message === 'ERR invalid CONNECT syntax') {
+			return ctapConnectOnce(ctlSocketPath, commit, 0);
+		}
+		throw err;
+	}
+}
+
+function ctapConnectOnce(
 	ctlSocketPath: string,
 	commit: string,
-): Promise<{ token: string; conn: net.Socket }> {
+	keepalive: number,
+): Promise<{ token: string; socket?: string; conn: net.Socket }> {
 	return new Promise((resolve, reject) => {
 		const conn = net.createConnection(ctlSocketPath, () => {
-			conn.write(`CTAP1 CONNECT ${commit} ${CLIENT_ID}\n`);
+			const option = keepalive > 0 ? ` keepalive=${keepalive}` : '';
+			conn.write(`CTAP1 CONNECT ${commit} ${CLIENT_ID}${option}\n`);
 		});
 
 		let data = '';
 		conn.on('data', (chunk: Buffer) => {
 			data += chunk.toString();
-			if (data.includes('\n')) {
-				const line = data.trim();
+			const nl = data.indexOf('\n');
+			if (nl >= 0) {
+				const line = data.slice(0, nl).trim();
 				if (line.startsWith('OK')) {
-					const token = line.slice(3).trim();
+					const [, token = '', ...fields] = line.split(' ');
+					// Side-by-side servers answer with the data socket to use.
+					const socket = fields.find(f => f.startsWith('socket='))?.slice('socket='.length);
 					conn.removeAllListeners('data');
 					conn.removeAllListeners('timeout');
-					resolve({ token, conn });
+					conn.setTimeout(0);
+					// Keep any partial line until the rest of it arrives, starting
+					// with whatever followed the OK line in this chunk.
+					let pending = '';
+					const onLeaseData = (text: string) => {
+						pending += text;
+						const msgs = pending.split('\n');
+						pending = msgs.pop() ?? '';
+						for (const msg of msgs.map(m => m.trim())) {
+							if (msg === 'PING') {
+								conn.write('PONG\n');
+							} else if (msg.startsWith('TAKEOVER ')) {
+								const [, newCommit, by, grace] = msg.split(' ');
+								vscode.window.showWarningMessage(
+									`CodeTap: ${by} is switching this session to ${newCommit.slice(0, 12)}; disconnecting in ${grace}s.`,
+								);
+							}
+						}
+					};
+					conn.on('data', (chunk: Buffer) => onLeaseData(chunk.toString()));
+					onLeaseData(data.slice(nl + 1));
+					resolve({ token, socket, conn });
 				} else {
 					conn.destroy();
 					reject(new Error(line));
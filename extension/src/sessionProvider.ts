@@ -21,15 +21,37 @@ export class SessionProvider implements vscode.TreeDataProvider<Session> {
 		);
 		const locationTag = session.location === 'remote' ? '$(remote) ' : '';
 		item.description = `${locationTag}${session.metadata.folder}`;
-		item.tooltip = [
+		const meta = session.metadata;
+		const lines = [
 			`Name: ${session.name}`,
 			`Location: ${session.location}`,
-			`Commit: ${session.metadata.commit}`,
-			`Arch: ${session.metadata.arch}`,
-			`PID: ${session.metadata.pid}`,
-			`Folder: ${session.metadata.folder}`,
-			`Status: ${session.alive ? 'alive' : 'dead'}`,
-		].join('\n');
+			`Commit: ${meta.commit}`,
+			`Arch: ${meta.arch}`,
+			`PID: ${meta.pid}`,
+			`Folder: ${meta.folder}`,
+			`Status: ${session.alive ? meta.status ?? 'alive' : 'dead'}`,
+		];
+		if (meta.leases !== undefined) {
+			lines.push(`Clients: ${meta.leases}`);
+		}
+		if (meta.user || meta.hostname) {
+			lines.push(`User: ${meta.user ?? meta.uid}@${meta.hostname ?? '?'}`);
+		}
+		if (meta.os_id) {
+			lines.push(`OS: ${meta.os_id} ${meta.os_version ?? ''}`.trimEnd());
+		}
+		if (meta.container_runtime) {
+			const id = meta.container_id ? ` ${meta.container_id.slice(0, 12)}` : '';
+			lines.push(`Container: ${meta.container_runtime}${id}`);
+		}
+		const labels = Object.entries(meta.labels ?? {});
+		if (labels.length > 0) {
+			lines.push(`Labels: ${labels.map(([k, v]) => `${k}=${v}`).join(', ')}`);
+		}
+		if (meta.version) {
+			lines.push(`codetap: ${meta.version}`);
+		}
+		item.tooltip = lines.join('\n');
 		item.iconPath = new vscode.ThemeIcon(
 			session.alive ? 'circle-filled' : 'circle-outline'
 		);
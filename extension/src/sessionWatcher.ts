@@ -28,36 +28,63 @@ export class SessionWatcher {
 
 	async getSessions(): Promise<Session[]> {
 		const sessions: Session[] = [];
-		let files: string[];
-		try {
-			files = fs.readdirSync(this.socketDir).filter(f => f.endsWith('.ctl.sock'));
-		} catch {
-			return sessions;
-		}
-
-		for (const file of files) {
-			const name = file.replace(/\.ctl\.sock$/, '');
-			const ctlSocketPath = path.join(this.socketDir, file);
-			const socketPath = path.join(this.socketDir, name + '.sock');
+		for (const { dir, prefix } of this.sessionDirs()) {
+			let files: string[];
 			try {
-				const metadata = await this.queryInfo(ctlSocketPath);
-				sessions.push({ name, socketPath, ctlSocketPath, metadata, alive: true, location: this.location });
+				files = fs.readdirSync(dir).filter(f => f.endsWith('.ctl.sock'));
 			} catch {
-				// Control socket exists but not responding — dead session.
-				sessions.push({
-					name,
-					socketPath,
-					ctlSocketPath,
-					metadata: { name, commit: '', arch: '', folder: '', pid: 0, started_at: '' },
-					alive: false,
-					location: this.location,
-				});
+				continue;
+			}
+
+			for (const file of files) {
+				const name = prefix + file.replace(/\.ctl\.sock$/, '');
+				const ctlSocketPath = path.join(dir, file);
+				const socketPath = path.join(dir, file.replace(/\.ctl\.sock$/, '.sock'));
+				try {
+					const metadata = await this.queryInfo(ctlSocketPath);
+					sessions.push({ name, socketPath, ctlSocketPath, metadata, alive: true, location: this.location });
+				} catch {
+					// Control socket exists but not responding — dead session.
+					sessions.push({
+						name,
+						socketPath,
+						ctlSocketPath,
+						metadata: { name, commit: '', arch: '', folder: '', pid: 0, started_at: '' },
+						alive: false,
+						location: this.location,
+					});
+				}
 			}
 		}
 
 		return sessions;
 	}
 
+	/**
+	 * Directories to scan: our own <socketDir>/<uid>, other users' directories
+	 * shared with a group (names qualified as "<uid>/<name>"), and the base
+	 * directory itself for sessions started by older codetap versions.
+	 */
+	private sessionDirs(): { dir: string; prefix: string }[] {
+		const own = String(process.getuid?.() ?? '');
+		const dirs = [{ dir: path.join(this.socketDir, own), prefix: '' }];
+		try {
+			for (const entry of fs.readdirSync(this.socketDir, { withFileTypes: true })) {
+				if (!entry.isDirectory() || !/^\d+$/.test(entry.name) || entry.name === own) {
+					continue;
+				}
+				const dir = path.join(this.socketDir, entry.name);
+				if ((fs.statSync(dir).mode & 0o050) === 0o050) {
+					dirs.push({ dir, prefix: entry.name + '/' });
+				}
+			}
+		} catch {
+			// Base directory missing — nothing started yet.
+		}
+		dirs.push({ dir: this.socketDir, prefix: '' });
+		return dirs;
+	}
+
 	private queryInfo(ctlSocketPath: string): Promise<SessionMetadata> {
 		return new Promise((resolve, reject) => {
 			const conn = net.createConnection(ctlSocketPath, () => {
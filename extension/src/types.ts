@@ -5,6 +5,18 @@ export interface SessionMetadata {
 	folder: string;
 	pid: number;
 	started_at: string;
+	// Fields below are absent for sessions started by older codetap builds.
+	status?: string;
+	version?: string;
+	leases?: number;
+	hostname?: string;
+	uid?: number;
+	user?: string;
+	os_id?: string;
+	os_version?: string;
+	container_runtime?: string;
+	container_id?: string;
+	labels?: Record<string, string>;
 }
 
 export type SessionLocation = 'local' | 'remote';
@@ -0,0 +1,204 @@
+// Package bundle reads and writes offline bundles, which carry VS Code
+// Server tarballs to air-gapped machines. A bundle is an uncompressed tar
+// archive: manifest.json first, then the server tarballs, then a SHA256SUMS
+// file so that an unpacked bundle can also be checked with "sha256sum -c".
+package bundle
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Format is the manifest format version this package writes and reads.
+const Format = 1
+
+const (
+	manifestName = "manifest.json"
+	sumsName     = "SHA256SUMS"
+)
+
+// Manifest describes the contents of a bundle.
+type Manifest struct {
+	Format  int       `json:"format"`
+	Created time.Time `json:"created"`
+	Codetap string    `json:"codetap,omitempty"` // version of the codetap that wrote it
+	Servers []Server  `json:"servers"`
+}
+
+// Server is one server tarball in a bundle.
+type Server struct {
+	Commit   string `json:"commit"`
+	Artifact string `json:"artifact"`
+	File     string `json:"file"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+	// Unverified marks a tarball that was never checked against the
+	// checksum the update API publishes; SHA256 only guards the transfer.
+	Unverified bool `json:"unverified,omitempty"`
+	// Path is the local tarball Write packs; it is not part of the bundle.
+	Path string `json:"-"`
+}
+
+// Write packs the servers of m into a bundle written to w, reading each
+// from its Path. Size and SHA256 are filled in where they are missing.
+func Write(w io.Writer, m Manifest) error {
+	m.Format = Format
+	for i := range m.Servers {
+		s := &m.Servers[i]
+		if s.File == "" {
+			s.File = filepath.Base(s.Path)
+		}
+		if err := checkFile(s.File); err != nil {
+			return err
+		}
+		info, err := os.Stat(s.Path)
+		if err != nil {
+			return err
+		}
+		s.Size = info.Size()
+		if s.SHA256 == "" {
+			if s.SHA256, err = fileSHA256(s.Path); err != nil {
+				return err
+			}
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeBytes(tw, manifestName, append(manifest, '\n'), m.Created); err != nil {
+		return err
+	}
+	var sums strings.Builder
+	for _, s := range m.Servers {
+		if err := writeFile(tw, s, m.Created); err != nil {
+			return err
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", s.SHA256, s.File)
+	}
+	if err := writeBytes(tw, sumsName, []byte(sums.String()), m.Created); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func writeBytes(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func writeFile(tw *tar.Writer, s Server, modTime time.Time) error {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hdr := &tar.Header{Name: s.File, Mode: 0o644, Size: s.Size, ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("pack %s: %w", s.File, err)
+	}
+	return nil
+}
+
+// Read unpacks a bundle from r, calling fn with every server tarball the
+// manifest lists and its contents. fn is responsible for checking the
+// contents against Server.SHA256. Read fails if the bundle holds a file the
+// manifest does not list or lacks one that it does.
+func Read(r io.Reader, fn func(Server, io.Reader) error) (Manifest, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return Manifest{}, fmt.Errorf("not a codetap bundle: %w", err)
+	}
+	if hdr.Name != manifestName {
+		return Manifest{}, fmt.Errorf("not a codetap bundle: starts with %q instead of %s", hdr.Name, manifestName)
+	}
+	var m Manifest
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return Manifest{}, fmt.Errorf("read %s: %w", manifestName, err)
+	}
+	if m.Format != Format {
+		return m, fmt.Errorf("unsupported bundle format %d, this codetap reads format %d", m.Format, Format)
+	}
+
+	pending := map[string]Server{}
+	for _, s := range m.Servers {
+		if err := checkFile(s.File); err != nil {
+			return m, err
+		}
+		s.SHA256 = strings.ToLower(s.SHA256)
+		pending[s.File] = s
+	}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return m, fmt.Errorf("read bundle: %w", err)
+		}
+		if hdr.Name == sumsName {
+			continue
+		}
+		s, ok := pending[hdr.Name]
+		if !ok {
+			return m, fmt.Errorf("bundle holds %q, which its manifest does not list", hdr.Name)
+		}
+		if hdr.Size != s.Size {
+			return m, fmt.Errorf("bundle holds %d bytes of %s, manifest says %d", hdr.Size, s.File, s.Size)
+		}
+		delete(pending, hdr.Name)
+		if err := fn(s, tr); err != nil {
+			return m, fmt.Errorf("%s: %w", s.File, err)
+		}
+	}
+	if len(pending) > 0 {
+		var missing []string
+		for name := range pending {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return m, fmt.Errorf("bundle is missing %s, which its manifest lists", strings.Join(missing, ", "))
+	}
+	return m, nil
+}
+
+// checkFile refuses manifest file names that are not plain file names.
+func checkFile(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || name == manifestName || name == sumsName {
+		return fmt.Errorf("invalid file name %q in bundle", name)
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
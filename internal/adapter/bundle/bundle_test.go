@@ -0,0 +1,137 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTarball(t *testing.T, dir, name, body string) Server {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return Server{Commit: strings.Repeat("a", 40), Artifact: strings.TrimSuffix(name[41:], ".tar.gz"), Path: path}
+}
+
+func sum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func TestWriteRead_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	commit := strings.Repeat("a", 40)
+	x64 := writeTarball(t, dir, commit+"-server-linux-x64.tar.gz", "x64 server")
+	arm := writeTarball(t, dir, commit+"-server-linux-arm64.tar.gz", "arm64 server")
+	x64.Unverified = true
+	arm.SHA256 = sum("arm64 server") // as recorded at download time
+
+	var buf bytes.Buffer
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := Write(&buf, Manifest{Created: created, Codetap: "v1.2.3", Servers: []Server{x64, arm}}); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+
+	got := map[string]string{}
+	m, err := Read(bytes.NewReader(buf.Bytes()), func(s Server, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if s.SHA256 != sum(string(data)) {
+			t.Errorf("%s: manifest sha256 %s does not match its contents", s.File, s.SHA256)
+		}
+		if s.Unverified != (s.Artifact == "server-linux-x64") {
+			t.Errorf("%s: unverified = %v", s.File, s.Unverified)
+		}
+		got[s.Artifact] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if m.Format != Format || !m.Created.Equal(created) || m.Codetap != "v1.2.3" || len(m.Servers) != 2 {
+		t.Errorf("manifest = %+v", m)
+	}
+	if got["server-linux-x64"] != "x64 server" || got["server-linux-arm64"] != "arm64 server" {
+		t.Errorf("read servers = %v", got)
+	}
+
+	// SHA256SUMS lets an unpacked bundle be checked with sha256sum -c.
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	var names []string
+	var sums string
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+		if hdr.Name == sumsName {
+			data, _ := io.ReadAll(tr)
+			sums = string(data)
+		}
+	}
+	if names[0] != manifestName || names[len(names)-1] != sumsName {
+		t.Errorf("bundle entries = %v, want manifest first and SHA256SUMS last", names)
+	}
+	if want := sum("x64 server") + "  " + filepath.Base(x64.Path) + "\n"; !strings.Contains(sums, want) {
+		t.Errorf("SHA256SUMS = %q, want a line %q", sums, want)
+	}
+}
+
+func TestRead_RefusesMismatchedBundles(t *testing.T) {
+	dir := t.TempDir()
+	commit := strings.Repeat("a", 40)
+	server := writeTarball(t, dir, commit+"-server-linux-x64.tar.gz", "x64 server")
+
+	build := func(mutate func(tw *tar.Writer)) []byte {
+		var valid bytes.Buffer
+		if err := Write(&valid, Manifest{Servers: []Server{server}}); err != nil {
+			t.Fatal(err)
+		}
+		// Copy the valid bundle, letting mutate add or drop entries.
+		var out bytes.Buffer
+		tw := tar.NewWriter(&out)
+		tr := tar.NewReader(&valid)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			if mutate == nil || hdr.Name != filepath.Base(server.Path) {
+				_ = tw.WriteHeader(hdr)
+				_, _ = io.Copy(tw, tr)
+			}
+		}
+		if mutate != nil {
+			mutate(tw)
+		}
+		_ = tw.Close()
+		return out.Bytes()
+	}
+
+	extra := func(tw *tar.Writer) {
+		_ = tw.WriteHeader(&tar.Header{Name: "../evil", Mode: 0o644, Size: 1, Typeflag: tar.TypeReg})
+		_, _ = tw.Write([]byte("x"))
+	}
+	cases := map[string][]byte{
+		"not a bundle":   []byte("plain text"),
+		"missing server": build(func(*tar.Writer) {}),
+		"unlisted file":  build(extra),
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Read(bytes.NewReader(data), func(Server, io.Reader) error { return nil })
+			if err == nil {
+				t.Error("Read() accepted the bundle")
+			}
+		})
+	}
+}
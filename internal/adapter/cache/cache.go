@@ -0,0 +1,274 @@
+// Package cache lists and removes provisioned VS Code Servers: extracted
+// servers under the repository directory and tarballs under the download
+// cache directory.
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"codetap/internal/adapter/flock"
+	"codetap/internal/domain"
+)
+
+// lastUsedFile is written into an extracted server's directory by MarkUsed.
+const lastUsedFile = ".codetap-last-used"
+
+var commitRe = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// Cache implements domain.ServerCache on the directory layout used by
+// TarExtractor (<repoDir>/<commit>/) and HTTPDownloader
+// (<tarballDir>/<commit>-<artifact>.tar.gz).
+type Cache struct {
+	repoDir    string
+	tarballDir string
+}
+
+// New creates a cache over the given repository and tarball directories.
+func New(repoDir, tarballDir string) *Cache {
+	return &Cache{repoDir: repoDir, tarballDir: tarballDir}
+}
+
+// MarkUsed records the current time as the commit's last use. A server that
+// is not in the repository, because it runs from a read-only layer, is not
+// tracked.
+func (c *Cache) MarkUsed(commit string) error {
+	stamp := time.Now().UTC().Format(time.RFC3339) + "\n"
+	err := os.WriteFile(filepath.Join(c.repoDir, commit, lastUsedFile), []byte(stamp), 0o644)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Use takes a shared lock on the commit's .use-<commit> file in the
+// repository for as long as a session runs the server. Sessions that cannot
+// see each other's sockets, in another socket directory or a container
+// sharing only $HOME, still see each other's locks.
+func (c *Cache) Use(commit string) (func(), error) {
+	if !commitRe.MatchString(commit) {
+		return nil, fmt.Errorf("invalid commit %q", commit)
+	}
+	if err := os.MkdirAll(c.repoDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create repository: %w", err)
+	}
+	l, err := flock.AcquireShared(c.useLock(commit))
+	if err != nil {
+		return nil, err
+	}
+	return func() { _ = l.Release() }, nil
+}
+
+func (c *Cache) useLock(commit string) string {
+	return filepath.Join(c.repoDir, ".use-"+commit)
+}
+
+// Entries returns every cached commit, most recently used first.
+func (c *Cache) Entries() ([]domain.CacheEntry, error) {
+	byCommit := map[string]*domain.CacheEntry{}
+	get := func(commit string) *domain.CacheEntry {
+		e, ok := byCommit[commit]
+		if !ok {
+			e = &domain.CacheEntry{Commit: commit}
+			byCommit[commit] = e
+		}
+		return e
+	}
+
+	dirs, err := os.ReadDir(c.repoDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read repository: %w", err)
+	}
+	for _, d := range dirs {
+		if !d.IsDir() || !commitRe.MatchString(d.Name()) {
+			continue
+		}
+		dir := filepath.Join(c.repoDir, d.Name())
+		e := get(d.Name())
+		e.Extracted = true
+		e.Version = serverVersion(dir)
+		e.Size += dirSize(dir)
+		e.LastUsed = lastUsed(dir)
+	}
+
+	tarballs, err := os.ReadDir(c.tarballDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read tarball cache: %w", err)
+	}
+	for _, f := range tarballs {
+		commit, ok := tarballCommit(f.Name())
+		if !ok || f.IsDir() {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		e := get(commit)
+		e.Size += info.Size()
+		e.TarballSize += info.Size()
+		if !e.Extracted && info.ModTime().After(e.LastUsed) {
+			e.LastUsed = info.ModTime()
+		}
+	}
+
+	entries := make([]domain.CacheEntry, 0, len(byCommit))
+	for _, e := range byCommit {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].LastUsed.Equal(entries[j].LastUsed) {
+			return entries[i].LastUsed.After(entries[j].LastUsed)
+		}
+		return entries[i].Commit < entries[j].Commit
+	})
+	return entries, nil
+}
+
+// Remove deletes the extracted server and all tarballs, checksums and
+// partial downloads of commit. It takes the locks that downloading and
+// extracting the commit hold, and fails instead of removing files another
+// process is provisioning. A commit that a session holds with Use fails
+// with domain.ErrServerInUse.
+func (c *Cache) Remove(commit string) error {
+	if !commitRe.MatchString(commit) {
+		return fmt.Errorf("invalid commit %q", commit)
+	}
+	if _, err := os.Stat(c.repoDir); err == nil {
+		use, ok, err := flock.TryAcquire(c.useLock(commit))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("server %s: %w", commit[:12], domain.ErrServerInUse)
+		}
+		defer use.Remove() // sessions waiting in Use lock a new file
+	}
+	files, _ := os.ReadDir(c.tarballDir)
+	artifacts := map[string]bool{}
+	for _, f := range files {
+		if artifact, ok := strings.CutPrefix(f.Name(), ".lock-"+commit+"-"); ok {
+			artifacts[artifact] = true
+		} else if got, artifact, ok := tarballBuild(f.Name()); ok && got == commit {
+			artifacts[artifact] = true
+		}
+	}
+	locks := []string{filepath.Join(c.repoDir, ".lock-"+commit)}
+	for artifact := range artifacts {
+		locks = append(locks, filepath.Join(c.tarballDir, ".lock-"+commit+"-"+artifact))
+	}
+	for _, path := range locks {
+		if _, err := os.Stat(filepath.Dir(path)); err != nil {
+			continue // nothing was ever provisioned there
+		}
+		l, ok, err := flock.TryAcquire(path)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("server %s is being provisioned by another process", commit[:12])
+		}
+		defer l.Remove() // held until the files are gone, then removed with them
+	}
+
+	dir := filepath.Join(c.repoDir, commit)
+	// Extraction preserves read-only directory modes, which would stop
+	// RemoveAll for anyone but root.
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			_ = os.Chmod(path, 0o755)
+		}
+		return nil
+	})
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("remove server %s: %w", commit, err)
+	}
+
+	for _, f := range files {
+		// Only builds whose lock is held: a download of another artifact
+		// may have started since the directory was read.
+		if got, artifact, ok := tarballBuild(f.Name()); ok && got == commit && artifacts[artifact] {
+			if err := os.Remove(filepath.Join(c.tarballDir, f.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("remove tarball: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// tarballCommit extracts the commit from a tarball cache file name:
+// <commit>-<artifact>.tar.gz, its .sha256 and its .download- partial file.
+func tarballCommit(name string) (string, bool) {
+	commit, _, ok := tarballBuild(name)
+	return commit, ok
+}
+
+// tarballBuild is tarballCommit that also returns the artifact.
+func tarballBuild(name string) (commit, artifact string, ok bool) {
+	name = strings.TrimPrefix(name, ".download-")
+	if len(name) < 41 || name[40] != '-' {
+		return "", "", false
+	}
+	artifact, _, ok = strings.Cut(name[41:], ".tar.gz")
+	commit = name[:40]
+	if !ok || artifact == "" || !commitRe.MatchString(commit) {
+		return "", "", false
+	}
+	return commit, artifact, true
+}
+
+// serverVersion reads the VS Code version from an extracted server's
+// package.json, falling back to product.json.
+func serverVersion(dir string) string {
+	for _, name := range []string{"package.json", "product.json"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		var v struct {
+			Version string `json:"version"`
+		}
+		if json.Unmarshal(data, &v) == nil && v.Version != "" {
+			return v.Version
+		}
+	}
+	return ""
+}
+
+// lastUsed returns the time recorded by MarkUsed, or the directory's
+// modification time for servers extracted before it existed.
+func lastUsed(dir string) time.Time {
+	if data, err := os.ReadFile(filepath.Join(dir, lastUsedFile)); err == nil {
+		if t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data))); err == nil {
+			return t
+		}
+	}
+	if info, err := os.Stat(dir); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
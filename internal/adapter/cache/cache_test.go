@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"codetap/internal/adapter/flock"
+	"codetap/internal/domain"
+)
+
+var (
+	commitA = strings.Repeat("a", 40)
+	commitB = strings.Repeat("b", 40)
+	commitC = strings.Repeat("c", 40)
+)
+
+func write(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEntries_MergesServersAndTarballs(t *testing.T) {
+	root := t.TempDir()
+	repo, tarballs := filepath.Join(root, "repository"), filepath.Join(root, "cache")
+	c := New(repo, tarballs)
+
+	write(t, filepath.Join(repo, commitA, "package.json"), `{"version":"1.109.5"}`)
+	write(t, filepath.Join(repo, commitA, "bin", "code-server"), "0123456789")
+	write(t, filepath.Join(tarballs, commitA+"-server-linux-x64.tar.gz"), "tarball")
+	write(t, filepath.Join(tarballs, commitA+"-server-linux-x64.tar.gz.sha256"), "sum")
+	write(t, filepath.Join(tarballs, ".download-"+commitB+"-server-linux-x64.tar.gz"), "part")
+	write(t, filepath.Join(repo, commitC, "product.json"), `{"version":"1.108.0"}`)
+	write(t, filepath.Join(repo, ".extract-123", "junk"), "x")
+	write(t, filepath.Join(repo, "not-a-commit", "junk"), "x")
+
+	old := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(filepath.Join(repo, commitC), old, old); err != nil {
+		t.Fatal(err)
+	}
+	partial := old.Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(tarballs, ".download-"+commitB+"-server-linux-x64.tar.gz"), partial, partial); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.MarkUsed(commitA); err != nil {
+		t.Fatalf("MarkUsed() error: %v", err)
+	}
+
+	entries, err := c.Entries()
+	if err != nil {
+		t.Fatalf("Entries() error: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Entries() = %+v, want 3 commits", entries)
+	}
+	a := entries[0]
+	if a.Commit != commitA || a.Version != "1.109.5" || !a.Extracted {
+		t.Errorf("most recent entry = %+v, want extracted %s at 1.109.5", a, commitA)
+	}
+	if a.TarballSize != int64(len("tarball")+len("sum")) || a.Size <= a.TarballSize {
+		t.Errorf("sizes = %d total, %d tarball", a.Size, a.TarballSize)
+	}
+	if time.Since(a.LastUsed) > time.Minute {
+		t.Errorf("LastUsed = %v, want the MarkUsed time", a.LastUsed)
+	}
+	last := entries[2]
+	if last.Commit != commitC || last.Version != "1.108.0" || !last.LastUsed.Equal(old) {
+		t.Errorf("oldest entry = %+v, want %s last used at the directory mtime", last, commitC)
+	}
+	if entries[1].Commit != commitB || entries[1].Extracted {
+		t.Errorf("partial download entry = %+v", entries[1])
+	}
+}
+
+func TestRemove_DeletesServerAndTarballs(t *testing.T) {
+	root := t.TempDir()
+	repo, tarballs := filepath.Join(root, "repository"), filepath.Join(root, "cache")
+	c := New(repo, tarballs)
+
+	write(t, filepath.Join(repo, commitA, "bin", "code-server"), "x")
+	if err := os.Chmod(filepath.Join(repo, commitA, "bin"), 0o555); err != nil {
+		t.Fatal(err)
+	}
+	write(t, filepath.Join(tarballs, commitA+"-server-linux-x64.tar.gz"), "t")
+	write(t, filepath.Join(tarballs, commitA+"-server-linux-x64.tar.gz.sha256"), "s")
+	write(t, filepath.Join(tarballs, commitB+"-server-linux-x64.tar.gz"), "t")
+
+	if err := c.Remove(commitA); err != nil {
+		t.Fatalf("Remove() error: %v", err)
+	}
+	entries, _ := c.Entries()
+	if len(entries) != 1 || entries[0].Commit != commitB {
+		t.Errorf("Entries() after Remove = %+v, want only %s", entries, commitB)
+	}
+	if err := c.Remove("../etc"); err == nil {
+		t.Error("Remove() accepted a path instead of a commit")
+	}
+}
+
+func TestMarkUsed_IgnoresServersOutsideRepository(t *testing.T) {
+	root := t.TempDir()
+	c := New(filepath.Join(root, "repository"), filepath.Join(root, "cache"))
+	if err := c.MarkUsed(commitA); err != nil {
+		t.Errorf("MarkUsed() error = %v for a server in a read-only layer", err)
+	}
+	if entries, _ := c.Entries(); len(entries) != 0 {
+		t.Errorf("Entries() = %+v, want none", entries)
+	}
+}
+
+func TestRemove_RefusesServersBeingProvisioned(t *testing.T) {
+	root := t.TempDir()
+	repo, tarballs := filepath.Join(root, "repository"), filepath.Join(root, "cache")
+	c := New(repo, tarballs)
+	write(t, filepath.Join(repo, commitA, "bin", "code-server"), "x")
+	write(t, filepath.Join(tarballs, ".download-"+commitA+"-server-linux-x64.tar.gz"), "partial")
+
+	for _, path := range []string{
+		filepath.Join(tarballs, ".lock-"+commitA+"-server-linux-x64"),
+		filepath.Join(repo, ".lock-"+commitA),
+	} {
+		l, err := flock.Acquire(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Remove(commitA); err == nil {
+			t.Errorf("Remove() succeeded while %s was held", filepath.Base(path))
+		}
+		l.Release()
+	}
+	entries, _ := c.Entries()
+	if len(entries) != 1 || !entries[0].Extracted {
+		t.Fatalf("Entries() = %+v, want the server untouched", entries)
+	}
+
+	if err := c.Remove(commitA); err != nil {
+		t.Fatalf("Remove() error once the locks are free: %v", err)
+	}
+	if entries, _ := c.Entries(); len(entries) != 0 {
+		t.Errorf("Entries() after Remove = %+v, want none", entries)
+	}
+	for _, path := range []string{
+		filepath.Join(tarballs, ".lock-"+commitA+"-server-linux-x64"),
+		filepath.Join(repo, ".lock-"+commitA),
+	} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("lock %s of a removed server left behind: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+func TestRemove_RefusesServersInUse(t *testing.T) {
+	root := t.TempDir()
+	repo, tarballs := filepath.Join(root, "repository"), filepath.Join(root, "cache")
+	c := New(repo, tarballs)
+	write(t, filepath.Join(repo, commitA, "bin", "code-server"), "x")
+
+	// Two sessions, which need not see each other's sockets, run it.
+	release1, err := c.Use(commitA)
+	if err != nil {
+		t.Fatalf("Use() error: %v", err)
+	}
+	release2, err := New(repo, tarballs).Use(commitA)
+	if err != nil {
+		t.Fatalf("second Use() error: %v", err)
+	}
+
+	release1()
+	if err := c.Remove(commitA); !errors.Is(err, domain.ErrServerInUse) {
+		t.Errorf("Remove() while in use error = %v, want ErrServerInUse", err)
+	}
+	release2()
+	if err := c.Remove(commitA); err != nil {
+		t.Fatalf("Remove() error once released: %v", err)
+	}
+	if entries, _ := c.Entries(); len(entries) != 0 {
+		t.Errorf("Entries() after Remove = %+v, want none", entries)
+	}
+	if _, err := os.Stat(filepath.Join(repo, ".use-"+commitA)); !os.IsNotExist(err) {
+		t.Errorf("use lock of a removed server left behind: %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package commit
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -31,12 +32,32 @@ type Resolver struct {
 	baseURL string
 }
 
+// Options configures a Resolver.
+type Options struct {
+	// BaseURL is the update API to query, e.g. an internal mirror. Empty
+	// means Microsoft's.
+	BaseURL string
+	// TLS configures trusted CAs and client certificates; nil uses the
+	// system defaults.
+	TLS *tls.Config
+}
+
 // NewResolver creates a Resolver for the given architecture (x64 or arm64).
-func NewResolver(arch string) *Resolver {
+func NewResolver(arch string, opts Options) *Resolver {
+	baseURL := strings.TrimRight(opts.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+	client := &http.Client{Timeout: 15 * time.Second}
+	if opts.TLS != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = opts.TLS
+		client.Transport = transport
+	}
 	return &Resolver{
 		arch:    arch,
-		baseURL: defaultBaseURL,
-		client:  &http.Client{Timeout: 15 * time.Second},
+		baseURL: baseURL,
+		client:  client,
 	}
 }
 
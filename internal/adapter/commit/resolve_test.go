@@ -11,7 +11,7 @@ import (
 const testCommit = "abc123def456abc123def456abc123def456abc1"
 
 func TestResolve_HexHash(t *testing.T) {
-	r := NewResolver("x64")
+	r := NewResolver("x64", Options{})
 	got, err := r.Resolve(testCommit)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -22,7 +22,7 @@ func TestResolve_HexHash(t *testing.T) {
 }
 
 func TestResolve_HexHash_Uppercase(t *testing.T) {
-	r := NewResolver("x64")
+	r := NewResolver("x64", Options{})
 	upper := strings.ToUpper(testCommit)
 	got, err := r.Resolve(upper)
 	if err != nil {
@@ -34,7 +34,7 @@ func TestResolve_HexHash_Uppercase(t *testing.T) {
 }
 
 func TestResolve_Empty(t *testing.T) {
-	r := NewResolver("x64")
+	r := NewResolver("x64", Options{})
 	got, err := r.Resolve("")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -45,7 +45,7 @@ func TestResolve_Empty(t *testing.T) {
 }
 
 func TestResolve_Whitespace(t *testing.T) {
-	r := NewResolver("x64")
+	r := NewResolver("x64", Options{})
 	got, err := r.Resolve("  \n  ")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -56,7 +56,7 @@ func TestResolve_Whitespace(t *testing.T) {
 }
 
 func TestResolve_InvalidFormat(t *testing.T) {
-	r := NewResolver("x64")
+	r := NewResolver("x64", Options{})
 	_, err := r.Resolve("not-a-commit")
 	if err == nil {
 		t.Fatal("expected error for invalid format")
@@ -90,6 +90,25 @@ func TestResolve_Semver(t *testing.T) {
 	}
 }
 
+func TestResolve_MirrorBaseURL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/vscode-update/api/update/server-linux-x64/stable/1.109.5" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		_ = json.NewEncoder(w).Encode(updateResponse{Version: testCommit})
+	}))
+	defer ts.Close()
+
+	r := NewResolver("x64", Options{BaseURL: ts.URL + "/vscode-update/"})
+	got, err := r.Resolve("1.109.5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != testCommit {
+		t.Errorf("got %q, want %q", got, testCommit)
+	}
+}
+
 func TestResolve_Latest(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !strings.Contains(r.URL.Path, "0000000000000000000000000000000000000000") {
@@ -0,0 +1,180 @@
+// Package ctap2 implements the CTAP2 control protocol: line-delimited
+// JSON-RPC 2.0 over a codetap control socket.
+//
+// A client selects CTAP2 by sending the line "CTAP2" as the first line on a
+// fresh .ctl.sock connection. The server answers with a "hello" notification,
+// after which each line in either direction is one JSON-RPC message.
+package ctap2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Hello is the first line a client sends to select CTAP2.
+const Hello = "CTAP2"
+
+// Standard JSON-RPC 2.0 error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Application error codes (JSON-RPC reserves -32000..-32099 for servers).
+const (
+	CodeVersionMismatch   = -32001
+	CodeRestartInProgress = -32002
+	CodeRestartFailed     = -32003
+	CodeTakeoverDisabled  = -32004
+)
+
+// Request is an inbound JSON-RPC request. Requests without an ID are
+// notifications and must not be answered.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// IsNotification reports whether the request expects no response.
+func (r Request) IsNotification() bool {
+	return len(r.ID) == 0
+}
+
+// Error is a structured JSON-RPC error.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errorf builds an Error with a formatted message.
+func Errorf(code int, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type notification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+// Conn reads requests from and writes messages to a CTAP2 connection.
+// Writes are serialized so server-initiated notifications may be sent from
+// other goroutines while a request is being answered.
+type Conn struct {
+	r       *bufio.Reader
+	mu      sync.Mutex
+	w       io.Writer
+	timeout time.Duration // see SetWriteTimeout
+}
+
+// NewConn wraps a reader (usually the bufio.Reader that consumed the Hello
+// line) and a writer into a CTAP2 connection.
+func NewConn(r *bufio.Reader, w io.Writer) *Conn {
+	return &Conn{r: r, w: w}
+}
+
+// SetWriteTimeout bounds every later write by d if the writer supports
+// write deadlines, as a net.Conn does, so that a peer that stops reading
+// cannot block the writer for long. Zero disables the bound.
+func (c *Conn) SetWriteTimeout(d time.Duration) {
+	c.mu.Lock()
+	c.timeout = d
+	c.mu.Unlock()
+}
+
+// ReadRequest reads the next request. Malformed lines are answered with a
+// parse or invalid-request error and skipped, so callers only see valid
+// requests or a read error.
+func (c *Conn) ReadRequest() (Request, error) {
+	for {
+		line, err := c.r.ReadBytes('\n')
+		if err != nil {
+			return Request{}, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var req Request
+		if jsonErr := json.Unmarshal(line, &req); jsonErr != nil {
+			_ = c.ReplyError(json.RawMessage("null"), Errorf(CodeParseError, "parse error: %v", jsonErr))
+			continue
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			id := req.ID
+			if len(id) == 0 {
+				id = json.RawMessage("null")
+			}
+			_ = c.ReplyError(id, Errorf(CodeInvalidRequest, "invalid request"))
+			continue
+		}
+		return req, nil
+	}
+}
+
+// Reply sends a successful result for the request with the given ID.
+func (c *Conn) Reply(id json.RawMessage, result any) error {
+	if result == nil {
+		result = struct{}{}
+	}
+	return c.write(response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+// ReplyError sends an error response for the request with the given ID.
+func (c *Conn) ReplyError(id json.RawMessage, e *Error) error {
+	return c.write(response{JSONRPC: "2.0", ID: id, Error: e})
+}
+
+// Notify sends a server-initiated notification.
+func (c *Conn) Notify(method string, params any) error {
+	return c.write(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (c *Conn) write(msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("encode message: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if dw, ok := c.w.(interface{ SetWriteDeadline(time.Time) error }); ok && c.timeout > 0 {
+		_ = dw.SetWriteDeadline(time.Now().Add(c.timeout))
+		defer dw.SetWriteDeadline(time.Time{})
+	}
+	_, err = c.w.Write(append(data, '\n'))
+	return err
+}
+
+// DecodeParams unmarshals named parameters into v. Missing params decode as
+// an empty object; anything else that does not fit v is an invalid-params error.
+func DecodeParams(req Request, v any) *Error {
+	if len(req.Params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(req.Params, v); err != nil {
+		return Errorf(CodeInvalidParams, "invalid params: %v", err)
+	}
+	return nil
+}
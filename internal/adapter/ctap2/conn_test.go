@@ -0,0 +1,151 @@
+package ctap2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decodeLines(t *testing.T, out string) []map[string]any {
+	t.Helper()
+	var msgs []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("decode %q: %v", line, err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+func TestReadRequest_Valid(t *testing.T) {
+	in := `{"jsonrpc":"2.0","id":1,"method":"info"}` + "\n"
+	var out bytes.Buffer
+	c := NewConn(bufio.NewReader(strings.NewReader(in)), &out)
+
+	req, err := c.ReadRequest()
+	if err != nil {
+		t.Fatalf("ReadRequest() error: %v", err)
+	}
+	if req.Method != "info" {
+		t.Errorf("Method = %q, want info", req.Method)
+	}
+	if string(req.ID) != "1" {
+		t.Errorf("ID = %s, want 1", req.ID)
+	}
+	if req.IsNotification() {
+		t.Error("request with id should not be a notification")
+	}
+	if out.Len() != 0 {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
+
+func TestReadRequest_SkipsMalformed(t *testing.T) {
+	in := "not json\n" +
+		`{"jsonrpc":"1.0","id":"a","method":"info"}` + "\n" +
+		"\n" +
+		`{"jsonrpc":"2.0","method":"pong"}` + "\n"
+	var out bytes.Buffer
+	c := NewConn(bufio.NewReader(strings.NewReader(in)), &out)
+
+	req, err := c.ReadRequest()
+	if err != nil {
+		t.Fatalf("ReadRequest() error: %v", err)
+	}
+	if req.Method != "pong" || !req.IsNotification() {
+		t.Errorf("got %+v, want pong notification", req)
+	}
+
+	msgs := decodeLines(t, out.String())
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 error responses, got %d: %q", len(msgs), out.String())
+	}
+	codes := []float64{CodeParseError, CodeInvalidRequest}
+	for i, m := range msgs {
+		e, ok := m["error"].(map[string]any)
+		if !ok {
+			t.Fatalf("message %d has no error: %v", i, m)
+		}
+		if e["code"] != codes[i] {
+			t.Errorf("message %d code = %v, want %v", i, e["code"], codes[i])
+		}
+	}
+	if msgs[1]["id"] != "a" {
+		t.Errorf("invalid request should echo id, got %v", msgs[1]["id"])
+	}
+}
+
+func TestReplyAndNotify(t *testing.T) {
+	var out bytes.Buffer
+	c := NewConn(bufio.NewReader(strings.NewReader("")), &out)
+
+	if err := c.Reply(json.RawMessage("7"), map[string]string{"token": "t"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ReplyError(json.RawMessage(`"x"`), &Error{Code: CodeVersionMismatch, Message: "mismatch", Data: map[string]int{"clients": 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Notify("lease.released", map[string]string{"client_id": "c1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := decodeLines(t, out.String())
+	if len(msgs) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(msgs))
+	}
+	if msgs[0]["id"] != float64(7) || msgs[0]["result"].(map[string]any)["token"] != "t" {
+		t.Errorf("bad reply: %v", msgs[0])
+	}
+	e := msgs[1]["error"].(map[string]any)
+	if e["code"] != float64(CodeVersionMismatch) || e["data"].(map[string]any)["clients"] != float64(2) {
+		t.Errorf("bad error reply: %v", msgs[1])
+	}
+	if _, ok := msgs[2]["id"]; ok {
+		t.Errorf("notification must not carry an id: %v", msgs[2])
+	}
+	if msgs[2]["method"] != "lease.released" {
+		t.Errorf("bad notification: %v", msgs[2])
+	}
+}
+
+func TestDecodeParams(t *testing.T) {
+	var p struct {
+		Commit string `json:"commit"`
+	}
+	if err := DecodeParams(Request{Params: json.RawMessage(`{"commit":"abc"}`)}, &p); err != nil {
+		t.Fatalf("DecodeParams() error: %v", err)
+	}
+	if p.Commit != "abc" {
+		t.Errorf("Commit = %q", p.Commit)
+	}
+	if err := DecodeParams(Request{}, &p); err != nil {
+		t.Errorf("missing params should not error: %v", err)
+	}
+	err := DecodeParams(Request{Params: json.RawMessage(`["abc"]`)}, &p)
+	if err == nil || err.Code != CodeInvalidParams {
+		t.Errorf("positional params should be invalid, got %v", err)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c := NewConn(bufio.NewReader(server), server)
+	c.SetWriteTimeout(50 * time.Millisecond)
+
+	// The client never reads, so the write can only end by the deadline.
+	start := time.Now()
+	if err := c.Notify("ping", nil); err == nil {
+		t.Fatal("Notify() succeeded on a peer that does not read")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Notify() blocked for %v", elapsed)
+	}
+}
@@ -2,91 +2,366 @@ package downloader
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"codetap/internal/domain"
 )
 
-const urlTemplate = "https://update.code.visualstudio.com/commit:%s/%s/stable"
+const (
+	defaultUpdateAPI = "https://update.code.visualstudio.com"
+	// downloadPath and apiPath are appended to the update API base URL. The
+	// API path describes one build, including its sha256hash.
+	downloadPath = "/commit:{commit}/{artifact}/{quality}"
+	apiPath      = "/api/versions/commit:{commit}/{artifact}/{quality}"
+	// quality is the only release channel codetap provisions.
+	quality = "stable"
+)
+
+// Options configures an HTTPDownloader. Zero durations and counts select
+// the defaults.
+type Options struct {
+	// Verify re-hashes cached tarballs against their recorded SHA-256 before
+	// using them. Fresh downloads are always verified.
+	Verify bool
+	// InsecureSkipVerify accepts tarballs whose checksum the update API
+	// cannot provide, e.g. from a mirror without /api/versions. Without it
+	// such a download fails. Tarballs accepted this way get no .sha256.
+	InsecureSkipVerify bool
+	// ConnectTimeout bounds establishing a connection, including TLS.
+	ConnectTimeout time.Duration
+	// IdleTimeout aborts an attempt when no data arrives for this long.
+	IdleTimeout time.Duration
+	// Retries is how often a failed download is retried; a negative value
+	// disables retries.
+	Retries int
+	// DownloadURL is a URL template for tarballs with the placeholders
+	// {commit}, {artifact} and {quality}. Empty means the UpdateAPI host's
+	// own download path.
+	DownloadURL string
+	// UpdateAPI is the base URL of the update API, which publishes the
+	// checksums. Empty means Microsoft's.
+	UpdateAPI string
+	// TLS configures trusted CAs and client certificates; nil uses the
+	// system defaults.
+	TLS *tls.Config
+}
 
 // HTTPDownloader downloads VS Code Server tarballs via HTTP.
 type HTTPDownloader struct {
-	cacheDir string
-	logger   domain.Logger
+	cacheDir    string
+	opts        Options
+	logger      domain.Logger
+	client      *http.Client
+	downloadURL string
+	apiURL      string
 }
 
 // NewHTTPDownloader creates a downloader that caches tarballs in cacheDir.
-func NewHTTPDownloader(cacheDir string, logger domain.Logger) *HTTPDownloader {
+func NewHTTPDownloader(cacheDir string, opts Options, logger domain.Logger) *HTTPDownloader {
+	if opts.ConnectTimeout <= 0 {
+		opts.ConnectTimeout = defaultConnectTimeout
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = defaultIdleTimeout
+	}
+	switch {
+	case opts.Retries == 0:
+		opts.Retries = defaultRetries
+	case opts.Retries < 0:
+		opts.Retries = 0
+	}
+	api := strings.TrimRight(opts.UpdateAPI, "/")
+	if api == "" {
+		api = defaultUpdateAPI
+	}
+	downloadURL := opts.DownloadURL
+	if downloadURL == "" {
+		downloadURL = api + downloadPath
+	}
 	return &HTTPDownloader{
-		cacheDir: cacheDir,
-		logger:   logger,
+		cacheDir:    cacheDir,
+		opts:        opts,
+		logger:      logger,
+		client:      newHTTPClient(opts),
+		downloadURL: downloadURL,
+		apiURL:      api + apiPath,
 	}
 }
 
+// CheckURLTemplate reports whether template is usable as Options.DownloadURL:
+// an absolute http(s) URL that names the commit.
+func CheckURLTemplate(template string) error {
+	u, err := url.Parse(expandURL(template, "commit", "artifact"))
+	if err != nil {
+		return fmt.Errorf("download URL %q: %w", template, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("download URL %q: want an absolute http or https URL", template)
+	}
+	if !strings.Contains(template, "{commit}") {
+		return fmt.Errorf("download URL %q: missing {commit} placeholder", template)
+	}
+	return nil
+}
+
+// expandURL fills the {commit}, {artifact} and {quality} placeholders.
+func expandURL(template, commit, artifact string) string {
+	return strings.NewReplacer("{commit}", commit, "{artifact}", artifact, "{quality}", quality).Replace(template)
+}
+
+var (
+	commitRe   = regexp.MustCompile(`^[0-9a-f]{40}$`)
+	artifactRe = regexp.MustCompile(`^server-[a-z0-9-]+$`)
+)
+
+// tarballName is the cache file name of a build's tarball.
+func tarballName(commit, artifact string) string {
+	return fmt.Sprintf("%s-%s.tar.gz", commit, artifact)
+}
+
+// buildInfo is the part of the update API's build description we use.
+type buildInfo struct {
+	SHA256 string `json:"sha256hash"`
+}
+
 // Download fetches the server tarball for the given commit and arch.
 // Returns the path to the cached tarball. Skips download if already cached.
+//
+// The tarball is hashed while it streams to disk and checked against the
+// sha256hash the update API publishes for the build; on a mismatch nothing
+// is cached and an error is returned. The verified hash is recorded next to
+// the tarball as <tarball>.sha256 so that cache hits can be re-verified.
+//
+// Concurrent runs for the same build, also from other processes sharing the
+// cache directory, are serialized by a lock file; the later ones wait and
+// then use the tarball the first one cached.
 func (d *HTTPDownloader) Download(commit, arch string) (string, error) {
-	artifact := serverArtifactName(arch, isAlpineLinux())
-	filename := fmt.Sprintf("%s-%s.tar.gz", commit, artifact)
-	dest := filepath.Join(d.cacheDir, filename)
+	return d.downloadArtifact(commit, LocalArtifact(arch))
+}
 
-	if _, err := os.Stat(dest); err == nil {
-		d.logger.Info("using cached tarball", "path", dest)
-		return dest, nil
+// Tarball returns the cached tarball of a build named by its artifact
+// rather than the local architecture, downloading it first if needed, and
+// its verified SHA-256. A tarball that could not be verified is refused
+// unless InsecureSkipVerify is set; its sum is "" then. A relay host uses it
+// to supply tarballs to remotes, which may run another architecture or libc
+// than the host.
+func (d *HTTPDownloader) Tarball(commit, artifact string) (path, sum string, err error) {
+	if !commitRe.MatchString(commit) {
+		return "", "", fmt.Errorf("invalid commit %q", commit)
+	}
+	if !artifactRe.MatchString(artifact) {
+		return "", "", fmt.Errorf("invalid artifact %q", artifact)
 	}
+	path, err = d.downloadArtifact(commit, artifact)
+	if err != nil {
+		return "", "", err
+	}
+	sum, err = readChecksum(path)
+	if err != nil {
+		if !d.opts.InsecureSkipVerify {
+			return "", "", fmt.Errorf("tarball %s was never verified: %w", filepath.Base(path), errUnverified)
+		}
+		sum = ""
+	}
+	return path, sum, nil
+}
+
+// errUnverified reports a tarball without a published checksum.
+var errUnverified = errors.New("no published checksum; pass --insecure-skip-verify to accept it anyway")
+
+func (d *HTTPDownloader) downloadArtifact(commit, artifact string) (string, error) {
+	filename := tarballName(commit, artifact)
 
 	if err := os.MkdirAll(d.cacheDir, 0755); err != nil {
 		return "", fmt.Errorf("create cache dir: %w", err)
 	}
-
-	url := fmt.Sprintf(urlTemplate, commit, artifact)
-	d.logger.Info("downloading VS Code Server", "commit", commit, "arch", arch, "artifact", artifact)
-
-	resp, err := http.Get(url)
+	lock, err := lockBuild(d.cacheDir, commit, artifact, d.logger)
 	if err != nil {
-		return "", fmt.Errorf("download failed: %w", err)
+		return "", err
 	}
-	defer resp.Body.Close()
+	defer lock.Release()
+	d.removeStalePartials(filename)
+
+	return d.download(commit, artifact, filename)
+}
 
-	if resp.StatusCode == http.StatusNotFound {
-		return "", fmt.Errorf("VS Code Server commit %s not found for artifact %s (arch %s) — verify the commit hash matches your VS Code version", commit, artifact, arch)
+// download does the work of Download while holding the build's lock.
+func (d *HTTPDownloader) download(commit, artifact, filename string) (string, error) {
+	dest := filepath.Join(d.cacheDir, filename)
+
+	if _, err := os.Stat(dest); err == nil {
+		// A tarball without a recorded checksum was never verified.
+		_, sumErr := readChecksum(dest)
+		if !d.opts.Verify && (sumErr == nil || d.opts.InsecureSkipVerify) {
+			d.logger.Info("using cached tarball", "path", dest)
+			return dest, nil
+		}
+		err := d.verifyCached(commit, artifact, dest)
+		if err == nil {
+			d.logger.Info("using cached tarball", "path", dest, "verified", true)
+			return dest, nil
+		}
+		if errors.Is(err, errUnverified) {
+			return "", fmt.Errorf("cached tarball %s: %w", filename, err)
+		}
+		d.logger.Error("cached tarball failed verification, downloading again", "path", dest, "err", err)
+		os.Remove(dest)
+		os.Remove(dest + ".sha256")
 	}
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("download returned HTTP %d", resp.StatusCode)
+
+	want, err := d.fetchSHA256(commit, artifact)
+	if err != nil {
+		if !d.opts.InsecureSkipVerify {
+			return "", fmt.Errorf("cannot verify VS Code Server %s for %s: %v: %w", commit, artifact, err, errUnverified)
+		}
+		d.logger.Error("checksum unavailable, tarball will not be verified", "commit", commit, "artifact", artifact, "err", err)
 	}
 
-	// Write to temp file then rename atomically
-	tmp, err := os.CreateTemp(d.cacheDir, ".download-*")
+	url := expandURL(d.downloadURL, commit, artifact)
+	d.logger.Info("downloading VS Code Server", "commit", commit, "artifact", artifact)
+
+	// The partial file has a fixed name so that a later run can resume it.
+	tmpPath := filepath.Join(d.cacheDir, ".download-"+filename)
+	got, resumed, err := d.fetch(url, tmpPath)
+	if errors.Is(err, errNotFound) {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("VS Code Server commit %s not found for artifact %s — verify the commit hash matches your VS Code version", commit, artifact)
+	}
 	if err != nil {
-		return "", fmt.Errorf("create temp file: %w", err)
+		return "", fmt.Errorf("download failed: %w", err)
 	}
-	tmpPath := tmp.Name()
 
-	_, copyErr := io.Copy(tmp, resp.Body)
-	closeErr := tmp.Close()
-	if copyErr != nil {
+	if want != "" && got != want && resumed {
+		// The bytes kept from an earlier attempt may belong to another
+		// file; try once more from scratch before calling it corrupt.
+		d.logger.Error("resumed download failed verification, starting over", "sha256", got, "expected", want)
 		os.Remove(tmpPath)
-		return "", fmt.Errorf("write tarball: %w", copyErr)
+		if got, _, err = d.fetch(url, tmpPath); err != nil {
+			return "", fmt.Errorf("download failed: %w", err)
+		}
 	}
-	if closeErr != nil {
+	if want != "" && got != want {
 		os.Remove(tmpPath)
-		return "", fmt.Errorf("close temp file: %w", closeErr)
+		return "", fmt.Errorf("download of %s is corrupt: sha256 %s, expected %s", filename, got, want)
 	}
 
 	if err := os.Rename(tmpPath, dest); err != nil {
 		os.Remove(tmpPath)
 		return "", fmt.Errorf("rename tarball: %w", err)
 	}
+	if want != "" {
+		if err := writeChecksum(dest, got); err != nil {
+			d.logger.Error("record checksum failed", "path", dest, "err", err)
+		}
+	}
 
-	d.logger.Info("download complete", "path", dest)
+	d.logger.Info("download complete", "path", dest, "sha256", got, "verified", want != "")
 	return dest, nil
 }
 
-func serverArtifactName(arch string, alpine bool) string {
+// verifyCached hashes a cached tarball and compares it with the checksum
+// recorded at download time, or with the update API's if none was recorded.
+// A tarball without any known checksum fails with errUnverified, or is
+// accepted with a warning if InsecureSkipVerify is set.
+func (d *HTTPDownloader) verifyCached(commit, artifact, path string) error {
+	want, err := readChecksum(path)
+	recorded := err == nil
+	if !recorded {
+		want, err = d.fetchSHA256(commit, artifact)
+		if err != nil {
+			if !d.opts.InsecureSkipVerify {
+				return fmt.Errorf("%v: %w", err, errUnverified)
+			}
+			d.logger.Error("checksum unavailable, cached tarball not verified", "path", path, "err", err)
+			return nil
+		}
+	}
+	got, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("sha256 %s, expected %s", got, want)
+	}
+	if !recorded {
+		_ = writeChecksum(path, got)
+	}
+	return nil
+}
+
+// fetchSHA256 asks the update API for the published SHA-256 of a build.
+func (d *HTTPDownloader) fetchSHA256(commit, artifact string) (string, error) {
+	resp, err := d.client.Get(expandURL(d.apiURL, commit, artifact))
+	if err != nil {
+		return "", fmt.Errorf("fetch checksum: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetch checksum: update API returned HTTP %d", resp.StatusCode)
+	}
+	var info buildInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", fmt.Errorf("fetch checksum: invalid API response: %w", err)
+	}
+	sum := strings.ToLower(info.SHA256)
+	if len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("fetch checksum: API returned unexpected sha256hash %q", info.SHA256)
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return "", fmt.Errorf("fetch checksum: API returned unexpected sha256hash %q", info.SHA256)
+	}
+	return sum, nil
+}
+
+// readChecksum reads <path>.sha256, written in sha256sum format.
+func readChecksum(path string) (string, error) {
+	data, err := os.ReadFile(path + ".sha256")
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("malformed checksum file %s.sha256", path)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// writeChecksum records sum as <path>.sha256 in sha256sum format, so the
+// cache can also be checked with "sha256sum -c".
+func writeChecksum(path, sum string) error {
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	return os.WriteFile(path+".sha256", []byte(line), 0644)
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ArtifactName names the server build for arch on glibc, or on musl
+// (Alpine) if alpine is set.
+func ArtifactName(arch string, alpine bool) string {
 	if alpine {
 		switch arch {
 		case "x64":
@@ -98,6 +373,11 @@ func serverArtifactName(arch string, alpine bool) string {
 	return fmt.Sprintf("server-linux-%s", arch)
 }
 
+// LocalArtifact names the server build that runs on this machine.
+func LocalArtifact(arch string) string {
+	return ArtifactName(arch, isAlpineLinux())
+}
+
 func isAlpineLinux() bool {
 	data, err := os.ReadFile("/etc/os-release")
 	if err != nil {
@@ -1,8 +1,165 @@
 package downloader
 
-import "testing"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
 
-func TestServerArtifactName(t *testing.T) {
+type nopLogger struct{}
+
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+// fakeUpdateServer serves one tarball and the update API's description of
+// it, advertising sum as its SHA-256, and counts tarball downloads.
+type fakeUpdateServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	tarball   []byte
+	sum       string
+	downloads int
+}
+
+func newFakeUpdateServer(t *testing.T, tarball []byte, sum string) *fakeUpdateServer {
+	t.Helper()
+	f := &fakeUpdateServer{tarball: tarball, sum: sum}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			fmt.Fprintf(w, `{"version":"abc","sha256hash":%q}`, f.sum)
+			return
+		}
+		f.downloads++
+		_, _ = w.Write(f.tarball)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func newTestDownloader(t *testing.T, srv *fakeUpdateServer, opts Options) *HTTPDownloader {
+	opts.UpdateAPI = srv.URL
+	d := NewHTTPDownloader(t.TempDir(), opts, nopLogger{})
+	return d
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDownload_VerifiesAndRecordsChecksum(t *testing.T) {
+	body := []byte("tarball bytes")
+	srv := newFakeUpdateServer(t, body, strings.ToUpper(sha256Hex(body)))
+	d := newTestDownloader(t, srv, Options{})
+
+	path, err := d.Download("abc", "x64")
+	if err != nil {
+		t.Fatalf("Download() error: %v", err)
+	}
+	if got, err := readChecksum(path); err != nil || got != sha256Hex(body) {
+		t.Errorf("recorded checksum = %q, %v; want %s", got, err, sha256Hex(body))
+	}
+}
+
+func TestDownload_RefusesMismatch(t *testing.T) {
+	srv := newFakeUpdateServer(t, []byte("truncated"), sha256Hex([]byte("complete tarball")))
+	d := newTestDownloader(t, srv, Options{})
+
+	if _, err := d.Download("abc", "x64"); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Fatalf("Download() error = %v, want checksum mismatch", err)
+	}
+	entries, _ := os.ReadDir(d.cacheDir)
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), ".lock-") {
+			t.Errorf("cache dir holds %s after a mismatch, want nothing but the lock file", e.Name())
+		}
+	}
+}
+
+func TestDownload_VerifyRedownloadsCorruptCache(t *testing.T) {
+	body := []byte("tarball bytes")
+	srv := newFakeUpdateServer(t, body, sha256Hex(body))
+
+	// Without --verify a cache hit is trusted as is.
+	d := newTestDownloader(t, srv, Options{})
+	path, err := d.Download("abc", "x64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("bit rot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Download("abc", "x64"); err != nil || srv.downloads != 1 {
+		t.Fatalf("unverified cache hit: err=%v downloads=%d, want cached tarball used", err, srv.downloads)
+	}
+
+	d.opts.Verify = true
+	if _, err := d.Download("abc", "x64"); err != nil {
+		t.Fatalf("Download(verify) error: %v", err)
+	}
+	if srv.downloads != 2 {
+		t.Errorf("downloads = %d, want the corrupt cache entry fetched again", srv.downloads)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != string(body) {
+		t.Errorf("cached tarball = %q, want %q", data, body)
+	}
+}
+
+func TestDownload_FailsClosedWithoutChecksum(t *testing.T) {
+	body := []byte("tarball bytes")
+	srv := newFakeUpdateServer(t, body, "") // the API publishes no usable sum
+	commit := strings.Repeat("a", 40)
+	d := newTestDownloader(t, srv, Options{})
+
+	if _, err := d.Download(commit, "x64"); !errors.Is(err, errUnverified) {
+		t.Fatalf("Download() error = %v, want errUnverified", err)
+	}
+	if srv.downloads != 0 {
+		t.Errorf("downloaded %d times without a checksum", srv.downloads)
+	}
+
+	// A tarball cached without a checksum is not used, but kept.
+	cached := filepath.Join(d.cacheDir, tarballName(commit, LocalArtifact("x64")))
+	if err := os.WriteFile(cached, body, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Download(commit, "x64"); !errors.Is(err, errUnverified) {
+		t.Errorf("Download() of an unverified cached tarball error = %v, want errUnverified", err)
+	}
+	if _, _, err := d.Tarball(commit, LocalArtifact("x64")); !errors.Is(err, errUnverified) {
+		t.Errorf("Tarball() error = %v, want errUnverified", err)
+	}
+	if _, err := os.Stat(cached); err != nil {
+		t.Errorf("unverified cached tarball was removed: %v", err)
+	}
+	os.Remove(cached)
+
+	// Opting out downloads it, but records no checksum.
+	d.opts.InsecureSkipVerify = true
+	path, err := d.Download(commit, "x64")
+	if err != nil {
+		t.Fatalf("Download(InsecureSkipVerify) error: %v", err)
+	}
+	if _, err := os.Stat(path + ".sha256"); !os.IsNotExist(err) {
+		t.Errorf("unverified tarball got a checksum file: %v", err)
+	}
+	if _, sum, err := d.Tarball(commit, LocalArtifact("x64")); err != nil || sum != "" {
+		t.Errorf("Tarball(InsecureSkipVerify) = %q, %v; want no sum", sum, err)
+	}
+}
+
+func TestArtifactName(t *testing.T) {
 	tests := []struct {
 		name   string
 		arch   string
@@ -18,9 +175,9 @@ func TestServerArtifactName(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := serverArtifactName(tt.arch, tt.alpine)
+			got := ArtifactName(tt.arch, tt.alpine)
 			if got != tt.want {
-				t.Fatalf("serverArtifactName(%q, alpine=%v) = %q, want %q", tt.arch, tt.alpine, got, tt.want)
+				t.Fatalf("ArtifactName(%q, alpine=%v) = %q, want %q", tt.arch, tt.alpine, got, tt.want)
 			}
 		})
 	}
@@ -47,3 +204,43 @@ func TestIsAlpineOSRelease(t *testing.T) {
 		})
 	}
 }
+
+func TestDownload_UsesMirrorTemplate(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			http.NotFound(w, r)
+			return
+		}
+		got = r.URL.Path
+		_, _ = w.Write([]byte("tarball"))
+	}))
+	defer srv.Close()
+
+	d := NewHTTPDownloader(t.TempDir(), Options{
+		DownloadURL:        srv.URL + "/vscode/{quality}/{commit}/vscode-{artifact}.tar.gz",
+		UpdateAPI:          srv.URL + "/",
+		InsecureSkipVerify: true,
+	}, nopLogger{})
+	if _, err := d.Download("abc", "arm64"); err != nil {
+		t.Fatalf("Download() error: %v", err)
+	}
+	want := "/vscode/stable/abc/vscode-" + LocalArtifact("arm64") + ".tar.gz"
+	if got != want {
+		t.Errorf("requested %q, want %q", got, want)
+	}
+}
+
+func TestCheckURLTemplate(t *testing.T) {
+	for template, ok := range map[string]bool{
+		"https://mirror.example/vscode/{commit}/{artifact}": true,
+		"http://10.0.0.1:8080/{quality}/{commit}.tgz":       true,
+		"https://mirror.example/vscode/latest.tar.gz":       false,
+		"mirror.example/{commit}":                           false,
+		"ftp://mirror.example/{commit}":                     false,
+	} {
+		if err := CheckURLTemplate(template); (err == nil) != ok {
+			t.Errorf("CheckURLTemplate(%q) = %v, want ok=%v", template, err, ok)
+		}
+	}
+}
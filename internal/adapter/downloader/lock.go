@@ -0,0 +1,74 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"codetap/internal/adapter/flock"
+	"codetap/internal/domain"
+)
+
+// stalePartialAge is how long a partial download of another build is kept
+// for resuming before it is treated as abandoned.
+var stalePartialAge = 24 * time.Hour
+
+// lockBuild takes the cross-process lock for one build, so that concurrent
+// runs sharing a cache directory download it only once. The waiting run
+// then finds the tarball cached.
+func lockBuild(cacheDir, commit, artifact string, logger domain.Logger) (*flock.Lock, error) {
+	path := filepath.Join(cacheDir, ".lock-"+commit+"-"+artifact)
+	l, ok, err := flock.TryAcquire(path)
+	if err != nil || ok {
+		return l, err
+	}
+	logger.Info("waiting for another process downloading the same server", "lock", path)
+	return flock.Acquire(path)
+}
+
+// removeStalePartials deletes partial downloads of other builds that no
+// process is writing and that have not been touched for stalePartialAge.
+// The partial download of the build being fetched is resumed instead.
+func (d *HTTPDownloader) removeStalePartials(filename string) {
+	entries, err := os.ReadDir(d.cacheDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name, ok := strings.CutPrefix(e.Name(), ".download-")
+		if !ok || name == filename {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < stalePartialAge {
+			continue
+		}
+		path := filepath.Join(d.cacheDir, e.Name())
+		build, ok := strings.CutSuffix(name, ".tar.gz")
+		if !ok {
+			// Older releases named partials randomly and never locked them.
+			d.removeStalePartial(path, info)
+			continue
+		}
+		l, ok, err := flock.TryAcquire(filepath.Join(d.cacheDir, ".lock-"+build))
+		if err != nil || !ok {
+			continue
+		}
+		if d.removeStalePartial(path, info) {
+			l.Remove()
+		} else {
+			l.Release()
+		}
+	}
+}
+
+// removeStalePartial deletes one abandoned partial download and reports
+// whether it is gone.
+func (d *HTTPDownloader) removeStalePartial(path string, info os.FileInfo) bool {
+	if err := os.Remove(path); err != nil {
+		return false
+	}
+	d.logger.Info("removed stale partial download", "path", path, "size", FormatBytes(info.Size()), "modified", info.ModTime().Format(time.RFC3339))
+	return true
+}
@@ -0,0 +1,90 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDownload_ConcurrentRunsDownloadOnce(t *testing.T) {
+	body := []byte("tarball bytes")
+	srv := newFakeUpdateServer(t, body, sha256Hex(body))
+	dir := t.TempDir()
+
+	// Separate downloaders share nothing but the cache directory, like
+	// separate processes with a common $HOME.
+	var wg sync.WaitGroup
+	paths := make([]string, 4)
+	for i := range paths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d := NewHTTPDownloader(dir, Options{UpdateAPI: srv.URL}, nopLogger{})
+			path, err := d.Download("abc", "x64")
+			if err != nil {
+				t.Errorf("Download() error: %v", err)
+			}
+			paths[i] = path
+		}(i)
+	}
+	wg.Wait()
+
+	if srv.downloads != 1 {
+		t.Errorf("tarball downloaded %d times, want once", srv.downloads)
+	}
+	for _, p := range paths[1:] {
+		if p != paths[0] {
+			t.Errorf("Download() paths differ: %q and %q", p, paths[0])
+		}
+	}
+}
+
+func TestDownload_RemovesStalePartials(t *testing.T) {
+	body := []byte("tarball bytes")
+	srv := newFakeUpdateServer(t, body, sha256Hex(body))
+	d := newTestDownloader(t, srv, Options{})
+
+	stale := filepath.Join(d.cacheDir, ".download-old-server-linux-x64.tar.gz")
+	recent := filepath.Join(d.cacheDir, ".download-new-server-linux-x64.tar.gz")
+	busy := filepath.Join(d.cacheDir, ".download-busy-server-linux-x64.tar.gz")
+	legacy := filepath.Join(d.cacheDir, ".download-123456789")
+	old := time.Now().Add(-2 * stalePartialAge)
+	for _, p := range []string{stale, recent, busy, legacy} {
+		if err := os.WriteFile(p, []byte("part"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{stale, busy, legacy} {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	held, err := lockBuild(d.cacheDir, "busy", "server-linux-x64", nopLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Release()
+
+	if _, err := d.Download("abc", "x64"); err != nil {
+		t.Fatalf("Download() error: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("abandoned partial download was not removed")
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Error("abandoned randomly named partial download was not removed")
+	}
+	for _, lock := range []string{".lock-old-server-linux-x64", ".lock-123456789"} {
+		if _, err := os.Stat(filepath.Join(d.cacheDir, lock)); !os.IsNotExist(err) {
+			t.Errorf("lock %s left behind: %v", lock, err)
+		}
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("recent partial download was removed: %v", err)
+	}
+	if _, err := os.Stat(busy); err != nil {
+		t.Errorf("partial download held by another process was removed: %v", err)
+	}
+}
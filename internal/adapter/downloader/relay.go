@@ -0,0 +1,67 @@
+package downloader
+
+import (
+	"io"
+	"path/filepath"
+
+	"codetap/internal/adapter/relay"
+	"codetap/internal/domain"
+)
+
+// RelayDownloader provisions the remote side of a stdio relay, which often
+// has no network access of its own. On a cache miss it asks the relay host
+// over the mux to supply the tarball from the host's cache, verifies it
+// against the SHA-256 the host recorded, and caches it like HTTPDownloader
+// does. Until the host has announced support in its init frame (see
+// EnableHost), and whenever the host cannot supply a tarball, it falls back
+// to the wrapped downloader.
+type RelayDownloader struct {
+	cacheDir string
+	r        io.Reader
+	w        io.Writer
+	fallback domain.Downloader
+	logger   domain.Logger
+	host     bool
+}
+
+// NewRelayDownloader creates a downloader that fetches tarballs over the
+// relay frames read from r and written to w, caching them in cacheDir.
+func NewRelayDownloader(cacheDir string, r io.Reader, w io.Writer, fallback domain.Downloader, logger domain.Logger) *RelayDownloader {
+	return &RelayDownloader{cacheDir: cacheDir, r: r, w: w, fallback: fallback, logger: logger}
+}
+
+// EnableHost is called once the relay host has offered to supply tarballs.
+// Requests must not be sent to a host that has not, and only during the
+// init phase, before the mux carries connections.
+func (d *RelayDownloader) EnableHost() {
+	d.host = true
+}
+
+// Download returns the cached tarball for commit and arch, fetching it from
+// the relay host if it is missing.
+func (d *RelayDownloader) Download(commit, arch string) (string, error) {
+	artifact := LocalArtifact(arch)
+	if !d.host || fileExists(filepath.Join(d.cacheDir, tarballName(commit, artifact))) {
+		return d.fallback.Download(commit, arch)
+	}
+
+	d.logger.Info("fetching VS Code Server from relay host", "commit", commit, "artifact", artifact)
+	verified := false
+	path, err := receive(d.cacheDir, commit, artifact, d.logger, func(w io.Writer) (string, bool, error) {
+		sum, ok, err := relay.FetchTarball(d.r, d.w, relay.TarballRequest{Commit: commit, Artifact: artifact}, w)
+		verified = ok
+		return sum, ok, err
+	})
+	if err == nil && !verified {
+		// The host never verified it either; whether it may be used is up
+		// to the wrapped downloader, as for any unverified cached tarball.
+		d.logger.Info("received unverified server from relay host", "path", path)
+		return d.fallback.Download(commit, arch)
+	}
+	if err == nil {
+		d.logger.Info("received server from relay host", "path", path)
+		return path, nil
+	}
+	d.logger.Error("relay host could not supply the server, downloading it directly", "commit", commit, "artifact", artifact, "err", err)
+	return d.fallback.Download(commit, arch)
+}
@@ -0,0 +1,144 @@
+package downloader
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"codetap/internal/adapter/relay"
+)
+
+// fakeRelayHost answers FrameFetch requests read from r with body and sum,
+// speaking the host's side of the init phase.
+func fakeRelayHost(t *testing.T, r io.Reader, w io.Writer, body []byte, sum string, unverified bool) {
+	t.Helper()
+	go func() {
+		for {
+			frame, err := relay.ReadFrame(r)
+			if err != nil {
+				return
+			}
+			var req relay.TarballRequest
+			_ = json.Unmarshal(frame.Data, &req)
+			reply, _ := json.Marshal(map[string]any{"size": len(body), "sha256": sum, "unverified": unverified})
+			_ = relay.WriteFrame(w, relay.Frame{Type: relay.FrameFetch, Data: reply})
+			_ = relay.WriteFrame(w, relay.Frame{Type: relay.FrameData, Data: body})
+			_ = relay.WriteFrame(w, relay.Frame{Type: relay.FrameClose})
+		}
+	}()
+}
+
+type fallbackDownloader struct {
+	calls int
+	path  string
+}
+
+func (f *fallbackDownloader) Download(commit, arch string) (string, error) {
+	f.calls++
+	return f.path, nil
+}
+
+func newRelayPair(t *testing.T, body []byte, sum string, unverified bool, fallback *fallbackDownloader) *RelayDownloader {
+	toHost, fromRemote := io.Pipe()
+	toRemote, fromHost := io.Pipe()
+	t.Cleanup(func() { fromRemote.Close(); fromHost.Close() })
+	fakeRelayHost(t, toHost, fromHost, body, sum, unverified)
+	return NewRelayDownloader(t.TempDir(), toRemote, fromRemote, fallback, nopLogger{})
+}
+
+func TestRelayDownloader_FetchesAndCaches(t *testing.T) {
+	body := []byte("tarball bytes")
+	fallback := &fallbackDownloader{}
+	d := newRelayPair(t, body, sha256Hex(body), false, fallback)
+	d.EnableHost()
+
+	path, err := d.Download(strings.Repeat("a", 40), "x64")
+	if err != nil {
+		t.Fatalf("Download() error: %v", err)
+	}
+	if fallback.calls != 0 {
+		t.Errorf("fallback called %d times, want the host to supply the tarball", fallback.calls)
+	}
+	if data, _ := os.ReadFile(path); string(data) != string(body) {
+		t.Errorf("cached tarball = %q, want %q", data, body)
+	}
+	if got, err := readChecksum(path); err != nil || got != sha256Hex(body) {
+		t.Errorf("recorded checksum = %q, %v", got, err)
+	}
+	if filepath.Dir(path) != d.cacheDir {
+		t.Errorf("tarball cached at %s, want it in %s", path, d.cacheDir)
+	}
+
+	// A cache hit goes through the wrapped downloader, which handles --verify.
+	fallback.path = path
+	if got, err := d.Download(strings.Repeat("a", 40), "x64"); err != nil || got != path {
+		t.Errorf("second Download() = %q, %v", got, err)
+	}
+	if fallback.calls != 1 {
+		t.Errorf("fallback called %d times for a cache hit, want 1", fallback.calls)
+	}
+}
+
+func TestRelayDownloader_RecordsNoChecksumForUnverifiedTarball(t *testing.T) {
+	body := []byte("tarball bytes")
+	fallback := &fallbackDownloader{}
+	d := newRelayPair(t, body, sha256Hex(body), true, fallback)
+	d.EnableHost()
+	fallback.path = filepath.Join(d.cacheDir, tarballName(strings.Repeat("a", 40), LocalArtifact("x64")))
+
+	if _, err := d.Download(strings.Repeat("a", 40), "x64"); err != nil {
+		t.Fatalf("Download() error: %v", err)
+	}
+	if data, _ := os.ReadFile(fallback.path); string(data) != string(body) {
+		t.Errorf("cached tarball = %q, want %q", data, body)
+	}
+	if _, err := readChecksum(fallback.path); err == nil {
+		t.Error("recorded a checksum the host never verified")
+	}
+	if fallback.calls != 1 {
+		t.Errorf("fallback called %d times, want it to decide on the unverified tarball", fallback.calls)
+	}
+}
+
+func TestRelayDownloader_FallsBack(t *testing.T) {
+	body := []byte("tarball bytes")
+
+	t.Run("host did not offer tarballs", func(t *testing.T) {
+		fallback := &fallbackDownloader{path: "/direct"}
+		d := newRelayPair(t, body, sha256Hex(body), false, fallback)
+		if path, err := d.Download(strings.Repeat("a", 40), "x64"); err != nil || path != "/direct" {
+			t.Errorf("Download() = %q, %v; want the direct download", path, err)
+		}
+	})
+
+	t.Run("corrupt tarball", func(t *testing.T) {
+		fallback := &fallbackDownloader{path: "/direct"}
+		d := newRelayPair(t, body, sha256Hex([]byte("other")), false, fallback)
+		d.EnableHost()
+		if path, err := d.Download(strings.Repeat("a", 40), "x64"); err != nil || path != "/direct" {
+			t.Errorf("Download() = %q, %v; want the direct download", path, err)
+		}
+		entries, _ := os.ReadDir(d.cacheDir)
+		for _, e := range entries {
+			if !strings.HasPrefix(e.Name(), ".lock-") {
+				t.Errorf("cache holds %s after a corrupt transfer", e.Name())
+			}
+		}
+	})
+}
+
+func TestTarball_RefusesInvalidNames(t *testing.T) {
+	d := NewHTTPDownloader(t.TempDir(), Options{}, nopLogger{})
+	for _, req := range [][2]string{
+		{"../../etc", "server-linux-x64"},
+		{strings.Repeat("a", 40), "../server"},
+		{strings.Repeat("a", 40), "server-linux-x64/../../x"},
+	} {
+		if _, _, err := d.Tarball(req[0], req[1]); err == nil {
+			t.Errorf("Tarball(%q, %q) accepted an invalid name", req[0], req[1])
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"codetap/internal/domain"
+)
+
+// Store adds a tarball obtained without downloading it, such as from an
+// offline bundle, to the cache in cacheDir. The tarball is read from r and
+// must match sum; nothing is cached otherwise. Unless verified says sum was
+// published for the build, no checksum is recorded for it. A build that is
+// already cached is kept and r is drained.
+func Store(cacheDir, commit, artifact string, r io.Reader, sum string, verified bool, logger domain.Logger) (string, error) {
+	if !commitRe.MatchString(commit) {
+		return "", fmt.Errorf("invalid commit %q", commit)
+	}
+	if !artifactRe.MatchString(artifact) {
+		return "", fmt.Errorf("invalid artifact %q", artifact)
+	}
+	read := false
+	path, err := receive(cacheDir, commit, artifact, logger, func(w io.Writer) (string, bool, error) {
+		read = true
+		_, err := io.Copy(w, r)
+		return sum, verified, err
+	})
+	if err == nil && !read {
+		logger.Info("tarball already cached", "path", path)
+		_, err = io.Copy(io.Discard, r)
+	}
+	return path, err
+}
+
+// fileExists reports whether path exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// receive caches the tarball that fill writes as <commit>-<artifact>.tar.gz
+// in cacheDir, holding the build's lock. fill returns the SHA-256 the
+// tarball must have and whether it is a published one; it may learn them
+// only after writing. Only a published sum is recorded as the tarball's
+// checksum. If the build is already cached, fill is not called.
+func receive(cacheDir, commit, artifact string, logger domain.Logger, fill func(io.Writer) (string, bool, error)) (string, error) {
+	dest := filepath.Join(cacheDir, tarballName(commit, artifact))
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", fmt.Errorf("create cache dir: %w", err)
+	}
+	lock, err := lockBuild(cacheDir, commit, artifact, logger)
+	if err != nil {
+		return "", err
+	}
+	defer lock.Release()
+	if fileExists(dest) {
+		return dest, nil
+	}
+
+	// A partial HTTP download cannot be resumed from another source.
+	tmpPath := filepath.Join(cacheDir, ".download-"+filepath.Base(dest))
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("create temp file: %w", err)
+	}
+	h := sha256.New()
+	want, verified, err := fill(io.MultiWriter(f, h))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	got := hex.EncodeToString(h.Sum(nil))
+	if got != want {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("tarball %s is corrupt: sha256 %s, expected %s", filepath.Base(dest), got, want)
+	}
+
+	if err := os.Rename(tmpPath, dest); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("rename tarball: %w", err)
+	}
+	if !verified {
+		logger.Error("cached tarball that was never verified against a published checksum", "path", dest)
+		return dest, nil
+	}
+	if err := writeChecksum(dest, got); err != nil {
+		logger.Error("record checksum failed", "path", dest, "err", err)
+	}
+	return dest, nil
+}
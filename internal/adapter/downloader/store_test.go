@@ -0,0 +1,74 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	commit := strings.Repeat("a", 40)
+	body := []byte("server tarball")
+
+	t.Run("caches a matching tarball", func(t *testing.T) {
+		dir := t.TempDir()
+		path, err := Store(dir, commit, "server-linux-x64", strings.NewReader(string(body)), sha256Hex(body), true, nopLogger{})
+		if err != nil {
+			t.Fatalf("Store() error: %v", err)
+		}
+		if want := filepath.Join(dir, commit+"-server-linux-x64.tar.gz"); path != want {
+			t.Errorf("Store() = %q, want %q", path, want)
+		}
+		if got, err := readChecksum(path); err != nil || got != sha256Hex(body) {
+			t.Errorf("recorded checksum = %q, %v", got, err)
+		}
+	})
+
+	t.Run("records no checksum for an unverified tarball", func(t *testing.T) {
+		path, err := Store(t.TempDir(), commit, "server-linux-x64", strings.NewReader(string(body)), sha256Hex(body), false, nopLogger{})
+		if err != nil {
+			t.Fatalf("Store() error: %v", err)
+		}
+		if _, err := readChecksum(path); err == nil {
+			t.Error("recorded a checksum that was never published")
+		}
+	})
+
+	t.Run("refuses a mismatch", func(t *testing.T) {
+		dir := t.TempDir()
+		if _, err := Store(dir, commit, "server-linux-x64", strings.NewReader("tampered"), sha256Hex(body), true, nopLogger{}); err == nil {
+			t.Fatal("Store() accepted a tarball that does not match its checksum")
+		}
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			if !strings.HasPrefix(e.Name(), ".lock-") {
+				t.Errorf("cache holds %s after a mismatch", e.Name())
+			}
+		}
+	})
+
+	t.Run("keeps a cached build", func(t *testing.T) {
+		dir := t.TempDir()
+		cached := filepath.Join(dir, commit+"-server-linux-x64.tar.gz")
+		if err := os.WriteFile(cached, body, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		r := strings.NewReader("other bytes")
+		if _, err := Store(dir, commit, "server-linux-x64", r, "0", true, nopLogger{}); err != nil {
+			t.Fatalf("Store() error: %v", err)
+		}
+		if r.Len() != 0 {
+			t.Error("Store() did not drain the reader")
+		}
+		if data, _ := os.ReadFile(cached); string(data) != string(body) {
+			t.Errorf("cached tarball = %q, want it kept", data)
+		}
+	})
+
+	t.Run("refuses invalid names", func(t *testing.T) {
+		if _, err := Store(t.TempDir(), commit, "../server", strings.NewReader(""), "", true, nopLogger{}); err == nil {
+			t.Error("Store() accepted an invalid artifact")
+		}
+	})
+}
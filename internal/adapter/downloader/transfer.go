@@ -0,0 +1,264 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"codetap/internal/domain"
+)
+
+// Defaults for Options fields left zero.
+const (
+	defaultConnectTimeout = 30 * time.Second
+	defaultIdleTimeout    = 60 * time.Second
+	defaultRetries        = 5
+)
+
+// retryBackoff is the delay before the first retry; it doubles with every
+// further attempt up to maxRetryBackoff.
+var (
+	retryBackoff    = time.Second
+	maxRetryBackoff = 30 * time.Second
+)
+
+// progressInterval is how often a running download logs its progress.
+var progressInterval = 5 * time.Second
+
+// errNotFound reports a 404 for the tarball; retrying cannot help.
+var errNotFound = errors.New("not found")
+
+// permanentError wraps failures that retrying would not fix.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// newHTTPClient builds the client for downloads and API calls. There is no
+// overall request timeout, which would cap how long a large tarball may take
+// on a slow link; stalls are caught by the idle timeout instead.
+func newHTTPClient(opts Options) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   opts.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = opts.ConnectTimeout
+	if opts.TLS != nil {
+		transport.TLSClientConfig = opts.TLS
+	}
+	transport.ResponseHeaderTimeout = opts.IdleTimeout
+	return &http.Client{Transport: transport}
+}
+
+// fetch downloads url into partPath and returns the SHA-256 of the complete
+// file. Bytes already in partPath from an earlier attempt or an earlier run
+// are kept and the rest is requested with a Range header. Transient failures
+// are retried with exponential backoff. resumed reports whether the result
+// was stitched together from more than one response.
+func (d *HTTPDownloader) fetch(url, partPath string) (sum string, resumed bool, err error) {
+	for attempt := 0; ; attempt++ {
+		var r bool
+		sum, r, err = d.fetchOnce(url, partPath)
+		resumed = resumed || r
+		if err == nil {
+			return sum, resumed, nil
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return "", resumed, perm.err
+		}
+		if attempt >= d.opts.Retries {
+			return "", resumed, fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+		}
+		delay := min(retryBackoff<<attempt, maxRetryBackoff)
+		d.logger.Error("download interrupted, retrying", "attempt", attempt+1, "retry_in", delay, "err", err)
+		time.Sleep(delay)
+	}
+}
+
+// fetchOnce makes one request for the missing part of partPath.
+func (d *HTTPDownloader) fetchOnce(url, partPath string) (string, bool, error) {
+	f, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return "", false, &permanentError{fmt.Errorf("open temp file: %w", err)}
+	}
+	defer f.Close()
+
+	// Hash what is already there so the sum covers the whole file.
+	h := sha256.New()
+	offset, err := io.Copy(h, f)
+	if err != nil {
+		return "", false, &permanentError{fmt.Errorf("read temp file: %w", err)}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", false, &permanentError{err}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if start := contentRangeStart(resp.Header.Get("Content-Range")); start != offset {
+			_ = f.Truncate(0)
+			return "", false, fmt.Errorf("server resumed at byte %d instead of %d", start, offset)
+		}
+		d.logger.Info("resuming download", "offset", FormatBytes(offset))
+	case resp.StatusCode == http.StatusOK:
+		if offset > 0 {
+			d.logger.Info("server does not support resuming, starting over", "discarded", FormatBytes(offset))
+			if err := f.Truncate(0); err != nil {
+				return "", false, &permanentError{fmt.Errorf("truncate temp file: %w", err)}
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return "", false, &permanentError{fmt.Errorf("rewind temp file: %w", err)}
+			}
+			h.Reset()
+			offset = 0
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is as long as or longer than the tarball now on
+		// the server; it cannot be trusted.
+		_ = f.Truncate(0)
+		return "", false, fmt.Errorf("server rejected resume at byte %d", offset)
+	case resp.StatusCode == http.StatusNotFound:
+		return "", false, &permanentError{errNotFound}
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return "", false, fmt.Errorf("download returned HTTP %d", resp.StatusCode)
+	default:
+		return "", false, &permanentError{fmt.Errorf("download returned HTTP %d", resp.StatusCode)}
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	body := newIdleReader(resp.Body, d.opts.IdleTimeout, cancel)
+	defer body.stop()
+	p := &progress{logger: d.logger, done: offset, total: total, start: time.Now(), startDone: offset}
+
+	_, err = io.Copy(io.MultiWriter(f, h, p), body)
+	if body.idle.Load() {
+		err = fmt.Errorf("no data received for %v", d.opts.IdleTimeout)
+	}
+	if err != nil {
+		return "", offset > 0, fmt.Errorf("write tarball: %w", err)
+	}
+	if total >= 0 && p.done != total {
+		return "", offset > 0, fmt.Errorf("download ended at byte %d of %d", p.done, total)
+	}
+	return hex.EncodeToString(h.Sum(nil)), offset > 0, nil
+}
+
+// contentRangeStart parses the first byte position of "bytes <start>-<end>/<size>".
+func contentRangeStart(header string) int64 {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return -1
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// idleReader cancels the request when no data arrives for timeout.
+type idleReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	idle    atomic.Bool
+}
+
+func newIdleReader(r io.Reader, timeout time.Duration, cancel func()) *idleReader {
+	ir := &idleReader{r: r, timeout: timeout}
+	ir.timer = time.AfterFunc(timeout, func() {
+		ir.idle.Store(true)
+		cancel()
+	})
+	return ir
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if n > 0 {
+		ir.timer.Reset(ir.timeout)
+	}
+	return n, err
+}
+
+func (ir *idleReader) stop() { ir.timer.Stop() }
+
+// progress logs bytes, rate and ETA at most once per progressInterval.
+type progress struct {
+	logger      domain.Logger
+	done, total int64
+	start       time.Time
+	startDone   int64 // bytes present before this request
+	last        time.Time
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	now := time.Now()
+	if p.last.IsZero() {
+		p.last = now
+	}
+	if now.Sub(p.last) < progressInterval {
+		return len(b), nil
+	}
+	p.last = now
+
+	elapsed := now.Sub(p.start).Seconds()
+	rate := float64(p.done-p.startDone) / elapsed
+	args := []any{"downloaded", FormatBytes(p.done), "rate", FormatBytes(int64(rate)) + "/s"}
+	if p.total > 0 {
+		args = append(args, "total", FormatBytes(p.total), "percent", p.done*100/p.total)
+		if rate > 0 {
+			eta := time.Duration(float64(p.total-p.done)/rate) * time.Second
+			args = append(args, "eta", eta.Round(time.Second))
+		}
+	}
+	p.logger.Info("download progress", args...)
+	return len(b), nil
+}
+
+// FormatBytes renders n with a binary unit, e.g. "61.2 MiB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
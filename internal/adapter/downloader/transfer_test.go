@@ -0,0 +1,188 @@
+package downloader
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	retryBackoff = time.Millisecond
+}
+
+// flakyServer serves body, failing each request as told by fail, which gets
+// the request number (from 0) and may write a response itself.
+type flakyServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	ranges []string // Range header of each request
+}
+
+func newFlakyServer(t *testing.T, body []byte, fail func(n int, w http.ResponseWriter, r *http.Request) bool) *flakyServer {
+	t.Helper()
+	f := &flakyServer{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			http.NotFound(w, r) // no checksum: these tests cover the transfer only
+			return
+		}
+		f.mu.Lock()
+		n := len(f.ranges)
+		f.ranges = append(f.ranges, r.Header.Get("Range"))
+		f.mu.Unlock()
+		if fail != nil && fail(n, w, r) {
+			return
+		}
+		http.ServeContent(w, r, "server.tar.gz", time.Time{}, bytes.NewReader(body))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *flakyServer) downloader(t *testing.T, opts Options) *HTTPDownloader {
+	opts.UpdateAPI = f.URL
+	opts.InsecureSkipVerify = true // the server publishes no checksum
+	d := NewHTTPDownloader(t.TempDir(), opts, nopLogger{})
+	return d
+}
+
+func checkDownloaded(t *testing.T, path string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("downloaded %d bytes, want %d matching bytes", len(got), len(want))
+	}
+}
+
+// truncate sends the headers for the whole body but only half of it, then
+// drops the connection.
+func truncate(body []byte) func(int, http.ResponseWriter, *http.Request) bool {
+	return func(n int, w http.ResponseWriter, _ *http.Request) bool {
+		if n > 0 {
+			return false
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, _ = w.Write(body[:len(body)/2])
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func TestDownload_ResumesInterruptedTransfer(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 10000)
+	srv := newFlakyServer(t, body, truncate(body))
+	d := srv.downloader(t, Options{})
+
+	path, err := d.Download("abc", "x64")
+	if err != nil {
+		t.Fatalf("Download() error: %v", err)
+	}
+	checkDownloaded(t, path, body)
+	if len(srv.ranges) != 2 || srv.ranges[1] != "bytes="+strconv.Itoa(len(body)/2)+"-" {
+		t.Errorf("Range headers = %q, want a second request resuming at byte %d", srv.ranges, len(body)/2)
+	}
+	if _, err := os.Stat(filepath.Join(d.cacheDir, ".download-"+filepath.Base(path))); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+}
+
+func TestDownload_ResumesAcrossRuns(t *testing.T) {
+	body := bytes.Repeat([]byte("abcdefghij"), 1000)
+	srv := newFlakyServer(t, body, truncate(body))
+	d := srv.downloader(t, Options{Retries: -1})
+
+	if _, err := d.Download("abc", "x64"); err == nil {
+		t.Fatal("Download() without retries succeeded despite the dropped connection")
+	}
+	path, err := d.Download("abc", "x64")
+	if err != nil {
+		t.Fatalf("second Download() error: %v", err)
+	}
+	checkDownloaded(t, path, body)
+	if srv.ranges[1] == "" {
+		t.Error("second run did not resume the partial file")
+	}
+}
+
+func TestDownload_RestartsWhenRangeIgnored(t *testing.T) {
+	body := bytes.Repeat([]byte("z"), 5000)
+	srv := newFlakyServer(t, body, func(n int, w http.ResponseWriter, r *http.Request) bool {
+		if n == 0 {
+			return truncate(body)(n, w, r)
+		}
+		_, _ = w.Write(body)
+		return true
+	})
+	d := srv.downloader(t, Options{})
+
+	path, err := d.Download("abc", "x64")
+	if err != nil {
+		t.Fatalf("Download() error: %v", err)
+	}
+	checkDownloaded(t, path, body)
+}
+
+func TestDownload_RetriesServerErrorsAndStalls(t *testing.T) {
+	body := []byte("tarball")
+	srv := newFlakyServer(t, body, func(n int, w http.ResponseWriter, _ *http.Request) bool {
+		switch n {
+		case 0:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		case 1:
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond) // stall past the idle timeout
+			return true
+		}
+		return false
+	})
+	d := srv.downloader(t, Options{IdleTimeout: 50 * time.Millisecond})
+
+	path, err := d.Download("abc", "x64")
+	if err != nil {
+		t.Fatalf("Download() error: %v", err)
+	}
+	checkDownloaded(t, path, body)
+	if len(srv.ranges) != 3 {
+		t.Errorf("made %d requests, want 3", len(srv.ranges))
+	}
+}
+
+func TestDownload_NotFoundIsNotRetried(t *testing.T) {
+	srv := newFlakyServer(t, nil, func(_ int, w http.ResponseWriter, r *http.Request) bool {
+		http.NotFound(w, r)
+		return true
+	})
+	d := srv.downloader(t, Options{})
+
+	_, err := d.Download("abc", "x64")
+	if err == nil || !strings.Contains(err.Error(), "not found for artifact") {
+		t.Fatalf("Download() error = %v, want not found", err)
+	}
+	if len(srv.ranges) != 1 {
+		t.Errorf("made %d requests for a 404, want 1", len(srv.ranges))
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		512:              "512 B",
+		2048:             "2.0 KiB",
+		61*1024*1024 + 1: "61.0 MiB",
+	} {
+		if got := FormatBytes(n); got != want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
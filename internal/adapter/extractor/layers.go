@@ -0,0 +1,40 @@
+package extractor
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// SetLayers makes the extractor look for servers in read-only repositories
+// before its own, in order. A layer uses the same <layer>/<commit>/ layout
+// and is never written to: servers missing from every layer are still
+// extracted into the writable repository.
+func (e *TarExtractor) SetLayers(layers []string) {
+	e.layers = nil
+	for _, dir := range layers {
+		if dir == "" || filepath.Clean(dir) == filepath.Clean(e.repoBaseDir) {
+			continue
+		}
+		e.layers = append(e.layers, dir)
+	}
+}
+
+// findServer returns the directory holding the server for commit, searching
+// the layers first, or "" if no repository has it.
+func (e *TarExtractor) findServer(commit string) string {
+	for _, dir := range e.layers {
+		if serverDir := filepath.Join(dir, commit); isServer(serverDir) {
+			return serverDir
+		}
+	}
+	if serverDir := e.ServerDir(commit); isServer(serverDir) {
+		return serverDir
+	}
+	return ""
+}
+
+// isServer reports whether dir holds an extracted server.
+func isServer(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, "bin", "code-server"))
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,73 @@
+package extractor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeServer(t *testing.T, repo, commit string) string {
+	t.Helper()
+	bin := filepath.Join(repo, commit, "bin", "code-server")
+	if err := os.MkdirAll(filepath.Dir(bin), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return bin
+}
+
+func TestLayers_SearchedBeforeRepository(t *testing.T) {
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "repository")
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	e := NewTarExtractor(repo, nopLogger{})
+	e.SetLayers([]string{first, "", second})
+
+	inSecond := writeServer(t, second, "abc")
+	writeServer(t, repo, "abc")
+	if !e.IsProvisioned("abc") || e.ServerBinPath("abc") != inSecond {
+		t.Errorf("ServerBinPath() = %q, want the layer's %q", e.ServerBinPath("abc"), inSecond)
+	}
+	inFirst := writeServer(t, first, "abc")
+	if got := e.ServerBinPath("abc"); got != inFirst {
+		t.Errorf("ServerBinPath() = %q, want the first layer's %q", got, inFirst)
+	}
+
+	inRepo := writeServer(t, repo, "def")
+	if !e.IsProvisioned("def") || e.ServerBinPath("def") != inRepo {
+		t.Errorf("ServerBinPath() = %q, want the repository's %q", e.ServerBinPath("def"), inRepo)
+	}
+
+	if e.IsProvisioned("missing") {
+		t.Error("IsProvisioned() = true for a server no repository has")
+	}
+	if want := filepath.Join(repo, "missing", "bin", "code-server"); e.ServerBinPath("missing") != want {
+		t.Errorf("ServerBinPath() = %q, want %q in the writable repository", e.ServerBinPath("missing"), want)
+	}
+}
+
+func TestLayers_ExtractsIntoRepository(t *testing.T) {
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "repository")
+	layer := filepath.Join(dir, "layer")
+	if err := os.Mkdir(layer, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	tarball := filepath.Join(dir, "server.tar.gz")
+	writeTarGz(t, tarball, serverEntries)
+
+	e := NewTarExtractor(repo, nopLogger{})
+	e.SetLayers([]string{layer})
+	if err := e.Extract(tarball, e.ServerDir("abc")); err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+	if want := filepath.Join(repo, "abc", "bin", "code-server"); e.ServerBinPath("abc") != want {
+		t.Errorf("ServerBinPath() = %q, want %q", e.ServerBinPath("abc"), want)
+	}
+	if entries, _ := os.ReadDir(layer); len(entries) != 0 {
+		t.Errorf("layer holds %d entries, want it left untouched", len(entries))
+	}
+	_ = os.Chmod(filepath.Join(repo, "abc", "bin", "helpers"), 0o755)
+}
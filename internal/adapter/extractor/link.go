@@ -0,0 +1,77 @@
+package extractor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Link installs the server in srcDir, an existing install elsewhere on the
+// same filesystem, into targetDir by hard-linking its files, so that it
+// takes no extra space and survives the removal of srcDir. Like Extract it
+// holds targetDir's lock and renames the result into place atomically.
+// Linking across filesystems fails, and nothing is installed then.
+func (e *TarExtractor) Link(srcDir, targetDir string) error {
+	if !isServer(srcDir) {
+		return fmt.Errorf("%s holds no bin/code-server", srcDir)
+	}
+	linked := false
+	err := e.install(targetDir, func(tmpDir string) error {
+		if err := linkTree(srcDir, tmpDir); err != nil {
+			return fmt.Errorf("link %s: %w", srcDir, err)
+		}
+		linked = true
+		return nil
+	})
+	if err == nil && linked {
+		e.logger.Info("linked existing server", "source", srcDir, "path", targetDir)
+	}
+	return err
+}
+
+// linkTree recreates the tree under src in dest, which must exist:
+// directories and symlinks are copied, regular files hard-linked. Other
+// file types are skipped.
+func linkTree(src, dest string) error {
+	var dirs []dirMode
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		switch {
+		case d.IsDir():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if err := os.Mkdir(target, 0o755); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirMode{target, info.Mode().Perm()})
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return os.Link(path, target)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package extractor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLink_HardLinksTree(t *testing.T) {
+	dir := t.TempDir()
+	tarball := filepath.Join(dir, "server.tar.gz")
+	writeTarGz(t, tarball, serverEntries)
+	src := NewTarExtractor(filepath.Join(dir, "src"), nopLogger{})
+	if err := src.Extract(tarball, src.ServerDir("abc")); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := filepath.Join(dir, "repository")
+	e := NewTarExtractor(repo, nopLogger{})
+	if err := e.Link(src.ServerDir("abc"), e.ServerDir("abc")); err != nil {
+		t.Fatalf("Link() error: %v", err)
+	}
+	if !e.IsProvisioned("abc") {
+		t.Fatal("IsProvisioned() = false after Link")
+	}
+	a, _ := os.Stat(filepath.Join(repo, "abc", "node"))
+	b, _ := os.Stat(filepath.Join(dir, "src", "abc", "node"))
+	if a == nil || b == nil || !os.SameFile(a, b) {
+		t.Error("node is not hard-linked")
+	}
+	if link, err := os.Readlink(filepath.Join(repo, "abc", "bin", "helpers", "node")); err != nil || link != "../../node" {
+		t.Errorf("symlink = %q, %v; want ../../node", link, err)
+	}
+	if info, err := os.Stat(filepath.Join(repo, "abc", "bin", "helpers")); err != nil || info.Mode().Perm() != 0o555 {
+		t.Errorf("helpers mode = %v, %v; want 0555", info.Mode().Perm(), err)
+	}
+
+	if err := e.Link(filepath.Join(dir, "missing"), e.ServerDir("def")); err == nil {
+		t.Error("Link() accepted a directory without a server")
+	}
+	_ = os.Chmod(filepath.Join(repo, "abc", "bin", "helpers"), 0o755)
+	_ = os.Chmod(filepath.Join(dir, "src", "abc", "bin", "helpers"), 0o755)
+}
@@ -0,0 +1,88 @@
+package extractor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"codetap/internal/adapter/flock"
+)
+
+// staleExtractAge is how old a temporary directory from an older codetap,
+// which does not name its target, must be before it is removed.
+var staleExtractAge = time.Hour
+
+// lock takes the cross-process lock for extracting into targetDir.
+func (e *TarExtractor) lock(targetDir string) (*flock.Lock, error) {
+	path := lockPath(targetDir)
+	l, ok, err := flock.TryAcquire(path)
+	if err != nil || ok {
+		return l, err
+	}
+	e.logger.Info("waiting for another process extracting the same server", "lock", path)
+	return flock.Acquire(path)
+}
+
+func lockPath(targetDir string) string {
+	return filepath.Join(filepath.Dir(targetDir), ".lock-"+filepath.Base(targetDir))
+}
+
+// removeStaleExtracts deletes .extract-<target>-* temporary directories left
+// by interrupted extractions, with the lock for targetDir held. Leftovers
+// for targetDir are stale by definition; those for other targets are stale
+// if nobody holds their lock.
+func (e *TarExtractor) removeStaleExtracts(targetDir string) {
+	base := filepath.Dir(targetDir)
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		return
+	}
+	for _, d := range entries {
+		rest, ok := strings.CutPrefix(d.Name(), ".extract-")
+		if !ok || !d.IsDir() {
+			continue
+		}
+		path := filepath.Join(base, d.Name())
+		target := ""
+		if i := strings.LastIndex(rest, "-"); i > 0 {
+			target = rest[:i]
+		}
+		switch {
+		case target == filepath.Base(targetDir):
+		case target == "":
+			if info, err := d.Info(); err != nil || time.Since(info.ModTime()) < staleExtractAge {
+				continue
+			}
+		default:
+			l, ok, err := flock.TryAcquire(lockPath(filepath.Join(base, target)))
+			if err != nil || !ok {
+				continue
+			}
+			e.removeStale(path)
+			l.Release()
+			continue
+		}
+		e.removeStale(path)
+	}
+}
+
+func (e *TarExtractor) removeStale(path string) {
+	if err := removeAll(path); err != nil {
+		e.logger.Error("remove stale extraction failed", "path", path, "err", err)
+		return
+	}
+	e.logger.Info("removed stale extraction", "path", path)
+}
+
+// removeAll is os.RemoveAll for trees with read-only directories, which
+// extraction preserves from the tarball.
+func removeAll(path string) error {
+	_ = filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			_ = os.Chmod(p, 0o755)
+		}
+		return nil
+	})
+	return os.RemoveAll(path)
+}
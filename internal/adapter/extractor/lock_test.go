@@ -0,0 +1,83 @@
+package extractor
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExtract_ConcurrentRunsExtractOnce(t *testing.T) {
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "repository")
+	tarball := filepath.Join(dir, "server.tar.gz")
+	writeTarGz(t, tarball, serverEntries)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e := NewTarExtractor(repo, nopLogger{})
+			if err := e.Extract(tarball, e.ServerDir("abc")); err != nil {
+				t.Errorf("Extract() error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, _ := os.ReadDir(repo)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 2 || names[0] != ".lock-abc" || names[1] != "abc" {
+		t.Errorf("repository = %v, want the server and its lock file", names)
+	}
+	_ = os.Chmod(filepath.Join(repo, "abc", "bin", "helpers"), 0o755)
+}
+
+func TestExtract_RemovesStaleExtracts(t *testing.T) {
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "repository")
+	e := NewTarExtractor(repo, nopLogger{})
+	tarball := filepath.Join(dir, "server.tar.gz")
+	writeTarGz(t, tarball, serverEntries)
+
+	old := time.Now().Add(-2 * staleExtractAge)
+	dirs := map[string]bool{ // name → want removed
+		".extract-abc-111":  true,  // interrupted extraction of this target
+		".extract-def-222":  true,  // of another target, nobody extracting it
+		".extract-busy-333": false, // of a target being extracted right now
+		".extract-444":      true,  // from an older codetap, long abandoned
+		".extract-555":      false, // from an older codetap, maybe still running
+	}
+	for name := range dirs {
+		if err := os.MkdirAll(filepath.Join(repo, name, "bin"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(repo, ".extract-def-222", "bin"), 0o555); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(repo, ".extract-444"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	busy, err := e.lock(e.ServerDir("busy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Release()
+
+	if err := e.Extract(tarball, e.ServerDir("abc")); err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+	for name, wantRemoved := range dirs {
+		_, err := os.Stat(filepath.Join(repo, name))
+		if removed := os.IsNotExist(err); removed != wantRemoved {
+			t.Errorf("%s removed = %v, want %v", name, removed, wantRemoved)
+		}
+	}
+	_ = os.Chmod(filepath.Join(repo, "abc", "bin", "helpers"), 0o755)
+}
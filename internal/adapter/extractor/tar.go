@@ -3,15 +3,16 @@ package extractor
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 
 	"codetap/internal/domain"
 )
 
-// TarExtractor extracts VS Code Server tarballs using the system tar command.
+// TarExtractor extracts VS Code Server tarballs. Extraction is pure Go, so it
+// works on distroless and BusyBox images without a suitable tar binary.
 type TarExtractor struct {
 	repoBaseDir string
+	layers      []string // read-only repositories searched first, see SetLayers
 	logger      domain.Logger
 }
 
@@ -23,64 +24,88 @@ func NewTarExtractor(repoBaseDir string, logger domain.Logger) *TarExtractor {
 	}
 }
 
-// IsProvisioned checks if the server binary exists for the given commit.
+// IsProvisioned checks if the server binary exists for the given commit, in
+// a read-only layer or in the writable repository.
 func (e *TarExtractor) IsProvisioned(commit string) bool {
-	bin := e.ServerBinPath(commit)
-	info, err := os.Stat(bin)
-	return err == nil && !info.IsDir()
+	return e.findServer(commit) != ""
 }
 
-// ServerBinPath returns the path to the code-server binary for a commit.
+// ServerBinPath returns the path to the code-server binary for a commit. A
+// server in a read-only layer is preferred over one in the repository.
 func (e *TarExtractor) ServerBinPath(commit string) string {
-	return filepath.Join(e.repoBaseDir, commit, "bin", "code-server")
+	dir := e.findServer(commit)
+	if dir == "" {
+		dir = e.ServerDir(commit)
+	}
+	return filepath.Join(dir, "bin", "code-server")
 }
 
-// ServerDir returns the directory for an extracted server for the given commit.
+// ServerDir returns the directory in the writable repository that a server
+// for the given commit is extracted into.
 func (e *TarExtractor) ServerDir(commit string) string {
 	return filepath.Join(e.repoBaseDir, commit)
 }
 
 // Extract unpacks the tarball into repoBaseDir/<commit>/.
 // The MS tarball has a top-level directory that is stripped.
+//
+// Concurrent extractions into the same directory, also from other processes
+// sharing the repository, are serialized by a lock file; the later ones wait
+// and then find the server extracted.
 func (e *TarExtractor) Extract(tarballPath, targetDir string) error {
-	bin := filepath.Join(targetDir, "bin", "code-server")
-	if info, err := os.Stat(bin); err == nil && !info.IsDir() {
-		e.logger.Info("server already extracted", "path", targetDir)
+	extracted := false
+	err := e.install(targetDir, func(tmpDir string) error {
+		e.logger.Info("extracting server", "tarball", tarballPath, "target", targetDir)
+
+		if err := untarGz(tarballPath, tmpDir, 1); err != nil {
+			return fmt.Errorf("extract %s: %w — corrupt download? delete it and retry", tarballPath, err)
+		}
+
+		// Verify the binary exists in extracted output
+		if !isServer(tmpDir) {
+			return fmt.Errorf("extracted tarball missing bin/code-server — corrupt download? delete %s and retry", tarballPath)
+		}
+		extracted = true
 		return nil
+	})
+	if err == nil && extracted {
+		e.logger.Info("extraction complete", "path", targetDir)
 	}
+	return err
+}
 
+// install fills a temporary directory next to targetDir and renames it into
+// place, holding targetDir's lock. If another process installed the server
+// meanwhile, fill is not called.
+func (e *TarExtractor) install(targetDir string, fill func(tmpDir string) error) error {
 	if err := os.MkdirAll(filepath.Dir(targetDir), 0755); err != nil {
 		return fmt.Errorf("create server base dir: %w", err)
 	}
-
-	// Extract to a temp dir first, then rename atomically
-	tmpDir, err := os.MkdirTemp(filepath.Dir(targetDir), ".extract-*")
+	lock, err := e.lock(targetDir)
 	if err != nil {
-		return fmt.Errorf("create temp dir: %w", err)
+		return err
 	}
+	defer lock.Release()
+	e.removeStaleExtracts(targetDir)
 
-	e.logger.Info("extracting server", "tarball", tarballPath, "target", targetDir)
-
-	cmd := exec.Command("tar", "xzf", tarballPath, "-C", tmpDir, "--strip-components=1")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		os.RemoveAll(tmpDir)
-		return fmt.Errorf("tar extract: %w", err)
+	if isServer(targetDir) {
+		e.logger.Info("server already extracted", "path", targetDir)
+		return nil
 	}
 
-	// Verify the binary exists in extracted output
-	extractedBin := filepath.Join(tmpDir, "bin", "code-server")
-	if _, err := os.Stat(extractedBin); err != nil {
-		os.RemoveAll(tmpDir)
-		return fmt.Errorf("extracted tarball missing bin/code-server — corrupt download? delete %s and retry", tarballPath)
+	// Fill a temp dir first, then rename atomically. The name carries the
+	// target so that leftovers can be matched to its lock.
+	tmpDir, err := os.MkdirTemp(filepath.Dir(targetDir), ".extract-"+filepath.Base(targetDir)+"-*")
+	if err != nil {
+		return fmt.Errorf("create temp dir: %w", err)
+	}
+	if err := fill(tmpDir); err != nil {
+		removeAll(tmpDir)
+		return err
 	}
-
 	if err := os.Rename(tmpDir, targetDir); err != nil {
-		os.RemoveAll(tmpDir)
+		removeAll(tmpDir)
 		return fmt.Errorf("rename extracted dir: %w", err)
 	}
-
-	e.logger.Info("extraction complete", "path", targetDir)
 	return nil
 }
@@ -0,0 +1,227 @@
+package extractor
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// untarGz unpacks the gzip-compressed tarball at tarballPath into dest,
+// dropping the first strip path components of every entry like tar's
+// --strip-components. Entries whose names or link targets would land
+// outside dest are refused, and so is writing through a symlink created by
+// an earlier entry.
+func untarGz(tarballPath, dest string, strip int) error {
+	f, err := os.Open(tarballPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("gzip: %w", err)
+	}
+	defer gz.Close()
+	return untar(gz, dest, strip)
+}
+
+// dirMode remembers a directory's mode so it is applied after its contents
+// have been written; a read-only directory would otherwise block them.
+type dirMode struct {
+	path string
+	mode os.FileMode
+}
+
+func untar(r io.Reader, dest string, strip int) error {
+	tr := tar.NewReader(r)
+	var dirs []dirMode
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar: %w", err)
+		}
+
+		name, err := entryName(hdr.Name, strip)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue // a stripped leading directory
+		}
+		target := filepath.Join(dest, filepath.FromSlash(name))
+		if err := checkParents(dest, target); err != nil {
+			return err
+		}
+		mode := hdr.FileInfo().Mode().Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			// Never chmod through an existing symlink or file.
+			if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+				return fmt.Errorf("tar entry %q: %s exists and is not a directory", hdr.Name, name)
+			}
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirMode{target, mode})
+		case tar.TypeReg, tar.TypeRegA:
+			if err := writeFile(target, tr, mode); err != nil {
+				return err
+			}
+			_ = os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+		case tar.TypeSymlink:
+			if err := checkLinkTarget(dest, name, hdr.Linkname); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			linkName, err := entryName(hdr.Linkname, strip)
+			if err != nil || linkName == "" {
+				return fmt.Errorf("tar entry %q: invalid hard link target %q", hdr.Name, hdr.Linkname)
+			}
+			source := filepath.Join(dest, filepath.FromSlash(linkName))
+			if err := checkParents(dest, source); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+			// PAX metadata for the whole archive; nothing to create.
+		default:
+			return fmt.Errorf("tar entry %q: unsupported type %q", hdr.Name, hdr.Typeflag)
+		}
+	}
+
+	// Innermost directories first, so a read-only parent does not block
+	// chmod on its children.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entryName validates a tar entry name and strips its leading components.
+// It returns "" for entries that are stripped away completely.
+func entryName(name string, strip int) (string, error) {
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(name) {
+		return "", fmt.Errorf("tar entry %q: absolute path", name)
+	}
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("tar entry %q: path escapes the target directory", name)
+	}
+	parts := strings.Split(clean, "/")
+	if clean == "." || len(parts) <= strip {
+		return "", nil
+	}
+	return strings.Join(parts[strip:], "/"), nil
+}
+
+// checkLinkTarget refuses absolute symlinks, relative ones that climb out
+// of the extracted tree, and ones whose target passes through a symlink
+// written earlier. The last would let a chain of links that each look
+// harmless, such as "a/x -> .." and "b -> a/x/..", resolve outside dest.
+// Since entries may come in any order, a ".." must also follow a component
+// that is already a directory: "b" may come first, before "a/x" exists.
+func checkLinkTarget(dest, name, linkname string) error {
+	if path.IsAbs(linkname) {
+		return fmt.Errorf("tar entry %q: absolute symlink target %q", name, linkname)
+	}
+	// Walk the target one component at a time, as the kernel resolves it.
+	// The link's own parents are directories, or are created as such.
+	var parts []string
+	var isDir []bool
+	if dir := path.Dir(name); dir != "." {
+		parts = strings.Split(dir, "/")
+		isDir = make([]bool, len(parts))
+		for i := range isDir {
+			isDir[i] = true
+		}
+	}
+	for _, part := range strings.Split(linkname, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(parts) == 0 {
+				return fmt.Errorf("tar entry %q: symlink target %q escapes the target directory", name, linkname)
+			}
+			if !isDir[len(isDir)-1] {
+				return fmt.Errorf("tar entry %q: symlink target %q climbs out of %q, which is not a directory", name, linkname, path.Join(parts...))
+			}
+			parts, isDir = parts[:len(parts)-1], isDir[:len(isDir)-1]
+			continue
+		}
+		parts = append(parts, part)
+		info, err := os.Lstat(filepath.Join(dest, filepath.Join(parts...)))
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("tar entry %q: symlink target %q passes through a symlink", name, linkname)
+		}
+		isDir = append(isDir, err == nil && info.IsDir())
+	}
+	return nil
+}
+
+// checkParents refuses a target whose parent directories inside dest include
+// a symlink, which an archive could use to write outside dest.
+func checkParents(dest, target string) error {
+	rel, err := filepath.Rel(dest, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return err
+	}
+	cur := dest
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		cur = filepath.Join(cur, part)
+		info, err := os.Lstat(cur)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("tar entry %q: parent %q is a symlink", target, cur)
+		}
+	}
+	return nil
+}
+
+func writeFile(target string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	// O_EXCL: an entry may not replace a file or symlink written earlier.
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// The umask applied at creation may have dropped bits.
+	return os.Chmod(target, mode)
+}
@@ -0,0 +1,198 @@
+package extractor
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+type entry struct {
+	name     string
+	typ      byte
+	mode     int64
+	body     string
+	linkname string
+}
+
+func tarball(t *testing.T, entries []entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typ, Mode: e.mode, Linkname: e.linkname, Size: int64(len(e.body))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeTarGz(t *testing.T, path string, entries []entry) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write(tarball(t, entries))
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serverEntries mimics the layout of a VS Code Server tarball.
+var serverEntries = []entry{
+	{name: "vscode-server-linux-x64/", typ: tar.TypeDir, mode: 0o755},
+	{name: "vscode-server-linux-x64/bin/", typ: tar.TypeDir, mode: 0o755},
+	{name: "vscode-server-linux-x64/bin/code-server", typ: tar.TypeReg, mode: 0o755, body: "#!/bin/sh\n"},
+	{name: "vscode-server-linux-x64/node", typ: tar.TypeReg, mode: 0o755, body: "ELF"},
+	{name: "vscode-server-linux-x64/product.json", typ: tar.TypeReg, mode: 0o644, body: "{}"},
+	{name: "vscode-server-linux-x64/bin/helpers/", typ: tar.TypeDir, mode: 0o555},
+	{name: "vscode-server-linux-x64/bin/helpers/node", typ: tar.TypeSymlink, linkname: "../../node"},
+	{name: "vscode-server-linux-x64/product-copy.json", typ: tar.TypeLink, linkname: "vscode-server-linux-x64/product.json"},
+}
+
+func TestUntar_StripsAndPreserves(t *testing.T) {
+	dest := t.TempDir()
+	if err := untar(bytes.NewReader(tarball(t, serverEntries)), dest, 1); err != nil {
+		t.Fatalf("untar() error: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dest, "bin", "code-server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o755 {
+		t.Errorf("code-server mode = %v, want 0755", info.Mode().Perm())
+	}
+	if info, _ := os.Stat(filepath.Join(dest, "product.json")); info.Mode().Perm() != 0o644 {
+		t.Errorf("product.json mode = %v, want 0644", info.Mode().Perm())
+	}
+	if info, _ := os.Stat(filepath.Join(dest, "bin", "helpers")); info.Mode().Perm() != 0o555 {
+		t.Errorf("helpers dir mode = %v, want 0555", info.Mode().Perm())
+	}
+	link, err := os.Readlink(filepath.Join(dest, "bin", "helpers", "node"))
+	if err != nil || link != "../../node" {
+		t.Errorf("symlink = %q, %v; want ../../node", link, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "bin", "helpers", "node")); string(data) != "ELF" {
+		t.Errorf("symlink resolves to %q, want the node binary", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "product-copy.json")); string(data) != "{}" {
+		t.Errorf("hard link content = %q", data)
+	}
+	_ = os.Chmod(filepath.Join(dest, "bin", "helpers"), 0o755) // let TempDir clean up
+}
+
+func TestUntar_RefusesEscapes(t *testing.T) {
+	cases := map[string][]entry{
+		"dot-dot": {
+			{name: "top/../../evil", typ: tar.TypeReg, mode: 0o644, body: "x"},
+		},
+		"absolute": {
+			{name: "/etc/evil", typ: tar.TypeReg, mode: 0o644, body: "x"},
+		},
+		"absolute symlink": {
+			{name: "top/passwd", typ: tar.TypeSymlink, linkname: "/etc/passwd"},
+		},
+		"escaping symlink": {
+			{name: "top/up", typ: tar.TypeSymlink, linkname: "../../.."},
+		},
+		"write through symlink": {
+			{name: "top/dir", typ: tar.TypeSymlink, linkname: "."},
+			{name: "top/dir/file", typ: tar.TypeReg, mode: 0o644, body: "x"},
+		},
+		"replace symlink": {
+			{name: "top/link", typ: tar.TypeSymlink, linkname: "file"},
+			{name: "top/link", typ: tar.TypeReg, mode: 0o644, body: "x"},
+		},
+		"chained symlinks": {
+			{name: "top/sub/", typ: tar.TypeDir, mode: 0o755},
+			{name: "top/sub/x", typ: tar.TypeSymlink, linkname: ".."},
+			{name: "top/y", typ: tar.TypeSymlink, linkname: "sub/x/.."},
+			{name: "top/y/", typ: tar.TypeDir, mode: 0o777},
+		},
+		"chained symlinks in reverse order": {
+			{name: "top/sub/", typ: tar.TypeDir, mode: 0o755},
+			{name: "top/y", typ: tar.TypeSymlink, linkname: "sub/x/.."},
+			{name: "top/sub/x", typ: tar.TypeSymlink, linkname: ".."},
+		},
+		"chmod through symlink": {
+			{name: "top/up", typ: tar.TypeSymlink, linkname: "."},
+			{name: "top/up/", typ: tar.TypeDir, mode: 0o777},
+		},
+		"escaping hard link": {
+			{name: "top/shadow", typ: tar.TypeLink, linkname: "../../etc/shadow"},
+		},
+	}
+	for name, entries := range cases {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			dest := filepath.Join(root, "dest")
+			if err := os.Mkdir(dest, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			before, _ := os.Stat(root)
+			if err := untar(bytes.NewReader(tarball(t, entries)), dest, 1); err == nil {
+				t.Fatal("untar() accepted a malicious archive")
+			}
+			if _, err := os.Lstat(filepath.Join(root, "evil")); !os.IsNotExist(err) {
+				t.Error("file written outside the target directory")
+			}
+			if info, _ := os.Stat(root); info.Mode().Perm() != before.Mode().Perm() {
+				t.Errorf("directory outside the target changed to mode %v", info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestExtract_AtomicAndCleansUp(t *testing.T) {
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "repository")
+	e := NewTarExtractor(repo, nopLogger{})
+
+	good := filepath.Join(dir, "good.tar.gz")
+	writeTarGz(t, good, serverEntries)
+	if err := e.Extract(good, e.ServerDir("abc")); err != nil {
+		t.Fatalf("Extract() error: %v", err)
+	}
+	if !e.IsProvisioned("abc") {
+		t.Error("IsProvisioned() = false after Extract")
+	}
+
+	bad := filepath.Join(dir, "bad.tar.gz")
+	if err := os.WriteFile(bad, []byte("not gzip"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := e.Extract(bad, e.ServerDir("def"))
+	if err == nil || !strings.Contains(err.Error(), "corrupt download") {
+		t.Fatalf("Extract(corrupt) error = %v, want corrupt download hint", err)
+	}
+	entries, _ := os.ReadDir(repo)
+	for _, entry := range entries {
+		if entry.Name() != "abc" && !strings.HasPrefix(entry.Name(), ".lock-") {
+			t.Errorf("repository holds %q after a failed extract", entry.Name())
+		}
+	}
+	_ = filepath.Walk(repo, func(p string, info os.FileInfo, _ error) error {
+		if info != nil && info.IsDir() {
+			_ = os.Chmod(p, 0o755)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,95 @@
+// Package flock provides advisory cross-process locks on lock files using
+// flock(2). The kernel releases a lock when its holder exits, so a crashed
+// process never leaves a lock behind, only the empty lock file.
+package flock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Lock is a lock held on an open lock file.
+type Lock struct {
+	f    *os.File
+	path string
+}
+
+// Acquire blocks until it holds an exclusive lock on path, creating the
+// file if needed.
+func Acquire(path string) (*Lock, error) {
+	return lock(path, syscall.LOCK_EX)
+}
+
+// TryAcquire is Acquire without waiting. It returns ok false if another
+// process holds the lock.
+func TryAcquire(path string) (l *Lock, ok bool, err error) {
+	l, err = lock(path, syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return nil, false, nil
+	}
+	return l, err == nil, err
+}
+
+// AcquireShared blocks until it holds a shared lock on path, creating the
+// file if needed. Any number of processes may share it; an exclusive lock
+// waits until all of them have released it.
+func AcquireShared(path string) (*Lock, error) {
+	return lock(path, syscall.LOCK_SH)
+}
+
+func lock(path string, how int) (*Lock, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open lock file: %w", err)
+		}
+		for {
+			err = syscall.Flock(int(f.Fd()), how)
+			if !errors.Is(err, syscall.EINTR) {
+				break
+			}
+		}
+		if err != nil {
+			f.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+		// The holder we waited for may have removed the file; a lock on
+		// it would exclude nobody, so lock whatever is there now.
+		if sameFile(f, path) {
+			return &Lock{f: f, path: path}, nil
+		}
+		f.Close()
+	}
+}
+
+// sameFile reports whether path still names the open file f.
+func sameFile(f *os.File, path string) bool {
+	a, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	b, err := os.Stat(path)
+	return err == nil && os.SameFile(a, b)
+}
+
+// Release unlocks and closes the lock file. The file itself is left in
+// place for the next holder; see Remove.
+func (l *Lock) Release() error {
+	return l.f.Close()
+}
+
+// Remove deletes the lock file and then releases the lock. Waiters notice
+// that the file they locked is gone and lock a new one, so only an
+// exclusive holder may remove it.
+func (l *Lock) Remove() error {
+	err := os.Remove(l.path)
+	if closeErr := l.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package flock
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTryAcquire_ExcludesOtherHolders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock-abc")
+	l, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire() error: %v", err)
+	}
+
+	// flock locks belong to open files, so a second open conflicts even
+	// within one process, just as another process would.
+	if _, ok, err := TryAcquire(path); ok || err != nil {
+		t.Fatalf("TryAcquire() while held = %v, %v; want false, nil", ok, err)
+	}
+
+	acquired := make(chan *Lock)
+	go func() {
+		l2, err := Acquire(path)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- l2
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Acquire() did not wait for the holder")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatalf("Release() error: %v", err)
+	}
+	select {
+	case l2 := <-acquired:
+		l2.Release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Acquire() still waiting after Release")
+	}
+
+	l3, ok, err := TryAcquire(path)
+	if !ok || err != nil {
+		t.Fatalf("TryAcquire() after release = %v, %v; want true, nil", ok, err)
+	}
+	l3.Release()
+}
+
+func TestAcquireShared_ExcludesOnlyExclusiveHolders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".use-abc")
+	a, err := AcquireShared(path)
+	if err != nil {
+		t.Fatalf("AcquireShared() error: %v", err)
+	}
+	b, err := AcquireShared(path)
+	if err != nil {
+		t.Fatalf("second AcquireShared() error: %v", err)
+	}
+	if _, ok, err := TryAcquire(path); ok || err != nil {
+		t.Fatalf("TryAcquire() while shared = %v, %v; want false, nil", ok, err)
+	}
+	a.Release()
+	b.Release()
+
+	l, ok, err := TryAcquire(path)
+	if !ok || err != nil {
+		t.Fatalf("TryAcquire() after release = %v, %v; want true, nil", ok, err)
+	}
+	l.Release()
+}
+
+func TestRemove_WaitersLockTheNewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".use-abc")
+	l, err := Acquire(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan *Lock)
+	go func() {
+		w, err := AcquireShared(path)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- w
+	}()
+	time.Sleep(50 * time.Millisecond) // let the waiter open the old file
+	if err := l.Remove(); err != nil {
+		t.Fatalf("Remove() error: %v", err)
+	}
+
+	var w *Lock
+	select {
+	case w = <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("AcquireShared() still waiting after Remove")
+	}
+	defer w.Release()
+	// The waiter must hold the file that is there now, or a later
+	// exclusive lock would not see it.
+	if _, ok, err := TryAcquire(path); ok || err != nil {
+		t.Errorf("TryAcquire() while the waiter holds the lock = %v, %v; want false, nil", ok, err)
+	}
+}
@@ -0,0 +1,40 @@
+// Package peercred identifies the process behind an accepted Unix socket
+// connection and checks it against a session's access policy.
+package peercred
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"codetap/internal/domain"
+)
+
+// ErrUnsupported is returned by Lookup on platforms without SO_PEERCRED.
+var ErrUnsupported = errors.New("peer credentials not supported on this platform")
+
+// DeniedError reports a peer rejected by the access policy.
+type DeniedError struct {
+	Peer domain.PeerCred
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("peer pid=%d uid=%d gid=%d not allowed", e.Peer.PID, e.Peer.UID, e.Peer.GID)
+}
+
+// Authorize looks up the credentials of conn's peer and checks them against
+// policy. On platforms without peer credentials the connection is allowed and
+// the returned PeerCred is zero.
+func Authorize(conn net.Conn, policy domain.AccessPolicy) (domain.PeerCred, error) {
+	cred, err := Lookup(conn)
+	if errors.Is(err, ErrUnsupported) {
+		return cred, nil
+	}
+	if err != nil {
+		return cred, err
+	}
+	if !policy.Allows(cred) {
+		return cred, &DeniedError{Peer: cred}
+	}
+	return cred, nil
+}
@@ -0,0 +1,71 @@
+package peercred
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"codetap/internal/domain"
+)
+
+// Lookup returns the credentials of the process that connected to conn,
+// as recorded by the kernel at connect time (SO_PEERCRED). Supplementary
+// groups are read from /proc/<pid>/status when available.
+func Lookup(conn net.Conn) (domain.PeerCred, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return domain.PeerCred{}, fmt.Errorf("peer credentials: %T is not a Unix socket", conn)
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return domain.PeerCred{}, fmt.Errorf("peer credentials: %w", err)
+	}
+
+	var ucred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return domain.PeerCred{}, fmt.Errorf("peer credentials: %w", err)
+	}
+	if credErr != nil {
+		return domain.PeerCred{}, fmt.Errorf("peer credentials: %w", credErr)
+	}
+
+	return domain.PeerCred{
+		PID:    int(ucred.Pid),
+		UID:    int(ucred.Uid),
+		GID:    int(ucred.Gid),
+		Groups: procGroups(int(ucred.Pid)),
+	}, nil
+}
+
+// procGroups reads the supplementary group list of pid. The peer may live in
+// another PID namespace or have exited already; both yield nil.
+func procGroups(pid int) []int {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Groups:") {
+			continue
+		}
+		var groups []int
+		for _, field := range strings.Fields(strings.TrimPrefix(line, "Groups:")) {
+			if gid, err := strconv.Atoi(field); err == nil {
+				groups = append(groups, gid)
+			}
+		}
+		return groups
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package peercred
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"codetap/internal/domain"
+)
+
+// acceptOne dials a fresh Unix socket and returns the server side of the connection.
+func acceptOne(t *testing.T) net.Conn {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "peer.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	client, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func TestLookup(t *testing.T) {
+	cred, err := Lookup(acceptOne(t))
+	if err != nil {
+		t.Fatalf("Lookup() error: %v", err)
+	}
+	if cred.PID != os.Getpid() {
+		t.Errorf("PID = %d, want %d", cred.PID, os.Getpid())
+	}
+	if cred.UID != os.Getuid() || cred.GID != os.Getgid() {
+		t.Errorf("UID/GID = %d/%d, want %d/%d", cred.UID, cred.GID, os.Getuid(), os.Getgid())
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	self := os.Getuid()
+	groups, _ := os.Getgroups()
+
+	tests := []struct {
+		name   string
+		policy domain.AccessPolicy
+		allow  bool
+	}{
+		{name: "owner", policy: domain.AccessPolicy{UIDs: []int{self}}, allow: true},
+		{name: "other user", policy: domain.AccessPolicy{UIDs: []int{self + 1}}, allow: false},
+		{name: "primary group", policy: domain.AccessPolicy{GIDs: []int{os.Getgid()}}, allow: true},
+		{name: "empty", policy: domain.AccessPolicy{}, allow: false},
+	}
+	if len(groups) > 0 {
+		tests = append(tests, struct {
+			name   string
+			policy domain.AccessPolicy
+			allow  bool
+		}{name: "supplementary group", policy: domain.AccessPolicy{GIDs: []int{groups[len(groups)-1]}}, allow: true})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Authorize(acceptOne(t), tt.policy)
+			if tt.allow && err != nil {
+				t.Fatalf("Authorize() error: %v", err)
+			}
+			if !tt.allow {
+				var denied *DeniedError
+				if !errors.As(err, &denied) {
+					t.Fatalf("Authorize() = %v, want DeniedError", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package peercred
+
+import (
+	"net"
+
+	"codetap/internal/domain"
+)
+
+// Lookup is not implemented outside Linux; Authorize treats the peer as allowed.
+func Lookup(conn net.Conn) (domain.PeerCred, error) {
+	return domain.PeerCred{}, ErrUnsupported
+}
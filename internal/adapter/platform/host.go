@@ -0,0 +1,114 @@
+package platform
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"codetap/internal/domain"
+)
+
+// containerIDRe matches a 64-hex-digit container ID in a cgroup path.
+var containerIDRe = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// DetectHost reports the hostname, user, OS release and container context of
+// the current process. Anything that cannot be detected is left empty.
+func (p *Platform) DetectHost() domain.HostInfo {
+	uid := os.Getuid()
+	info := domain.HostInfo{UID: &uid}
+	info.Hostname, _ = os.Hostname()
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		info.User = u.Username
+	}
+
+	for _, path := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		if data, err := os.ReadFile(path); err == nil {
+			info.OSID, info.OSVersion = parseOSRelease(data)
+			break
+		}
+	}
+
+	cgroup, _ := os.ReadFile("/proc/self/cgroup")
+	info.ContainerRuntime, info.ContainerID = parseCgroup(cgroup)
+	if info.ContainerRuntime == "" {
+		switch {
+		case fileExists("/.dockerenv"):
+			info.ContainerRuntime = "docker"
+		case fileExists("/run/.containerenv"):
+			info.ContainerRuntime = "podman"
+		}
+	}
+	return info
+}
+
+// parseOSRelease returns ID and VERSION_ID from an os-release file, falling
+// back to VERSION when VERSION_ID is missing.
+func parseOSRelease(data []byte) (id, version string) {
+	var fallback string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(sc.Text()), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			id = value
+		case "VERSION_ID":
+			version = value
+		case "VERSION":
+			fallback = value
+		}
+	}
+	if version == "" {
+		version = fallback
+	}
+	return id, version
+}
+
+// parseCgroup detects the container runtime and ID from /proc/self/cgroup.
+// Under cgroup v2 with a private cgroup namespace the path is just "/", so
+// both results are empty and the caller falls back to marker files.
+func parseCgroup(data []byte) (runtime, id string) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(sc.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		path := parts[2]
+		var rt string
+		switch {
+		case strings.Contains(path, "kubepods"):
+			rt = "kubernetes"
+		case strings.Contains(path, "libpod"):
+			rt = "podman"
+		case strings.Contains(path, "docker"):
+			rt = "docker"
+		case strings.Contains(path, "containerd"):
+			rt = "containerd"
+		case strings.Contains(path, "lxc"):
+			rt = "lxc"
+		default:
+			continue
+		}
+		if m := containerIDRe.FindAllString(path, -1); len(m) > 0 {
+			return rt, m[len(m)-1]
+		}
+		if runtime == "" {
+			runtime = rt
+		}
+	}
+	return runtime, ""
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -56,6 +56,62 @@ func (p *Platform) RepositoryDir() string {
 	return filepath.Join(p.homeDir, ".codetap", "repository")
 }
 
+// VSCodeServerDir returns the directory where Remote-SSH and Dev Containers
+// install VS Code Servers: $VSCODE_AGENT_FOLDER, or ~/.vscode-server.
+func (p *Platform) VSCodeServerDir() string {
+	if v := os.Getenv("VSCODE_AGENT_FOLDER"); v != "" {
+		return v
+	}
+	return filepath.Join(p.homeDir, ".vscode-server")
+}
+
+// ResolveRepositoryPath returns the read-only server repositories to search
+// before RepositoryDir, from flag or env (CODETAP_REPOSITORY_PATH). Both are
+// lists separated like PATH; empty entries are dropped.
+func (p *Platform) ResolveRepositoryPath(flagValue string) []string {
+	if flagValue == "" {
+		flagValue = os.Getenv("CODETAP_REPOSITORY_PATH")
+	}
+	var dirs []string
+	for _, dir := range filepath.SplitList(flagValue) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// Mirror locates an internal mirror of the VS Code update service and the
+// TLS material for reaching it. Empty fields mean the public service and the
+// system defaults.
+type Mirror struct {
+	DownloadURL string // tarball URL template, CODETAP_DOWNLOAD_URL
+	UpdateAPI   string // update API base URL, CODETAP_UPDATE_API
+	CABundle    string // PEM file of extra trusted CAs, CODETAP_CA_BUNDLE
+	ClientCert  string // PEM client certificate, CODETAP_CLIENT_CERT
+	ClientKey   string // PEM client key, CODETAP_CLIENT_KEY
+}
+
+// ResolveMirror fills the fields not set by flags from the environment.
+func (p *Platform) ResolveMirror(flags Mirror) Mirror {
+	m := flags
+	for _, f := range []struct {
+		field *string
+		env   string
+	}{
+		{&m.DownloadURL, "CODETAP_DOWNLOAD_URL"},
+		{&m.UpdateAPI, "CODETAP_UPDATE_API"},
+		{&m.CABundle, "CODETAP_CA_BUNDLE"},
+		{&m.ClientCert, "CODETAP_CLIENT_CERT"},
+		{&m.ClientKey, "CODETAP_CLIENT_KEY"},
+	} {
+		if *f.field == "" {
+			*f.field = os.Getenv(f.env)
+		}
+	}
+	return m
+}
+
 // ResolveCommit resolves the commit hash from flag, env var, or file.
 func (p *Platform) ResolveCommit(flagValue string) (string, error) {
 	if flagValue != "" {
@@ -3,6 +3,7 @@ package platform
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"testing"
 )
@@ -57,6 +58,26 @@ func TestResolveSocketDir_Default(t *testing.T) {
 	}
 }
 
+func TestResolveMirror_FlagsOverrideEnv(t *testing.T) {
+	p := &Platform{homeDir: "/tmp"}
+	t.Setenv("CODETAP_DOWNLOAD_URL", "https://env.example/{commit}")
+	t.Setenv("CODETAP_UPDATE_API", "https://env.example")
+	t.Setenv("CODETAP_CA_BUNDLE", "/env/ca.pem")
+	t.Setenv("CODETAP_CLIENT_CERT", "")
+	t.Setenv("CODETAP_CLIENT_KEY", "")
+
+	got := p.ResolveMirror(Mirror{DownloadURL: "https://flag.example/{commit}", ClientCert: "/flag/client.pem"})
+	want := Mirror{
+		DownloadURL: "https://flag.example/{commit}",
+		UpdateAPI:   "https://env.example",
+		CABundle:    "/env/ca.pem",
+		ClientCert:  "/flag/client.pem",
+	}
+	if got != want {
+		t.Errorf("ResolveMirror() = %+v, want %+v", got, want)
+	}
+}
+
 func TestCacheDir(t *testing.T) {
 	p := &Platform{homeDir: "/home/user"}
 	want := "/home/user/.codetap/cache"
@@ -73,6 +94,34 @@ func TestRepositoryDir(t *testing.T) {
 	}
 }
 
+func TestVSCodeServerDir(t *testing.T) {
+	p := &Platform{homeDir: "/home/user"}
+	t.Setenv("VSCODE_AGENT_FOLDER", "")
+	if got := p.VSCodeServerDir(); got != "/home/user/.vscode-server" {
+		t.Errorf("VSCodeServerDir() = %q, want /home/user/.vscode-server", got)
+	}
+	t.Setenv("VSCODE_AGENT_FOLDER", "/data/vscode")
+	if got := p.VSCodeServerDir(); got != "/data/vscode" {
+		t.Errorf("VSCodeServerDir() = %q, want /data/vscode", got)
+	}
+}
+
+func TestResolveRepositoryPath(t *testing.T) {
+	p := &Platform{homeDir: "/home/user"}
+	t.Setenv("CODETAP_REPOSITORY_PATH", "/opt/codetap::/mnt/servers")
+
+	if got := p.ResolveRepositoryPath(""); !reflect.DeepEqual(got, []string{"/opt/codetap", "/mnt/servers"}) {
+		t.Errorf("ResolveRepositoryPath(\"\") = %q, want the env list without empty entries", got)
+	}
+	if got := p.ResolveRepositoryPath("/flag"); !reflect.DeepEqual(got, []string{"/flag"}) {
+		t.Errorf("ResolveRepositoryPath(\"/flag\") = %q, want the flag to override env", got)
+	}
+	t.Setenv("CODETAP_REPOSITORY_PATH", "")
+	if got := p.ResolveRepositoryPath(""); got != nil {
+		t.Errorf("ResolveRepositoryPath(\"\") = %q, want none", got)
+	}
+}
+
 func TestResolveCommit_Flag(t *testing.T) {
 	p := &Platform{homeDir: "/tmp"}
 	commit, err := p.ResolveCommit("abc123")
@@ -132,3 +181,42 @@ func TestResolveCommit_Missing(t *testing.T) {
 		t.Errorf("expected empty string, got %q", commit)
 	}
 }
+
+func TestParseOSRelease(t *testing.T) {
+	data := []byte("PRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\nNAME=\"Debian GNU/Linux\"\nVERSION_ID=\"12\"\nVERSION=\"12 (bookworm)\"\nID=debian\n")
+	id, version := parseOSRelease(data)
+	if id != "debian" || version != "12" {
+		t.Errorf("parseOSRelease() = %q, %q, want debian, 12", id, version)
+	}
+
+	id, version = parseOSRelease([]byte("ID=arch\nVERSION=\"rolling\"\n"))
+	if id != "arch" || version != "rolling" {
+		t.Errorf("parseOSRelease(no VERSION_ID) = %q, %q, want arch, rolling", id, version)
+	}
+}
+
+func TestParseCgroup(t *testing.T) {
+	const id = "3f4e8a1b2c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f7"
+	tests := []struct {
+		name        string
+		data        string
+		wantRuntime string
+		wantID      string
+	}{
+		{"docker v1", "12:memory:/docker/" + id + "\n0::/\n", "docker", id},
+		{"docker systemd", "0::/system.slice/docker-" + id + ".scope\n", "docker", id},
+		{"kubernetes", "0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod1234.slice/cri-containerd-" + id + ".scope\n", "kubernetes", id},
+		{"podman", "0::/machine.slice/libpod-" + id + ".scope/container\n", "podman", id},
+		{"lxc without id", "0::/lxc.payload.dev\n", "lxc", ""},
+		{"host", "0::/user.slice/user-1000.slice/session-2.scope\n", "", ""},
+		{"namespaced v2", "0::/\n", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, gotID := parseCgroup([]byte(tt.data))
+			if rt != tt.wantRuntime || gotID != tt.wantID {
+				t.Errorf("parseCgroup() = %q, %q, want %q, %q", rt, gotID, tt.wantRuntime, tt.wantID)
+			}
+		})
+	}
+}
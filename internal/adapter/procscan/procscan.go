@@ -0,0 +1,18 @@
+// Package procscan finds code-server processes by the socket they were
+// started on, so that servers left behind by a crashed codetap can be found
+// even though no control socket points at them any more.
+package procscan
+
+import "errors"
+
+// ErrUnsupported is returned by Find on platforms without /proc.
+var ErrUnsupported = errors.New("process scan not supported on this platform")
+
+// Process is a process started with --socket-path.
+type Process struct {
+	PID        int
+	PPID       int
+	PGID       int
+	Command    string // base name of argv[0]
+	SocketPath string // value of --socket-path
+}
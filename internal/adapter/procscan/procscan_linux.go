@@ -0,0 +1,128 @@
+package procscan
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Find returns every process visible in /proc whose command line carries a
+// --socket-path argument. Processes that exit or cannot be read during the
+// scan are skipped.
+func Find() ([]Process, error) {
+	return findIn("/proc")
+}
+
+func findIn(procDir string) ([]Process, error) {
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, fmt.Errorf("scan %s: %w", procDir, err)
+	}
+	var procs []Process
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pid <= 0 {
+			continue
+		}
+		dir := filepath.Join(procDir, e.Name())
+		cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+		if err != nil {
+			continue
+		}
+		args := strings.Split(string(bytes.TrimRight(cmdline, "\x00")), "\x00")
+		socketPath := socketPathArg(args)
+		if socketPath == "" {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+		if err != nil {
+			continue
+		}
+		ppid, pgid, err := parseStat(string(stat))
+		if err != nil {
+			continue
+		}
+		procs = append(procs, Process{
+			PID:        pid,
+			PPID:       ppid,
+			PGID:       pgid,
+			Command:    filepath.Base(args[0]),
+			SocketPath: socketPath,
+		})
+	}
+	return procs, nil
+}
+
+// socketPathArg returns the value of --socket-path, given either as
+// "--socket-path=<path>" or as "--socket-path <path>".
+func socketPathArg(args []string) string {
+	for i, a := range args {
+		if v, ok := strings.CutPrefix(a, "--socket-path="); ok {
+			return v
+		}
+		if a == "--socket-path" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// parseStat extracts the parent and the process group from
+// /proc/<pid>/stat. The command name in parentheses may itself contain
+// spaces and parentheses, so fields are counted from the last ")": state,
+// ppid, pgrp.
+func parseStat(stat string) (ppid, pgid int, err error) {
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, 0, errors.New("malformed stat")
+	}
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 3 {
+		return 0, 0, errors.New("malformed stat")
+	}
+	if ppid, err = strconv.Atoi(fields[1]); err != nil {
+		return 0, 0, err
+	}
+	if pgid, err = strconv.Atoi(fields[2]); err != nil {
+		return 0, 0, err
+	}
+	return ppid, pgid, nil
+}
+
+// Running reports whether pid exists and has not exited. Unlike kill(pid, 0)
+// it treats a zombie that its parent has not reaped yet as gone.
+func Running(pid int) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	end := strings.LastIndexByte(string(stat), ')')
+	fields := strings.Fields(string(stat[end+1:]))
+	return len(fields) > 0 && fields[0] != "Z" && fields[0] != "X"
+}
+
+// IsCodetap reports whether pid is a running codetap: a process running the
+// same executable as this one, or one whose command is named codetap. A
+// code-server whose parent is a codetap still has its session's owner, even
+// if that session does not answer on its control socket yet.
+func IsCodetap(pid int) bool {
+	if pid <= 1 || !Running(pid) {
+		return false
+	}
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	if exe, err := os.Stat(filepath.Join(dir, "exe")); err == nil {
+		if self, err := os.Stat("/proc/self/exe"); err == nil && os.SameFile(exe, self) {
+			return true
+		}
+	}
+	cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil {
+		return false
+	}
+	argv0, _, _ := strings.Cut(string(cmdline), "\x00")
+	return filepath.Base(argv0) == "codetap"
+}
@@ -0,0 +1,103 @@
+package procscan
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func writeProc(t *testing.T, procDir, pid string, args []string, stat string) {
+	t.Helper()
+	dir := filepath.Join(procDir, pid)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cmdline := strings.Join(args, "\x00") + "\x00"
+	if err := os.WriteFile(filepath.Join(dir, "cmdline"), []byte(cmdline), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindIn_ParsesSocketPathAndGroup(t *testing.T) {
+	proc := t.TempDir()
+	writeProc(t, proc, "100",
+		[]string{"/opt/server/bin/code-server", "--socket-path=/dev/shm/codetap/1000/a.sock", "--accept-server-license-terms"},
+		"100 (code-server) S 1 100 100 0 -1")
+	writeProc(t, proc, "101",
+		[]string{"/opt/server/node", "server-main.js", "--socket-path", "/dev/shm/codetap/1000/a.sock"},
+		"101 (no) (de)) S 100 100 100 0 -1")
+	writeProc(t, proc, "200", []string{"/usr/bin/sleep", "60"}, "200 (sleep) S 1 200 200 0 -1")
+	if err := os.MkdirAll(filepath.Join(proc, "self"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	procs, err := findIn(proc)
+	if err != nil {
+		t.Fatalf("findIn() error: %v", err)
+	}
+	if len(procs) != 2 {
+		t.Fatalf("findIn() = %+v, want 2 processes", procs)
+	}
+	want := map[int]Process{
+		100: {PID: 100, PPID: 1, PGID: 100, Command: "code-server", SocketPath: "/dev/shm/codetap/1000/a.sock"},
+		101: {PID: 101, PPID: 100, PGID: 100, Command: "node", SocketPath: "/dev/shm/codetap/1000/a.sock"},
+	}
+	for _, p := range procs {
+		if p != want[p.PID] {
+			t.Errorf("process %d = %+v, want %+v", p.PID, p, want[p.PID])
+		}
+	}
+}
+
+func TestFind_SeesLiveProcess(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "live.sock")
+	cmd := exec.Command("/bin/sh", "-c", "sleep 30; :", "sh", "--socket-path="+sock)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start sh: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		_ = cmd.Wait()
+	})
+
+	procs, err := Find()
+	if err != nil {
+		t.Fatalf("Find() error: %v", err)
+	}
+	for _, p := range procs {
+		if p.PID == cmd.Process.Pid {
+			if p.SocketPath != sock || p.PGID != cmd.Process.Pid {
+				t.Errorf("Find() = %+v, want socket %s in group %d", p, sock, cmd.Process.Pid)
+			}
+			return
+		}
+	}
+	t.Errorf("Find() did not report pid %d", cmd.Process.Pid)
+}
+
+func TestIsCodetap(t *testing.T) {
+	if !IsCodetap(os.Getpid()) {
+		t.Error("IsCodetap() = false for a process running this executable")
+	}
+	cmd := exec.Command("/bin/sh", "-c", "sleep 30")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start sh: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	if IsCodetap(cmd.Process.Pid) {
+		t.Error("IsCodetap() = true for sh")
+	}
+	if IsCodetap(1) {
+		t.Error("IsCodetap() = true for init")
+	}
+}
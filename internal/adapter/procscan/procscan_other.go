@@ -0,0 +1,18 @@
+//go:build !linux
+
+package procscan
+
+// Find is not implemented outside Linux.
+func Find() ([]Process, error) {
+	return nil, ErrUnsupported
+}
+
+// Running is not implemented outside Linux.
+func Running(pid int) bool {
+	return false
+}
+
+// IsCodetap is not implemented outside Linux.
+func IsCodetap(pid int) bool {
+	return false
+}
@@ -15,8 +15,14 @@ const (
 	FrameData  byte = 0x02 // Data payload
 	FrameClose byte = 0x03 // Connection closed
 	FrameInit  byte = 0x04 // Init phase: commit negotiation
+	FrameFetch byte = 0x05 // Init phase: server tarball request and reply
 )
 
+// InitSuppliesTarballs is set in the ConnID of the host's FrameInit when the
+// host can supply server tarballs with FrameFetch. Older remotes ignore the
+// ConnID of FrameInit.
+const InitSuppliesTarballs uint32 = 1
+
 // Frame is a multiplexed message with a connection ID and payload.
 type Frame struct {
 	Type   byte
@@ -57,7 +63,7 @@ func ReadFrame(r io.Reader) (Frame, error) {
 	}
 	length := binary.BigEndian.Uint32(header[5:9])
 
-	validType := f.Type >= FrameOpen && f.Type <= FrameInit
+	validType := f.Type >= FrameOpen && f.Type <= FrameFetch
 	if !validType || length > MaxFramePayload {
 		return Frame{}, recoverTextError(header, r)
 	}
@@ -18,8 +18,13 @@ import (
 // multiplexes accepted connections over the subprocess stdin/stdout.
 //
 // commit is the VS Code Server commit hash to negotiate with the remote side
-// via the FrameInit handshake.
-func HostSide(socketPath string, command []string, commit string, onInit func(string), logger domain.Logger) error {
+// via the FrameInit handshake. Relay sessions have no connection token, so
+// authorize (if non-nil) vets every accepted data connection before it is
+// forwarded; connections it rejects are closed.
+//
+// If supply is non-nil, the host offers the remote server tarballs from its
+// own cache during the handshake, for remotes without network access.
+func HostSide(socketPath string, command []string, commit string, onInit func(string), authorize func(net.Conn) error, supply SupplyFunc, logger domain.Logger) error {
 	// Create socket listener first so the session is discoverable by the
 	// VS Code extension and isAlive checks succeed.
 	_ = os.Remove(socketPath)
@@ -69,15 +74,30 @@ func HostSide(socketPath string, command []string, commit string, onInit func(st
 
 	fw := NewFrameWriter(stdinPipe)
 
-	// Init phase: send commit to remote and wait for ack.
+	// Init phase: send commit to remote and wait for ack, supplying the
+	// server tarball if the remote asks for it in the meantime.
+	var flags uint32
+	if supply != nil {
+		flags |= InitSuppliesTarballs
+	}
 	logger.Info("sending init frame", "commit", commit)
-	if err := fw.Write(Frame{Type: FrameInit, ConnID: 0, Data: []byte(commit)}); err != nil {
+	if err := fw.Write(Frame{Type: FrameInit, ConnID: flags, Data: []byte(commit)}); err != nil {
 		return fmt.Errorf("write init frame: %w", err)
 	}
 
-	ackFrame, err := ReadFrame(stdoutPipe)
-	if err != nil {
-		return fmt.Errorf("read init ack: %w", err)
+	var ackFrame Frame
+	for {
+		ackFrame, err = ReadFrame(stdoutPipe)
+		if err != nil {
+			return fmt.Errorf("read init ack: %w", err)
+		}
+		if ackFrame.Type != FrameFetch {
+			break
+		}
+		logger.Info("remote requested server tarball", "request", string(ackFrame.Data))
+		if err := serveTarball(fw, ackFrame.Data, supply, logger); err != nil {
+			return fmt.Errorf("supply tarball: %w", err)
+		}
 	}
 	if ackFrame.Type != FrameInit {
 		return fmt.Errorf("expected FrameInit ack, got 0x%02x", ackFrame.Type)
@@ -124,6 +144,13 @@ func HostSide(socketPath string, command []string, commit string, onInit func(st
 			if acceptErr != nil {
 				return // listener closed
 			}
+			if authorize != nil {
+				if authErr := authorize(conn); authErr != nil {
+					logger.Error("data connection refused", "err", authErr)
+					_ = conn.Close()
+					continue
+				}
+			}
 			id := nextID.Add(1)
 			conns.Store(id, conn)
 			logger.Info("connection accepted", "conn", id)
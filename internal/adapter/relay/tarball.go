@@ -0,0 +1,164 @@
+package relay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"codetap/internal/domain"
+)
+
+// Supplying a server tarball happens during the init phase, before the
+// remote acknowledges the commit and before any connection is multiplexed.
+// The remote sends a FrameFetch request; the host answers with a FrameFetch
+// reply, streams the tarball as FrameData frames and ends it with
+// FrameClose, all on ConnID 0.
+
+// TarballRequest asks the host for the server tarball of one build.
+type TarballRequest struct {
+	Commit   string `json:"commit"`
+	Artifact string `json:"artifact"`
+}
+
+// tarballReply announces the tarball that follows, or why there is none.
+type tarballReply struct {
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Unverified marks a tarball the host never checked against a
+	// published checksum; SHA256 then only guards the transfer.
+	Unverified bool   `json:"unverified,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// tarballChunk is the payload size of the FrameData frames carrying a
+// tarball.
+const tarballChunk = 256 << 10
+
+// SupplyFunc returns the path and SHA-256 of the host's cached tarball for
+// a build, downloading it first if needed.
+type SupplyFunc func(commit, artifact string) (path, sha256 string, err error)
+
+// FetchTarball asks the host on the other end of r and w for a tarball,
+// writes it to dst and returns the SHA-256 the host recorded for it, and
+// whether the host had verified it against a published checksum. The
+// caller checks the received bytes against that sum.
+func FetchTarball(r io.Reader, w io.Writer, req TarballRequest, dst io.Writer) (sum string, verified bool, err error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", false, err
+	}
+	if err := WriteFrame(w, Frame{Type: FrameFetch, Data: data}); err != nil {
+		return "", false, fmt.Errorf("request tarball: %w", err)
+	}
+
+	frame, err := ReadFrame(r)
+	if err != nil {
+		return "", false, fmt.Errorf("read tarball reply: %w", err)
+	}
+	if frame.Type != FrameFetch {
+		return "", false, fmt.Errorf("expected FrameFetch reply, got 0x%02x", frame.Type)
+	}
+	var reply tarballReply
+	if err := json.Unmarshal(frame.Data, &reply); err != nil {
+		return "", false, fmt.Errorf("invalid tarball reply: %w", err)
+	}
+	if reply.Error != "" {
+		return "", false, fmt.Errorf("host: %s", reply.Error)
+	}
+
+	var received int64
+	for {
+		frame, err := ReadFrame(r)
+		if err != nil {
+			return "", false, fmt.Errorf("read tarball: %w", err)
+		}
+		switch frame.Type {
+		case FrameData:
+			if _, err := dst.Write(frame.Data); err != nil {
+				return "", false, err
+			}
+			received += int64(len(frame.Data))
+		case FrameClose:
+			if received != reply.Size {
+				return "", false, fmt.Errorf("host sent %d of %d bytes", received, reply.Size)
+			}
+			return reply.SHA256, !reply.Unverified, nil
+		default:
+			return "", false, fmt.Errorf("unexpected frame 0x%02x in tarball stream", frame.Type)
+		}
+	}
+}
+
+// serveTarball answers one FrameFetch request from the remote. Failures to
+// supply the tarball are reported to the remote, which can fall back to
+// downloading it itself; only a broken pipe is returned as an error.
+func serveTarball(fw *FrameWriter, data []byte, supply SupplyFunc, logger domain.Logger) error {
+	reply := func(r tarballReply) error {
+		if r.Error != "" {
+			logger.Error("cannot supply server tarball", "err", r.Error)
+		}
+		out, _ := json.Marshal(r)
+		return fw.Write(Frame{Type: FrameFetch, Data: out})
+	}
+
+	var req TarballRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return reply(tarballReply{Error: fmt.Sprintf("invalid request: %v", err)})
+	}
+	if supply == nil {
+		return reply(tarballReply{Error: "this host does not supply server tarballs"})
+	}
+	path, sum, err := supply(req.Commit, req.Artifact)
+	if err != nil {
+		return reply(tarballReply{Error: err.Error()})
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return reply(tarballReply{Error: err.Error()})
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return reply(tarballReply{Error: err.Error()})
+	}
+	unverified := sum == ""
+	if unverified {
+		// An unverified download: the sum still guards the transfer.
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return reply(tarballReply{Error: err.Error()})
+		}
+		sum = hex.EncodeToString(h.Sum(nil))
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return reply(tarballReply{Error: err.Error()})
+		}
+	}
+
+	logger.Info("supplying server tarball", "path", path, "size", info.Size())
+	if err := reply(tarballReply{Size: info.Size(), SHA256: sum, Unverified: unverified}); err != nil {
+		return err
+	}
+	buf := make([]byte, tarballChunk)
+	var sent int64
+	for sent < info.Size() {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if err := fw.Write(Frame{Type: FrameData, Data: buf[:n]}); err != nil {
+				return err
+			}
+			sent += int64(n)
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			logger.Error("read server tarball failed", "path", path, "err", err)
+			break // ending the stream short makes the remote discard it
+		}
+	}
+	return fw.Write(Frame{Type: FrameClose})
+}
@@ -0,0 +1,100 @@
+package relay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+// fetchFromHost runs FetchTarball against serveTarball over in-memory pipes,
+// as the remote and host ends of a relay during the init phase.
+func fetchFromHost(t *testing.T, req TarballRequest, supply SupplyFunc) ([]byte, string, bool, error) {
+	t.Helper()
+	toHost, fromRemote := io.Pipe()
+	toRemote, fromHost := io.Pipe()
+
+	go func() {
+		frame, err := ReadFrame(toHost)
+		if err != nil || frame.Type != FrameFetch {
+			fromHost.CloseWithError(errors.New("host did not get a FrameFetch request"))
+			return
+		}
+		if err := serveTarball(NewFrameWriter(fromHost), frame.Data, supply, nopLogger{}); err != nil {
+			fromHost.CloseWithError(err)
+		}
+	}()
+
+	var got bytes.Buffer
+	sum, verified, err := FetchTarball(toRemote, fromRemote, req, &got)
+	return got.Bytes(), sum, verified, err
+}
+
+func TestFetchTarball_StreamsHostCache(t *testing.T) {
+	// Larger than one chunk, so the tarball spans several frames.
+	body := bytes.Repeat([]byte("codetap"), tarballChunk/3)
+	path := filepath.Join(t.TempDir(), "server.tar.gz")
+	if err := os.WriteFile(path, body, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(body)
+
+	cases := map[string]string{
+		"recorded sum": "recorded",
+		"computed sum": "",
+	}
+	for name, recorded := range cases {
+		t.Run(name, func(t *testing.T) {
+			var asked TarballRequest
+			supply := func(commit, artifact string) (string, string, error) {
+				asked = TarballRequest{commit, artifact}
+				return path, recorded, nil
+			}
+			req := TarballRequest{Commit: "abc", Artifact: "server-linux-x64"}
+			got, gotSum, verified, err := fetchFromHost(t, req, supply)
+			if err != nil {
+				t.Fatalf("FetchTarball() error: %v", err)
+			}
+			if asked != req {
+				t.Errorf("host was asked for %+v, want %+v", asked, req)
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("received %d bytes, want the %d byte tarball", len(got), len(body))
+			}
+			want := recorded
+			if want == "" {
+				want = hex.EncodeToString(sum[:])
+			}
+			if gotSum != want {
+				t.Errorf("sha256 = %q, want %q", gotSum, want)
+			}
+			if verified != (recorded != "") {
+				t.Errorf("verified = %v, want %v", verified, recorded != "")
+			}
+		})
+	}
+}
+
+func TestFetchTarball_ReportsHostErrors(t *testing.T) {
+	failing := func(string, string) (string, string, error) {
+		return "", "", errors.New("no network either")
+	}
+	for name, supply := range map[string]SupplyFunc{"supply fails": failing, "no supply": nil} {
+		t.Run(name, func(t *testing.T) {
+			_, _, _, err := fetchFromHost(t, TarballRequest{Commit: "abc", Artifact: "server-linux-x64"}, supply)
+			if err == nil || !strings.HasPrefix(err.Error(), "host: ") {
+				t.Errorf("FetchTarball() error = %v, want the host's error", err)
+			}
+		})
+	}
+}
@@ -21,10 +21,10 @@ func NewProcessRunner(logger domain.Logger) *ProcessRunner {
 }
 
 // Start launches code-server on the given Unix socket with the given token.
-// It returns a wait function that blocks until the process exits and a stop
+// It returns the process group ID, a wait function that blocks until the process exits and a stop
 // function that sends SIGTERM to the entire process group (sh + node).
 // Signals (SIGINT, SIGTERM) received by codetap are forwarded to the process group.
-func (r *ProcessRunner) Start(binPath, socketPath, token string) (func() error, func(), error) {
+func (r *ProcessRunner) Start(binPath, socketPath, token string) (int, func() error, func(), error) {
 	args := []string{
 		"--socket-path=" + socketPath,
 		"--accept-server-license-terms",
@@ -48,7 +48,7 @@ func (r *ProcessRunner) Start(binPath, socketPath, token string) (func() error,
 	cmd.SysProcAttr = sysProcAttr()
 
 	if err := cmd.Start(); err != nil {
-		return nil, nil, fmt.Errorf("start code-server: %w", err)
+		return 0, nil, nil, fmt.Errorf("start code-server: %w", err)
 	}
 
 	pgid := cmd.Process.Pid
@@ -87,5 +87,5 @@ func (r *ProcessRunner) Start(binPath, socketPath, token string) (func() error,
 		}
 	}
 
-	return wait, stop, nil
+	return pgid, wait, stop, nil
 }
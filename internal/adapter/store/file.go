@@ -1,57 +1,266 @@
 package store
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 )
 
-// FileStore manages socket paths and session discovery in a directory.
+// FileStore manages socket paths and session discovery in a per-user
+// directory under the socket base directory:
+//
+//	<base>/            1777, shared by all users (like /tmp)
+//	<base>/<uid>/      0700, owned by <uid>; 0750 + group in shared mode
+//
+// Sessions of the current user are addressed by their plain name. Sessions
+// in another user's explicitly shared directory are addressed as "<uid>/<name>".
 type FileStore struct {
-	socketDir string
+	baseDir  string
+	uid      int
+	shareGID int // -1 unless the directory is shared with a group
 }
 
-// NewFileStore creates a store rooted at socketDir.
-func NewFileStore(socketDir string) *FileStore {
-	return &FileStore{socketDir: socketDir}
+// NewFileStore creates a private store for the current user under baseDir.
+func NewFileStore(baseDir string) *FileStore {
+	return &FileStore{baseDir: baseDir, uid: os.Getuid(), shareGID: -1}
+}
+
+// NewSharedFileStore creates a store whose directory and sockets are
+// accessible to members of gid.
+func NewSharedFileStore(baseDir string, gid int) *FileStore {
+	return &FileStore{baseDir: baseDir, uid: os.Getuid(), shareGID: gid}
+}
+
+// Dir returns the current user's socket directory.
+func (s *FileStore) Dir() string {
+	return filepath.Join(s.baseDir, strconv.Itoa(s.uid))
 }
 
 // SocketPath returns the data socket path for a session.
 func (s *FileStore) SocketPath(name string) string {
-	return filepath.Join(s.socketDir, name+".sock")
+	return s.sessionPath(name) + ".sock"
 }
 
+// VersionSocketPath returns the data socket path for an additional
+// code-server running commit alongside the session's primary one:
+// <name>.<commit12>.sock.
+func (s *FileStore) VersionSocketPath(name, commit string) string {
+	if len(commit) > versionPrefixLen {
+		commit = commit[:versionPrefixLen]
+	}
+	return s.sessionPath(name) + "." + commit + ".sock"
+}
+
+// versionPrefixLen is how many characters of a commit hash name a versioned socket.
+const versionPrefixLen = 12
+
 // CtlSocketPath returns the control socket path for a session.
 func (s *FileStore) CtlSocketPath(name string) string {
-	return filepath.Join(s.socketDir, name+".ctl.sock")
+	return s.sessionPath(name) + ".ctl.sock"
 }
 
-// ListSessionNames discovers sessions by globbing *.ctl.sock and returns
-// the session names (without the .ctl.sock suffix).
+// sessionPath maps a plain or "<uid>/<name>" qualified session name to its
+// socket path without suffix.
+func (s *FileStore) sessionPath(name string) string {
+	if strings.Contains(name, "/") {
+		return filepath.Join(s.baseDir, filepath.Clean("/"+name))
+	}
+	return filepath.Join(s.Dir(), name)
+}
+
+// ListSessionNames discovers sessions by globbing *.ctl.sock in the current
+// user's directory and in every other user directory that has been shared
+// with a group and is readable by us. Names from shared directories are
+// qualified as "<uid>/<name>".
 func (s *FileStore) ListSessionNames() ([]string, error) {
-	pattern := filepath.Join(s.socketDir, "*.ctl.sock")
-	matches, err := filepath.Glob(pattern)
+	names, err := globSessions(s.Dir(), "")
 	if err != nil {
 		return nil, err
 	}
 
+	entries, err := os.ReadDir(s.baseDir)
+	if err != nil {
+		return names, nil
+	}
+	own := strconv.Itoa(s.uid)
+	for _, e := range entries {
+		if e.Name() == own || !isNumeric(e.Name()) || !e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.Mode().Perm()&0o050 != 0o050 {
+			continue // private directory — not shared with anyone
+		}
+		shared, err := globSessions(filepath.Join(s.baseDir, e.Name()), e.Name()+"/")
+		if err != nil {
+			continue
+		}
+		names = append(names, shared...)
+	}
+	return names, nil
+}
+
+// SessionForSocket maps a code-server data socket path back to the session
+// it belongs to, in the form ListSessionNames uses. Side-by-side version
+// sockets map to their session. ok is false for paths outside the directories
+// ListSessionNames scans.
+func (s *FileStore) SessionForSocket(path string) (name string, ok bool) {
+	rel, err := filepath.Rel(s.baseDir, filepath.Clean(path))
+	if err != nil {
+		return "", false
+	}
+	dir, file, found := strings.Cut(rel, string(filepath.Separator))
+	if !found || strings.Contains(file, string(filepath.Separator)) || !s.watchable(dir) {
+		return "", false
+	}
+	file, found = strings.CutSuffix(file, ".sock")
+	if !found || file == "" || strings.HasSuffix(file, ".ctl") {
+		return "", false
+	}
+	if i := strings.LastIndexByte(file, '.'); i > 0 && isVersion(file[i+1:]) {
+		file = file[:i]
+	}
+	if dir == strconv.Itoa(s.uid) {
+		return file, true
+	}
+	return dir + "/" + file, true
+}
+
+func globSessions(dir, prefix string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.ctl.sock"))
+	if err != nil {
+		return nil, err
+	}
 	names := make([]string, 0, len(matches))
 	for _, m := range matches {
-		base := filepath.Base(m)
-		name := strings.TrimSuffix(base, ".ctl.sock")
-		names = append(names, name)
+		names = append(names, prefix+strings.TrimSuffix(filepath.Base(m), ".ctl.sock"))
 	}
 	return names, nil
 }
 
-// Remove deletes the control and data socket files for the given session name.
+// Remove deletes the control and data socket files for the given session
+// name, including data sockets of side-by-side versions left behind by a crash.
 func (s *FileStore) Remove(name string) error {
 	os.Remove(s.CtlSocketPath(name))
 	os.Remove(s.SocketPath(name))
+	matches, _ := filepath.Glob(s.sessionPath(name) + ".*.sock")
+	for _, m := range matches {
+		commit := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(m), filepath.Base(name)+"."), ".sock")
+		if isVersion(commit) {
+			os.Remove(m)
+		}
+	}
 	return nil
 }
 
-// EnsureDir creates the socket directory if it does not exist.
+// EnsureDir creates the base and per-user socket directories and verifies
+// that the per-user directory is a real directory owned by us with the
+// expected mode. A directory planted by another user is refused rather than
+// trusted.
 func (s *FileStore) EnsureDir() error {
-	return os.MkdirAll(s.socketDir, 0755)
+	if err := os.MkdirAll(s.baseDir, 0o755); err != nil {
+		return fmt.Errorf("create socket base dir: %w", err)
+	}
+	// Let every user create their own subdirectory. Only the owner of the
+	// base directory can (and needs to) fix its mode.
+	if info, err := os.Stat(s.baseDir); err == nil && ownerOf(info) == s.uid && info.Mode()&(os.ModeSticky|0o777) != os.ModeSticky|0o777 {
+		if err := os.Chmod(s.baseDir, os.ModeSticky|0o777); err != nil {
+			return fmt.Errorf("chmod socket base dir: %w", err)
+		}
+	}
+
+	dir := s.Dir()
+	if err := os.Mkdir(dir, s.dirMode()); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("create socket dir: %w (is %s writable by all users?)", err, s.baseDir)
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("stat socket dir: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("socket dir %s is not a directory", dir)
+	}
+	if owner := ownerOf(info); owner != s.uid {
+		return fmt.Errorf("socket dir %s is owned by uid %d, not %d; refusing to use it", dir, owner, s.uid)
+	}
+
+	if s.shareGID >= 0 {
+		if err := os.Chown(dir, -1, s.shareGID); err != nil {
+			return fmt.Errorf("share socket dir with gid %d: %w", s.shareGID, err)
+		}
+	}
+	if info.Mode().Perm() != s.dirMode() {
+		if err := os.Chmod(dir, s.dirMode()); err != nil {
+			return fmt.Errorf("chmod socket dir: %w", err)
+		}
+	}
+	return nil
+}
+
+// PrepareSocket adjusts a freshly created socket so group members can
+// connect in shared mode. In private mode the 0700 directory already keeps
+// everyone else out.
+func (s *FileStore) PrepareSocket(path string) error {
+	if s.shareGID < 0 {
+		return nil
+	}
+	if err := os.Chown(path, -1, s.shareGID); err != nil {
+		return fmt.Errorf("share socket %s: %w", path, err)
+	}
+	return os.Chmod(path, 0o660)
+}
+
+func (s *FileStore) dirMode() os.FileMode {
+	if s.shareGID >= 0 {
+		return 0o750
+	}
+	return 0o700
+}
+
+func ownerOf(info os.FileInfo) int {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid)
+	}
+	return -1
+}
+
+// isVersion reports whether s is the commit prefix of a versioned socket,
+// so that a session named e.g. "app.de" is not taken for a version of "app".
+func isVersion(s string) bool {
+	return len(s) == versionPrefixLen && isHex(s)
+}
+
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumeric(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// watchable reports whether a directory under the base holds sessions that
+// ListSessionNames would return: our own, or a numeric directory shared with
+// a group.
+func (s *FileStore) watchable(name string) bool {
+	if name == strconv.Itoa(s.uid) {
+		return true
+	}
+	if !isNumeric(name) {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(s.baseDir, name))
+	return err == nil && info.Mode().Perm()&0o050 == 0o050
 }
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestEnsureDir_CreatesPrivateUserDir(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "codetap")
+	s := NewFileStore(base)
+
+	if err := s.EnsureDir(); err != nil {
+		t.Fatalf("EnsureDir() error: %v", err)
+	}
+
+	want := filepath.Join(base, strconv.Itoa(os.Getuid()))
+	if s.Dir() != want {
+		t.Errorf("Dir() = %q, want %q", s.Dir(), want)
+	}
+	info, err := os.Stat(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o700 {
+		t.Errorf("user dir mode = %o, want 700", info.Mode().Perm())
+	}
+	baseInfo, err := os.Stat(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if baseInfo.Mode()&os.ModeSticky == 0 || baseInfo.Mode().Perm() != 0o777 {
+		t.Errorf("base dir mode = %v, want sticky 777", baseInfo.Mode())
+	}
+
+	// Tightens a directory left with a looser mode.
+	if err := os.Chmod(want, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnsureDir(); err != nil {
+		t.Fatalf("EnsureDir() second call error: %v", err)
+	}
+	if info, _ := os.Stat(want); info.Mode().Perm() != 0o700 {
+		t.Errorf("user dir mode after re-ensure = %o, want 700", info.Mode().Perm())
+	}
+}
+
+func TestEnsureDir_RefusesSymlink(t *testing.T) {
+	base := t.TempDir()
+	target := t.TempDir()
+	s := NewFileStore(base)
+	if err := os.Symlink(target, s.Dir()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.EnsureDir(); err == nil {
+		t.Fatal("EnsureDir() should refuse a symlinked user dir")
+	}
+}
+
+func TestEnsureDir_RefusesForeignOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("needs root to create a directory owned by another user")
+	}
+	base := t.TempDir()
+	s := NewFileStore(base)
+	if err := os.Mkdir(s.Dir(), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(s.Dir(), 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.EnsureDir(); err == nil {
+		t.Fatal("EnsureDir() should refuse a user dir owned by someone else")
+	}
+}
+
+func TestListSessionNames(t *testing.T) {
+	base := t.TempDir()
+	s := NewFileStore(base)
+	if err := s.EnsureDir(); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, filepath.Join(s.Dir(), "mine.ctl.sock"))
+	touch(t, filepath.Join(s.Dir(), "mine.sock"))
+
+	shared := filepath.Join(base, "4242")
+	if err := os.Mkdir(shared, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, filepath.Join(shared, "team.ctl.sock"))
+
+	private := filepath.Join(base, "4343")
+	if err := os.Mkdir(private, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, filepath.Join(private, "hidden.ctl.sock"))
+
+	// Legacy flat layout is not picked up.
+	touch(t, filepath.Join(base, "legacy.ctl.sock"))
+
+	names, err := s.ListSessionNames()
+	if err != nil {
+		t.Fatalf("ListSessionNames() error: %v", err)
+	}
+	sort.Strings(names)
+	want := []string{"4242/team", "mine"}
+	if len(names) != len(want) || names[0] != want[0] || names[1] != want[1] {
+		t.Errorf("ListSessionNames() = %v, want %v", names, want)
+	}
+
+	if got := s.CtlSocketPath("4242/team"); got != filepath.Join(shared, "team.ctl.sock") {
+		t.Errorf("CtlSocketPath(qualified) = %q", got)
+	}
+}
+
+func TestSessionPath_StaysUnderBase(t *testing.T) {
+	base := "/dev/shm/codetap"
+	s := NewFileStore(base)
+
+	if got := s.SocketPath("../../etc/passwd"); got != filepath.Join(base, "etc", "passwd.sock") {
+		t.Errorf("SocketPath(traversal) = %q, want it confined to %s", got, base)
+	}
+}
+
+func TestVersionSocketPath_AndRemove(t *testing.T) {
+	base := t.TempDir()
+	s := NewFileStore(base)
+	if err := s.EnsureDir(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := s.VersionSocketPath("dev", "072586267e68ece9a47aa43f8c108e0dcbf44622")
+	if got != filepath.Join(s.Dir(), "dev.072586267e68.sock") {
+		t.Errorf("VersionSocketPath() = %q", got)
+	}
+
+	touch(t, s.CtlSocketPath("dev"))
+	touch(t, s.SocketPath("dev"))
+	touch(t, got)
+	touch(t, s.SocketPath("dev.other")) // another session sharing the prefix
+	touch(t, s.SocketPath("dev.de"))    // ... whose suffix happens to be hex
+	touch(t, s.VersionSocketPath("dev.de", "072586267e68ece9a47aa43f8c108e0dcbf44622"))
+
+	if err := s.Remove("dev"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{s.CtlSocketPath("dev"), s.SocketPath("dev"), got} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed", p)
+		}
+	}
+	for _, p := range []string{s.SocketPath("dev.other"), s.SocketPath("dev.de"), s.VersionSocketPath("dev.de", "072586267e68")} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("unrelated session socket was removed: %v", err)
+		}
+	}
+}
+
+func TestSessionForSocket(t *testing.T) {
+	base := t.TempDir()
+	s := NewFileStore(base)
+	if err := s.EnsureDir(); err != nil {
+		t.Fatal(err)
+	}
+	shared := filepath.Join(base, "4242")
+	if err := os.Mkdir(shared, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(base, "4343"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path string
+		name string
+		ok   bool
+	}{
+		{s.SocketPath("dev"), "dev", true},
+		{s.VersionSocketPath("dev", "072586267e68ece9a47aa43f8c108e0dcbf44622"), "dev", true},
+		{s.SocketPath("dev.other"), "dev.other", true},
+		{filepath.Join(shared, "team.sock"), "4242/team", true},
+		{s.CtlSocketPath("dev"), "", false},
+		{filepath.Join(base, "4343", "hidden.sock"), "", false},
+		{filepath.Join(base, "legacy.sock"), "", false},
+		{"/tmp/elsewhere.sock", "", false},
+	}
+	for _, c := range cases {
+		name, ok := s.SessionForSocket(c.path)
+		if name != c.name || ok != c.ok {
+			t.Errorf("SessionForSocket(%q) = %q, %v; want %q, %v", c.path, name, ok, c.name, c.ok)
+		}
+	}
+}
+
+func touch(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
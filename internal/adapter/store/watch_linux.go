@@ -0,0 +1,91 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// watchMask selects the inotify events that can add or remove a session.
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// Watch reports changes to the set of sessions: a control socket appearing
+// or disappearing in the current user's directory or in a shared user
+// directory, or a user directory being created under the base directory.
+// Each receive on the returned channel stands for one or more changes.
+// Watching stops when done is closed.
+func (s *FileStore) Watch(done <-chan struct{}) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %w", err)
+	}
+	// A non-blocking fd lets the runtime poller unblock Read when f is closed.
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	base, err := syscall.InotifyAddWatch(fd, s.baseDir, watchMask|syscall.IN_ONLYDIR)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("watch %s: %w", s.baseDir, err)
+	}
+	addDir := func(name string) {
+		if s.watchable(name) {
+			_, _ = syscall.InotifyAddWatch(fd, filepath.Join(s.baseDir, name), watchMask|syscall.IN_ONLYDIR)
+		}
+	}
+	if entries, err := os.ReadDir(s.baseDir); err == nil {
+		for _, e := range entries {
+			if e.IsDir() {
+				addDir(e.Name())
+			}
+		}
+	}
+
+	changes := make(chan struct{}, 1)
+	signal := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		<-done
+		_ = f.Close()
+	}()
+
+	go func() {
+		defer close(changes)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			changed := false
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+				name := string(bytes.TrimRight(nameBytes, "\x00"))
+				off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+				switch {
+				case int(ev.Wd) == base:
+					if ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+						addDir(name)
+						changed = true
+					}
+				case strings.HasSuffix(name, ".ctl.sock"):
+					changed = true
+				}
+			}
+			if changed {
+				signal()
+			}
+		}
+	}()
+	return changes, nil
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch_ReportsSessionChanges(t *testing.T) {
+	base := t.TempDir()
+	s := NewFileStore(base)
+
+	done := make(chan struct{})
+	defer close(done)
+	changes, err := s.Watch(done)
+	if err != nil {
+		t.Fatalf("Watch() error: %v", err)
+	}
+
+	// The user directory is created after the watch started.
+	if err := s.EnsureDir(); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changes, "user dir created")
+
+	touch(t, filepath.Join(s.Dir(), "dev.sock"))
+	select {
+	case <-changes:
+		t.Fatal("a data socket alone should not be reported")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	touch(t, s.CtlSocketPath("dev"))
+	expectChange(t, changes, "control socket created")
+
+	if err := s.Remove("dev"); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, changes, "session removed")
+}
+
+func expectChange(t *testing.T, changes <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no change reported after %s", what)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package store
+
+import "errors"
+
+// Watch is not implemented outside Linux; callers fall back to polling.
+func (s *FileStore) Watch(done <-chan struct{}) (<-chan struct{}, error) {
+	return nil, errors.ErrUnsupported
+}
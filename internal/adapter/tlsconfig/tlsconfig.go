@@ -0,0 +1,52 @@
+// Package tlsconfig builds the TLS client configuration for talking to an
+// internal download mirror: extra trusted CAs and a client certificate.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Load returns a client TLS configuration that trusts the system roots plus
+// the PEM certificates in caBundle and presents the client certificate in
+// certFile and keyFile. keyFile may be empty when certFile holds both.
+// With all arguments empty it returns nil, the Go default.
+func Load(caBundle, certFile, keyFile string) (*tls.Config, error) {
+	if caBundle == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caBundle != "" {
+		pem, err := os.ReadFile(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s contains no PEM certificates", caBundle)
+		}
+		cfg.RootCAs = pool
+	}
+
+	switch {
+	case certFile == "" && keyFile != "":
+		return nil, errors.New("client key given without a client certificate")
+	case certFile != "":
+		if keyFile == "" {
+			keyFile = certFile
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
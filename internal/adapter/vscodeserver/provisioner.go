@@ -0,0 +1,135 @@
+// Package vscodeserver reuses the VS Code Servers that Remote-SSH and Dev
+// Containers install under ~/.vscode-server, so that codetap need not
+// download a server the machine already has.
+package vscodeserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"codetap/internal/domain"
+)
+
+// Repository is the codetap repository that found installs are linked into.
+type Repository interface {
+	domain.Provisioner
+	Link(srcDir, targetDir string) error
+}
+
+var commitRe = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// Provisioner implements domain.Provisioner on top of the codetap
+// repository. A commit the repository lacks is looked up in the VS Code
+// Server data directory; an install found there is hard-linked into the
+// repository, or run in place if it lives on another filesystem.
+type Provisioner struct {
+	repo    Repository
+	dataDir string
+	logger  domain.Logger
+
+	mu     sync.Mutex
+	direct map[string]string // commit → install run in place
+}
+
+// New creates a provisioner that falls back to installs in dataDir, usually
+// ~/.vscode-server.
+func New(repo Repository, dataDir string, logger domain.Logger) *Provisioner {
+	return &Provisioner{repo: repo, dataDir: dataDir, logger: logger, direct: map[string]string{}}
+}
+
+// IsProvisioned reports whether the repository or the data directory holds
+// a server for commit. A server found in the data directory is linked into
+// the repository first.
+func (p *Provisioner) IsProvisioned(commit string) bool {
+	if p.repo.IsProvisioned(commit) {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.direct[commit]; ok {
+		return true
+	}
+	src := p.Find(commit)
+	if src == "" {
+		return false
+	}
+	err := p.repo.Link(src, p.repo.ServerDir(commit))
+	if err == nil && p.repo.IsProvisioned(commit) {
+		return true
+	}
+	p.logger.Info("cannot link installed VS Code Server into the repository, running it in place", "source", src, "err", err)
+	p.direct[commit] = src
+	return true
+}
+
+// ServerBinPath returns the code-server binary for commit: the one of an
+// install run in place, or else the repository's.
+func (p *Provisioner) ServerBinPath(commit string) string {
+	p.mu.Lock()
+	src, ok := p.direct[commit]
+	p.mu.Unlock()
+	if ok && !p.repo.IsProvisioned(commit) {
+		return filepath.Join(src, "bin", "code-server")
+	}
+	return p.repo.ServerBinPath(commit)
+}
+
+// ServerDir returns the repository directory a server for commit is
+// extracted into.
+func (p *Provisioner) ServerDir(commit string) string {
+	return p.repo.ServerDir(commit)
+}
+
+// Find returns the directory of a complete install of commit in the data
+// directory, or "" if there is none. Both the layout of the legacy server
+// (bin/<commit>) and that of the VS Code CLI
+// (cli/servers/Stable-<commit>/server) are searched.
+func (p *Provisioner) Find(commit string) string {
+	if !commitRe.MatchString(commit) {
+		return ""
+	}
+	for _, dir := range []string{
+		filepath.Join(p.dataDir, "bin", commit),
+		filepath.Join(p.dataDir, "cli", "servers", "Stable-"+commit, "server"),
+	} {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		if err := validate(dir, commit); err != nil {
+			p.logger.Info("ignoring VS Code Server install", "path", dir, "err", err)
+			continue
+		}
+		return dir
+	}
+	return ""
+}
+
+// validate checks that dir holds an executable bin/code-server and that its
+// product.json names commit, which an interrupted install would lack.
+func validate(dir, commit string) error {
+	info, err := os.Stat(filepath.Join(dir, "bin", "code-server"))
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("bin/code-server is not an executable file")
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "product.json"))
+	if err != nil {
+		return err
+	}
+	var product struct {
+		Commit string `json:"commit"`
+	}
+	if err := json.Unmarshal(data, &product); err != nil {
+		return fmt.Errorf("product.json: %w", err)
+	}
+	if product.Commit != commit {
+		return fmt.Errorf("product.json is for commit %q", product.Commit)
+	}
+	return nil
+}
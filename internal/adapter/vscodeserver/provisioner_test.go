@@ -0,0 +1,130 @@
+package vscodeserver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"codetap/internal/adapter/extractor"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+var commit = strings.Repeat("a", 40)
+
+// install writes a server for commit into dir as VS Code would.
+func install(t *testing.T, dir, commit string) {
+	t.Helper()
+	for name, data := range map[string]string{
+		"bin/code-server": "#!/bin/sh\n",
+		"node":            "ELF",
+		"product.json":    `{"commit":"` + commit + `"}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// unlinkable is a repository on another filesystem than the data directory.
+type unlinkable struct{ *extractor.TarExtractor }
+
+func (unlinkable) Link(string, string) error { return errors.New("invalid cross-device link") }
+
+func TestFind_Layouts(t *testing.T) {
+	for name, sub := range map[string]string{
+		"legacy": filepath.Join("bin", commit),
+		"cli":    filepath.Join("cli", "servers", "Stable-"+commit, "server"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			data := t.TempDir()
+			install(t, filepath.Join(data, sub), commit)
+			p := New(extractor.NewTarExtractor(t.TempDir(), nopLogger{}), data, nopLogger{})
+			if got, want := p.Find(commit), filepath.Join(data, sub); got != want {
+				t.Errorf("Find() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFind_RefusesIncompleteInstalls(t *testing.T) {
+	data := t.TempDir()
+	p := New(extractor.NewTarExtractor(t.TempDir(), nopLogger{}), data, nopLogger{})
+
+	dir := filepath.Join(data, "bin", commit)
+	install(t, dir, strings.Repeat("b", 40))
+	if got := p.Find(commit); got != "" {
+		t.Errorf("Find() = %q for an install of another commit", got)
+	}
+	install(t, dir, commit)
+	if err := os.Chmod(filepath.Join(dir, "bin", "code-server"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Find(commit); got != "" {
+		t.Errorf("Find() = %q for a non-executable code-server", got)
+	}
+	if got := p.Find("../../etc"); got != "" {
+		t.Errorf("Find() = %q for an invalid commit", got)
+	}
+}
+
+func TestIsProvisioned_LinksIntoRepository(t *testing.T) {
+	data, repo := t.TempDir(), t.TempDir()
+	src := filepath.Join(data, "bin", commit)
+	install(t, src, commit)
+	p := New(extractor.NewTarExtractor(repo, nopLogger{}), data, nopLogger{})
+
+	if !p.IsProvisioned(commit) {
+		t.Fatal("IsProvisioned() = false with the server installed")
+	}
+	bin := p.ServerBinPath(commit)
+	if want := filepath.Join(repo, commit, "bin", "code-server"); bin != want {
+		t.Errorf("ServerBinPath() = %q, want %q", bin, want)
+	}
+	linked, err := os.Stat(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, _ := os.Stat(filepath.Join(src, "bin", "code-server"))
+	if !os.SameFile(linked, orig) {
+		t.Error("repository copy is not a hard link to the install")
+	}
+
+	// The repository copy outlives the install.
+	if err := os.RemoveAll(src); err != nil {
+		t.Fatal(err)
+	}
+	if !p.IsProvisioned(commit) {
+		t.Error("IsProvisioned() = false after the install was removed")
+	}
+}
+
+func TestIsProvisioned_RunsInPlaceAcrossFilesystems(t *testing.T) {
+	data, repo := t.TempDir(), t.TempDir()
+	src := filepath.Join(data, "cli", "servers", "Stable-"+commit, "server")
+	install(t, src, commit)
+	p := New(unlinkable{extractor.NewTarExtractor(repo, nopLogger{})}, data, nopLogger{})
+
+	if !p.IsProvisioned(commit) {
+		t.Fatal("IsProvisioned() = false with the server installed")
+	}
+	if got, want := p.ServerBinPath(commit), filepath.Join(src, "bin", "code-server"); got != want {
+		t.Errorf("ServerBinPath() = %q, want the install's %q", got, want)
+	}
+	if want := filepath.Join(repo, commit); p.ServerDir(commit) != want {
+		t.Errorf("ServerDir() = %q, want %q", p.ServerDir(commit), want)
+	}
+
+	if p.IsProvisioned(strings.Repeat("b", 40)) {
+		t.Error("IsProvisioned() = true for a commit nothing holds")
+	}
+}
@@ -0,0 +1,216 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"codetap/internal/domain"
+)
+
+// PruneOptions selects the cache entries CachePrune removes. An entry is
+// removed only if it is outside the Keep most recently used entries and,
+// when OlderThan is set, unused for at least that long.
+type PruneOptions struct {
+	Keep      int
+	OlderThan time.Duration
+	DryRun    bool // report what would be removed without removing it
+	Force     bool // remove even if a session did not answer, see CacheRemove
+}
+
+// CacheList returns the cached servers, most recently used first, with the
+// live sessions running each one.
+func (s *Service) CacheList() ([]domain.CacheEntry, error) {
+	entries, unknown, err := s.cacheEntries()
+	for _, name := range unknown {
+		s.logger.Error("session did not answer; the server it runs cannot be told", "name", name)
+	}
+	return entries, err
+}
+
+// cacheEntries is CacheList that returns the sessions that did not answer,
+// whose servers may be in use, instead of logging them.
+func (s *Service) cacheEntries() (entries []domain.CacheEntry, unknown []string, err error) {
+	entries, err = s.cache.Entries()
+	if err != nil {
+		return nil, nil, err
+	}
+	inUse, unknown, err := s.commitsInUse()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range entries {
+		entries[i].InUse = inUse[entries[i].Commit]
+	}
+	return entries, unknown, nil
+}
+
+// checkUnknown refuses to remove servers while sessions that did not answer
+// may be running any of them, unless force is set.
+func (s *Service) checkUnknown(unknown []string, force bool) error {
+	if len(unknown) == 0 {
+		return nil
+	}
+	if force {
+		s.logger.Error("session did not answer; removing servers anyway", "names", strings.Join(unknown, ","))
+		return nil
+	}
+	return fmt.Errorf("session %s did not answer and may be running any cached server; retry, or pass --force to remove anyway", strings.Join(unknown, ", "))
+}
+
+// CachePrune removes cached servers selected by opts and returns them.
+// Servers that a live session is running are never removed: neither those
+// that sessions in the socket directories report, nor those that any
+// session holds with domain.ServerCache.Use.
+func (s *Service) CachePrune(opts PruneOptions) ([]domain.CacheEntry, error) {
+	if opts.Keep < 0 {
+		return nil, errors.New("keep must not be negative")
+	}
+	entries, unknown, err := s.cacheEntries()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkUnknown(unknown, opts.Force); err != nil {
+		return nil, err
+	}
+
+	var removed []domain.CacheEntry
+	now := time.Now()
+	for i, e := range entries {
+		switch {
+		case i < opts.Keep:
+			continue
+		case opts.OlderThan > 0 && now.Sub(e.LastUsed) < opts.OlderThan:
+			continue
+		case len(e.InUse) > 0:
+			s.logger.Info("keeping server in use", "commit", e.Commit, "sessions", strings.Join(e.InUse, ","))
+			continue
+		}
+		if !opts.DryRun {
+			err := s.cache.Remove(e.Commit)
+			if errors.Is(err, domain.ErrServerInUse) {
+				s.logger.Info("keeping server in use", "commit", e.Commit, "err", err)
+				continue
+			}
+			if err != nil {
+				return removed, err
+			}
+		}
+		removed = append(removed, e)
+	}
+	return removed, nil
+}
+
+// CacheRemove removes one cached server, given by its commit or a unique
+// prefix of it, unless a live session is running it. A session that does
+// not answer may be running it too; without force that fails the removal.
+func (s *Service) CacheRemove(commit string, force bool) (domain.CacheEntry, error) {
+	entries, unknown, err := s.cacheEntries()
+	if err != nil {
+		return domain.CacheEntry{}, err
+	}
+	var matches []domain.CacheEntry
+	for _, e := range entries {
+		if strings.HasPrefix(e.Commit, strings.ToLower(commit)) {
+			matches = append(matches, e)
+		}
+	}
+	switch {
+	case commit == "" || len(matches) == 0:
+		return domain.CacheEntry{}, fmt.Errorf("commit %q is not cached", commit)
+	case len(matches) > 1:
+		return domain.CacheEntry{}, fmt.Errorf("commit prefix %q is ambiguous", commit)
+	}
+	e := matches[0]
+	if len(e.InUse) > 0 {
+		return e, fmt.Errorf("commit %s is in use by %s", e.Commit[:12], strings.Join(e.InUse, ", "))
+	}
+	if err := s.checkUnknown(unknown, force); err != nil {
+		return e, err
+	}
+	return e, s.cache.Remove(e.Commit)
+}
+
+// commitsInUse maps each commit that a live session runs, as its primary or
+// side-by-side server, to the names of those sessions. Idle sessions count:
+// their next CONNECT restarts the same commit. Sessions whose probe timed
+// out or failed are returned as unknown: they may be running any commit.
+func (s *Service) commitsInUse() (inUse map[string][]string, unknown []string, err error) {
+	sessions, err := s.List()
+	if err != nil {
+		return nil, nil, err
+	}
+	inUse = map[string][]string{}
+	for _, e := range sessions {
+		if e.Probe == domain.ProbeTimeout || e.Probe == domain.ProbeError {
+			unknown = append(unknown, e.Name)
+			continue
+		}
+		if !e.Alive {
+			continue
+		}
+		for _, c := range append([]string{e.Metadata.Commit}, e.Metadata.Commits...) {
+			if c != "" {
+				inUse[c] = append(inUse[c], e.Name)
+			}
+		}
+	}
+	for c := range inUse {
+		sort.Strings(inUse[c])
+	}
+	return inUse, unknown, nil
+}
+
+// heldServer is a session's use lock on one commit's server, counted so that
+// the primary and a side-by-side server on the same commit can share it.
+type heldServer struct {
+	release func()
+	n       int
+}
+
+// holdServer marks commit's server as used by the session, so that cache
+// removal in any process skips it; see domain.ServerCache.Use. Each call
+// must be matched by dropServer.
+func (s *Service) holdServer(state *sessionState, commit string) error {
+	if s.cache == nil {
+		return nil
+	}
+	state.heldMu.Lock()
+	defer state.heldMu.Unlock()
+	if h := state.held[commit]; h != nil {
+		h.n++
+		return nil
+	}
+	release, err := s.cache.Use(commit)
+	if err != nil {
+		return fmt.Errorf("lock server %s: %w", commit, err)
+	}
+	state.held[commit] = &heldServer{release: release, n: 1}
+	return nil
+}
+
+// dropServer undoes one holdServer for commit.
+func (s *Service) dropServer(state *sessionState, commit string) {
+	state.heldMu.Lock()
+	defer state.heldMu.Unlock()
+	h := state.held[commit]
+	if h == nil {
+		return
+	}
+	if h.n--; h.n == 0 {
+		h.release()
+		delete(state.held, commit)
+	}
+}
+
+// dropAllServers releases every use lock when the session ends.
+func (s *Service) dropAllServers(state *sessionState) {
+	state.heldMu.Lock()
+	defer state.heldMu.Unlock()
+	for commit, h := range state.held {
+		h.release()
+		delete(state.held, commit)
+	}
+}
@@ -0,0 +1,186 @@
+package app
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"codetap/internal/domain"
+)
+
+func cacheEntries(ages ...time.Duration) []domain.CacheEntry {
+	var entries []domain.CacheEntry
+	for i, age := range ages {
+		entries = append(entries, domain.CacheEntry{
+			Commit:   strings.Repeat(string(rune('a'+i)), 40),
+			LastUsed: time.Now().Add(-age),
+		})
+	}
+	return entries
+}
+
+func TestCachePrune_KeepsRecentAndInUse(t *testing.T) {
+	dir := setupTestDir(t)
+	day := 24 * time.Hour
+	sc := &mockCache{entries: cacheEntries(0, 2*day, 40*day, 50*day, 60*day)}
+	a, b, c, d, e := sc.entries[0].Commit, sc.entries[1].Commit, sc.entries[2].Commit, sc.entries[3].Commit, sc.entries[4].Commit
+
+	// A live session still runs the oldest server, side by side with a newer one.
+	serveCtl(t, filepath.Join(dir, "work.ctl.sock"), `{"name":"work","commit":"`+a+`","versions":[{"commit":"`+e+`"}]}`)
+	svc := NewService(nil, nil, nil, sc, nil, newMockStore(dir), &mockTokenGen{}, &mockLogger{})
+
+	removed, err := svc.CachePrune(PruneOptions{Keep: 1, OlderThan: 30 * day, DryRun: true})
+	if err != nil {
+		t.Fatalf("CachePrune(dry run) error: %v", err)
+	}
+	if len(sc.removed) != 0 {
+		t.Errorf("dry run removed %v", sc.removed)
+	}
+	var got []string
+	for _, r := range removed {
+		got = append(got, r.Commit)
+	}
+	if want := []string{c, d}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dry run selected %v, want %v", got, want)
+	}
+
+	if _, err := svc.CachePrune(PruneOptions{Keep: 1}); err != nil {
+		t.Fatalf("CachePrune() error: %v", err)
+	}
+	if want := []string{b, c, d}; !reflect.DeepEqual(sc.removed, want) {
+		t.Errorf("removed %v, want %v (never %s, which is in use)", sc.removed, want, e)
+	}
+}
+
+func TestCacheRemove_RefusesInUseAndAmbiguous(t *testing.T) {
+	dir := setupTestDir(t)
+	sc := &mockCache{entries: cacheEntries(0, time.Hour)}
+	sc.entries = append(sc.entries, domain.CacheEntry{Commit: "ab" + strings.Repeat("0", 38)})
+	a, b := sc.entries[0].Commit, sc.entries[1].Commit
+
+	serveCtl(t, filepath.Join(dir, "work.ctl.sock"), `{"name":"work","commit":"`+a+`"}`)
+	svc := NewService(nil, nil, nil, sc, nil, newMockStore(dir), &mockTokenGen{}, &mockLogger{})
+
+	if _, err := svc.CacheRemove(a[:12], false); err == nil || !strings.Contains(err.Error(), "in use by work") {
+		t.Errorf("CacheRemove(in use) error = %v, want in use by work", err)
+	}
+	if _, err := svc.CacheRemove("a", false); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("CacheRemove(a) error = %v, want ambiguous", err)
+	}
+	if _, err := svc.CacheRemove("ffff", false); err == nil {
+		t.Error("CacheRemove(unknown) succeeded")
+	}
+	e, err := svc.CacheRemove(b[:8], false)
+	if err != nil || e.Commit != b {
+		t.Fatalf("CacheRemove(%s) = %v, %v", b[:8], e.Commit, err)
+	}
+	if !reflect.DeepEqual(sc.removed, []string{b}) {
+		t.Errorf("removed %v, want only %s", sc.removed, b)
+	}
+}
+
+func TestCache_HungSessionBlocksRemovalUnlessForced(t *testing.T) {
+	defer func(d time.Duration) { probeTimeout = d }(probeTimeout)
+	probeTimeout = 200 * time.Millisecond
+
+	dir := setupTestDir(t)
+	sc := &mockCache{entries: cacheEntries(0, 40*24*time.Hour)}
+	old := sc.entries[1].Commit
+	serveCtl(t, filepath.Join(dir, "hung.ctl.sock"), "")
+	svc := NewService(nil, nil, nil, sc, nil, newMockStore(dir), &mockTokenGen{}, &mockLogger{})
+
+	if _, err := svc.CachePrune(PruneOptions{Keep: 1}); err == nil || !strings.Contains(err.Error(), "hung") {
+		t.Errorf("CachePrune() error = %v, want the hung session named", err)
+	}
+	if _, err := svc.CacheRemove(old[:12], false); err == nil {
+		t.Error("CacheRemove() succeeded with a session that did not answer")
+	}
+	if len(sc.removed) != 0 {
+		t.Fatalf("removed %v while a session did not answer", sc.removed)
+	}
+
+	removed, err := svc.CachePrune(PruneOptions{Keep: 1, Force: true})
+	if err != nil || len(removed) != 1 || removed[0].Commit != old {
+		t.Errorf("CachePrune(force) = %v, %v; want %s removed", removed, err, old)
+	}
+}
+
+func TestProvision_MarksCommitUsed(t *testing.T) {
+	sc := &mockCache{}
+	pr := &mockProvisioner{provisioned: true}
+	svc := NewService(nil, nil, pr, sc, nil, newMockStore(setupTestDir(t)), &mockTokenGen{}, &mockLogger{})
+	if _, err := svc.Provision("abc123", "x64"); err != nil {
+		t.Fatalf("Provision() error: %v", err)
+	}
+	if !reflect.DeepEqual(sc.used, []string{"abc123"}) {
+		t.Errorf("MarkUsed calls = %v, want [abc123]", sc.used)
+	}
+}
+
+func TestCachePrune_SkipsServersHeldByOtherSessions(t *testing.T) {
+	dir := setupTestDir(t)
+	sc := &mockCache{entries: cacheEntries(0, time.Hour, 2*time.Hour)}
+	a, b, c := sc.entries[0].Commit, sc.entries[1].Commit, sc.entries[2].Commit
+	// A session in another socket directory, or without one, runs b.
+	sc.inUse = map[string]bool{b: true}
+	svc := NewService(nil, nil, nil, sc, nil, newMockStore(dir), &mockTokenGen{}, &mockLogger{})
+
+	removed, err := svc.CachePrune(PruneOptions{})
+	if err != nil {
+		t.Fatalf("CachePrune() error: %v", err)
+	}
+	if want := []string{a, c}; !reflect.DeepEqual(sc.removed, want) {
+		t.Errorf("removed %v, want %v", sc.removed, want)
+	}
+	if len(removed) != 2 {
+		t.Errorf("reported %d removed, want 2", len(removed))
+	}
+	if _, err := svc.CacheRemove(b, false); !errors.Is(err, domain.ErrServerInUse) {
+		t.Errorf("CacheRemove(held) error = %v, want ErrServerInUse", err)
+	}
+}
+
+func TestRun_HoldsServersItRuns(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	runner := &multiRunner{}
+	sc := &mockCache{}
+	svc := NewService(
+		&mockDownloader{downloadFn: func(_, _ string) (string, error) { return "", nil }},
+		&mockExtractor{extractFn: func(_, _ string) error { return nil }},
+		&mockProvisioner{provisioned: true, binPath: "/bin/cs"},
+		sc, runner, st, &mockTokenGen{token: "tok"}, &mockLogger{},
+	)
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- svc.Run(testConfig(dir))
+	}()
+	ctlPath := st.CtlSocketPath("test-session")
+	waitForCtlSocket(t, ctlPath)
+
+	// Switching versions holds the new server before letting go of the old.
+	conn, line := connectCTAP1(t, ctlPath, "def456", "client-1")
+	defer conn.Close()
+	if !startsWith(line, "OK") {
+		t.Fatalf("CONNECT = %q", line)
+	}
+	sc.mu.Lock()
+	held, released := append([]string(nil), sc.held...), append([]string(nil), sc.released...)
+	sc.mu.Unlock()
+	if want := []string{"abc123", "def456"}; !reflect.DeepEqual(held, want) {
+		t.Errorf("held %v, want %v", held, want)
+	}
+	if want := []string{"abc123"}; !reflect.DeepEqual(released, want) {
+		t.Errorf("released %v after the switch, want %v", released, want)
+	}
+
+	runner.stopAll()
+	<-runDone
+	if want := []string{"abc123", "def456"}; !reflect.DeepEqual(sc.released, want) {
+		t.Errorf("released %v after the session ended, want %v", sc.released, want)
+	}
+}
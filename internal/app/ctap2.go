@@ -0,0 +1,234 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"codetap/internal/adapter/ctap2"
+	"codetap/internal/domain"
+)
+
+// lease is a granted CONNECT held open by a client. CTAP1 leases own the
+// whole connection; CTAP2 leases share it with further requests.
+type lease struct {
+	conn   net.Conn
+	rpc    *ctap2.Conn     // nil for CTAP1 leases
+	peer   domain.PeerCred // process that holds the lease
+	commit string          // version the lease was granted on
+}
+
+// CTAP2 method parameters and results. The exported ones are also spoken by
+// codetap relay, so both ends share one schema.
+type (
+	// HelloParams is the hello notification sent on every new connection.
+	HelloParams struct {
+		Protocol string   `json:"protocol"`
+		Methods  []string `json:"methods"`
+	}
+
+	// ConnectParams are the parameters of connect.
+	ConnectParams struct {
+		Commit    string `json:"commit"`
+		ClientID  string `json:"client_id"`
+		Keepalive int    `json:"keepalive,omitempty"` // ping interval in seconds
+		Force     bool   `json:"force,omitempty"`     // take over from other versions
+	}
+
+	// ConnectResult is the result of connect.
+	ConnectResult struct {
+		Token     string `json:"token"`
+		Commit    string `json:"commit"`
+		Socket    string `json:"socket"`              // data socket serving Commit
+		Keepalive int    `json:"keepalive,omitempty"` // negotiated ping interval
+	}
+
+	// VersionMismatch is the data of a CodeVersionMismatch error.
+	VersionMismatch struct {
+		Commit  string `json:"commit"`
+		Clients int    `json:"clients"`
+	}
+
+	leaseEvent struct {
+		ClientID string `json:"client_id"`
+		Commit   string `json:"commit"`
+		Reason   string `json:"reason,omitempty"`
+	}
+
+	restartEvent struct {
+		From  string `json:"from"`
+		To    string `json:"to"`
+		Error string `json:"error,omitempty"` // why session.restart_failed
+	}
+
+	takeoverEvent struct {
+		ClientID string `json:"client_id"` // lease being revoked
+		Commit   string `json:"commit"`    // version taking over
+		By       string `json:"by"`        // client forcing the switch
+		GraceS   int    `json:"grace_s"`   // seconds until the connection is closed
+	}
+)
+
+// CTAP2Methods lists the methods advertised in the hello notification.
+var CTAP2Methods = []string{"info", "health", "connect"}
+
+// ctap2WriteTimeout bounds each write to a CTAP2 client. A client that does
+// not read for that long, such as a "codetap list --watch" whose output is
+// blocked, is dropped rather than left to stall everyone else.
+var ctap2WriteTimeout = 2 * time.Second
+
+// notify sends a notification to every connected CTAP2 client, in parallel
+// so that a slow client delays it by at most ctap2WriteTimeout. Clients the
+// notification cannot be written to are disconnected, which releases their
+// leases. It must be called without holding st.mu.
+func (st *sessionState) notify(method string, params any) {
+	st.mu.Lock()
+	conns := make(map[*ctap2.Conn]net.Conn, len(st.watchers))
+	for rc, conn := range st.watchers {
+		conns[rc] = conn
+	}
+	st.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for rc, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := rc.Notify(method, params); err != nil {
+				_ = conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// serveCTAP2 speaks JSON-RPC 2.0 on a control connection whose first line was
+// "CTAP2". The connection stays open until the client closes it; leases
+// granted by "connect" are released at that point. A connect that asks for a
+// keepalive starts "ping" notifications on the connection, and the whole
+// connection expires if the client then stays silent past the lease timeout.
+func (s *Service) serveCTAP2(conn net.Conn, peer domain.PeerCred, reader *bufio.Reader, state *sessionState, restartCh chan restartReq) {
+	_ = conn.SetReadDeadline(time.Time{})
+	rc := ctap2.NewConn(reader, conn)
+	rc.SetWriteTimeout(ctap2WriteTimeout)
+
+	state.mu.Lock()
+	state.watchers[rc] = conn
+	state.mu.Unlock()
+
+	var held []string
+	var ka *keepalive
+	reason := releaseClosed
+	defer func() {
+		if ka != nil {
+			ka.stop()
+		}
+		state.mu.Lock()
+		delete(state.watchers, rc)
+		state.mu.Unlock()
+		_ = conn.Close()
+		for _, id := range held {
+			s.releaseLease(state, id, conn, reason)
+		}
+	}()
+
+	_ = rc.Notify("hello", HelloParams{Protocol: ctap2.Hello, Methods: CTAP2Methods})
+
+	for {
+		if ka != nil {
+			ka.extend(conn)
+		}
+		req, err := rc.ReadRequest()
+		if err != nil {
+			if ka != nil && isTimeout(err) {
+				s.logger.Error("lease keepalive timed out", "clients", held, "timeout", ka.timeout)
+				reason = releaseExpired
+			}
+			return
+		}
+
+		switch req.Method {
+		case "info":
+			_ = rc.Reply(req.ID, s.info(state))
+
+		case "health":
+			_ = rc.Reply(req.ID, s.checkHealth(state))
+
+		case "connect":
+			var p ConnectParams
+			if perr := ctap2.DecodeParams(req, &p); perr != nil {
+				_ = rc.ReplyError(req.ID, perr)
+				continue
+			}
+			if p.Commit == "" || p.ClientID == "" {
+				_ = rc.ReplyError(req.ID, ctap2.Errorf(ctap2.CodeInvalidParams, "connect requires commit and client_id"))
+				continue
+			}
+			var interval time.Duration
+			if p.Keepalive != 0 {
+				var kerr error
+				if interval, kerr = parseKeepalive(p.Keepalive); kerr != nil {
+					_ = rc.ReplyError(req.ID, ctap2.Errorf(ctap2.CodeInvalidParams, "%v", kerr))
+					continue
+				}
+			}
+			result, cerr := s.connect(state, p.Commit, p.ClientID, p.Force, &lease{conn: conn, rpc: rc, peer: peer}, restartCh)
+			if cerr != nil {
+				_ = rc.ReplyError(req.ID, cerr)
+				continue
+			}
+			held = append(held, p.ClientID)
+			// The first keepalive negotiated on a connection covers all of its leases.
+			if interval > 0 && ka == nil {
+				ka = newKeepalive(interval, state.leaseTimeout)
+				ka.start(func() error { return rc.Notify("ping", nil) })
+			}
+			if ka != nil {
+				result.Keepalive = ka.seconds()
+			}
+			_ = rc.Reply(req.ID, result)
+
+		default:
+			if !req.IsNotification() {
+				_ = rc.ReplyError(req.ID, ctap2.Errorf(ctap2.CodeMethodNotFound, "method %q not found", req.Method))
+			}
+		}
+	}
+}
+
+// SubscribeCtl opens a CTAP2 connection to a control socket and delivers the
+// method of every notification the session sends, starting with "hello".
+// The channel is closed when the connection ends, which usually means the
+// session went away; stop ends the subscription early.
+func SubscribeCtl(ctlPath string) (events <-chan string, stop func(), err error) {
+	conn, err := net.DialTimeout("unix", ctlPath, time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := fmt.Fprintf(conn, "%s\n", ctap2.Hello); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	ch := make(chan string, 16)
+	go func() {
+		defer close(ch)
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				return
+			}
+			var msg struct {
+				Method string `json:"method"`
+			}
+			if json.Unmarshal(line, &msg) == nil && msg.Method != "" {
+				ch <- msg.Method
+			}
+		}
+	}()
+	return ch, func() { _ = conn.Close() }, nil
+}
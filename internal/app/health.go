@@ -0,0 +1,181 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"codetap/internal/domain"
+)
+
+// startGrace is how long a freshly started server may fail probes before it
+// is reported as degraded instead of starting.
+const startGrace = 30 * time.Second
+
+// HealthResponse is the JSON payload for CTAP1 HEALTH, from both codetap run
+// and codetap relay.
+type HealthResponse struct {
+	State        string `json:"state"`
+	ServerPID    int    `json:"server_pid"`
+	ProcessAlive bool   `json:"process_alive"`
+	SocketOK     bool   `json:"socket_ok"`
+	ProbeMS      int64  `json:"probe_ms"`
+	UptimeS      int64  `json:"uptime_s"`
+	ReadyAt      string `json:"ready_at,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// handleHealth probes code-server and responds with its readiness state.
+// The probe runs outside the state lock so a hung server cannot stall other
+// control connections.
+func (s *Service) handleHealth(conn net.Conn, state *sessionState) {
+	defer conn.Close()
+
+	data, _ := json.Marshal(s.checkHealth(state))
+	data = append(data, '\n')
+	_, _ = conn.Write(data)
+}
+
+// checkHealth evaluates the process group and data socket of the session's
+// code-server and classifies the result.
+func (s *Service) checkHealth(state *sessionState) HealthResponse {
+	state.mu.Lock()
+	pgid := state.serverPID
+	socketPath := state.socketPath
+	startedAt := state.startedAt
+	restarting := state.restartInProgress
+	idle := state.idle
+	waiting := state.waiting
+	state.mu.Unlock()
+
+	resp := HealthResponse{
+		ServerPID: pgid,
+		UptimeS:   int64(time.Since(startedAt).Seconds()),
+	}
+
+	if restarting {
+		resp.State = domain.HealthRestarting
+		return resp
+	}
+	if waiting {
+		resp.State = domain.HealthWaiting
+		return resp
+	}
+	if idle {
+		resp.State = domain.HealthIdle
+		return resp
+	}
+
+	resp.ProcessAlive = processGroupAlive(pgid)
+	latency, probeErr := ProbeServer(socketPath)
+	resp.ProbeMS = latency.Milliseconds()
+	resp.SocketOK = probeErr == nil
+
+	state.mu.Lock()
+	if resp.ProcessAlive && resp.SocketOK && state.readyAt.IsZero() {
+		state.readyAt = time.Now()
+	}
+	readyAt := state.readyAt
+	state.mu.Unlock()
+	if !readyAt.IsZero() {
+		resp.ReadyAt = readyAt.Format(time.RFC3339)
+	}
+
+	switch {
+	case !resp.ProcessAlive:
+		resp.State = domain.HealthDegraded
+		resp.Error = fmt.Sprintf("process group %d not running", pgid)
+	case resp.SocketOK:
+		resp.State = domain.HealthReady
+	case readyAt.IsZero() && time.Since(startedAt) < startGrace:
+		resp.State = domain.HealthStarting
+		resp.Error = probeErr.Error()
+	default:
+		resp.State = domain.HealthDegraded
+		resp.Error = probeErr.Error()
+	}
+	return resp
+}
+
+// ProbeServer performs a cheap HTTP handshake against the code-server data
+// socket (GET /version) and returns the round-trip latency. A hung node
+// process accepts the connection but never answers, which the read deadline
+// turns into an error.
+func ProbeServer(socketPath string) (time.Duration, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		return time.Since(start), fmt.Errorf("dial data socket: %w", err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := fmt.Fprintf(conn, "GET /version HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"); err != nil {
+		return time.Since(start), fmt.Errorf("write probe: %w", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return time.Since(start), fmt.Errorf("read probe response: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return time.Since(start), fmt.Errorf("probe returned HTTP %d", resp.StatusCode)
+	}
+	return time.Since(start), nil
+}
+
+// processGroupAlive reports whether any process in the group still exists.
+func processGroupAlive(pgid int) bool {
+	if pgid <= 0 {
+		return false
+	}
+	err := syscall.Kill(-pgid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// QueryCtlHealth connects to a control socket, sends CTAP1 HEALTH, and parses
+// the response. Returns false if the session does not answer or predates the
+// HEALTH command.
+func QueryCtlHealth(ctlPath string) (domain.Health, bool) {
+	return queryCtlHealth(ctlPath, time.Now().Add(6*time.Second))
+}
+
+// queryCtlHealth is QueryCtlHealth with every step bounded by deadline.
+func queryCtlHealth(ctlPath string, deadline time.Time) (domain.Health, bool) {
+	conn, err := dialCtl(ctlPath, 5*time.Second, deadline)
+	if err != nil {
+		return domain.Health{}, false
+	}
+	defer conn.Close()
+
+	_, _ = fmt.Fprintf(conn, "CTAP1 HEALTH\n")
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil || strings.HasPrefix(line, "ERR") {
+		return domain.Health{}, false
+	}
+
+	var resp HealthResponse
+	if err := json.Unmarshal([]byte(line), &resp); err != nil {
+		return domain.Health{}, false
+	}
+
+	readyAt, _ := time.Parse(time.RFC3339, resp.ReadyAt)
+	return domain.Health{
+		State:        resp.State,
+		ServerPID:    resp.ServerPID,
+		ProcessAlive: resp.ProcessAlive,
+		SocketOK:     resp.SocketOK,
+		ProbeLatency: time.Duration(resp.ProbeMS) * time.Millisecond,
+		Uptime:       time.Duration(resp.UptimeS) * time.Second,
+		ReadyAt:      readyAt,
+		Error:        resp.Error,
+	}, true
+}
@@ -0,0 +1,209 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"codetap/internal/domain"
+)
+
+// serveVersion serves a minimal code-server /version endpoint on a Unix socket.
+func serveVersion(t *testing.T, sock string) net.Listener {
+	t.Helper()
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() {
+		_ = http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/version" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte("abc123"))
+		}))
+	}()
+	return l
+}
+
+// httpMockRunner is a blocking runner that serves /version on the data socket.
+type httpMockRunner struct {
+	t        *testing.T
+	mu       sync.Mutex
+	listener net.Listener
+	stopCh   chan struct{}
+}
+
+func (m *httpMockRunner) Start(bin, sock, token string) (int, func() error, func(), error) {
+	m.mu.Lock()
+	m.listener = serveVersion(m.t, sock)
+	m.mu.Unlock()
+	wait := func() error {
+		<-m.stopCh
+		return nil
+	}
+	return syscall.Getpgrp(), wait, m.Stop, nil
+}
+
+func (m *httpMockRunner) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.stopCh:
+	default:
+		close(m.stopCh)
+		_ = m.listener.Close()
+	}
+}
+
+func TestProbeServer_Ready(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "data.sock")
+	l := serveVersion(t, sock)
+	defer l.Close()
+
+	if _, err := ProbeServer(sock); err != nil {
+		t.Fatalf("ProbeServer() error: %v", err)
+	}
+}
+
+func TestProbeServer_NoListener(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "missing.sock")
+	if _, err := ProbeServer(sock); err == nil {
+		t.Fatal("expected error for missing data socket")
+	}
+}
+
+func TestProbeServer_Hung(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "hung.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	// Accept but never answer, like a wedged node process.
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	_, err = ProbeServer(sock)
+	if err == nil {
+		t.Fatal("expected error for hung server")
+	}
+}
+
+func TestProcessGroupAlive(t *testing.T) {
+	if !processGroupAlive(syscall.Getpgrp()) {
+		t.Error("own process group should be alive")
+	}
+	if processGroupAlive(0) {
+		t.Error("pgid 0 should not be reported alive")
+	}
+}
+
+func TestRun_HealthReady(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	runner := &httpMockRunner{t: t, stopCh: make(chan struct{})}
+
+	svc := newTestService(
+		&mockDownloader{downloadFn: func(_, _ string) (string, error) { return "", nil }},
+		&mockExtractor{extractFn: func(_, _ string) error { return nil }},
+		&mockProvisioner{provisioned: true, binPath: "/bin/cs"},
+		runner, st,
+		&mockTokenGen{token: "tok"},
+	)
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- svc.Run(testConfig(dir))
+	}()
+
+	ctlPath := st.CtlSocketPath("test-session")
+	waitForCtlSocket(t, ctlPath)
+
+	health, ok := QueryCtlHealth(ctlPath)
+	if !ok {
+		runner.Stop()
+		t.Fatal("QueryCtlHealth() failed")
+	}
+	if health.State != domain.HealthReady {
+		t.Errorf("state = %q, want %q (err %q)", health.State, domain.HealthReady, health.Error)
+	}
+	if !health.ProcessAlive || !health.SocketOK {
+		t.Errorf("expected process and socket OK, got %+v", health)
+	}
+	if health.ReadyAt.IsZero() {
+		t.Error("expected ready_at to be set")
+	}
+
+	runner.Stop()
+	<-runDone
+}
+
+func TestRun_HealthStartingWhenSocketSilent(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	runner := newBlockingRunner() // creates a plain file, nothing listens
+
+	svc := newTestService(
+		&mockDownloader{downloadFn: func(_, _ string) (string, error) { return "", nil }},
+		&mockExtractor{extractFn: func(_, _ string) error { return nil }},
+		&mockProvisioner{provisioned: true, binPath: "/bin/cs"},
+		runner, st,
+		&mockTokenGen{token: "tok"},
+	)
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- svc.Run(testConfig(dir))
+	}()
+
+	ctlPath := st.CtlSocketPath("test-session")
+	waitForCtlSocket(t, ctlPath)
+
+	conn, err := net.DialTimeout("unix", ctlPath, time.Second)
+	if err != nil {
+		runner.Stop()
+		t.Fatalf("dial: %v", err)
+	}
+	_, _ = fmt.Fprintf(conn, "CTAP1 HEALTH\n")
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	conn.Close()
+	if err != nil {
+		runner.Stop()
+		t.Fatalf("read HEALTH response: %v", err)
+	}
+
+	var resp map[string]any
+	if err := json.Unmarshal([]byte(line), &resp); err != nil {
+		runner.Stop()
+		t.Fatalf("parse HEALTH response: %v", err)
+	}
+	if resp["state"] != domain.HealthStarting {
+		t.Errorf("state = %v, want %q", resp["state"], domain.HealthStarting)
+	}
+	if resp["socket_ok"] != false {
+		t.Errorf("socket_ok = %v, want false", resp["socket_ok"])
+	}
+	if !strings.Contains(fmt.Sprint(resp["error"]), "data socket") {
+		t.Errorf("expected probe error, got %v", resp["error"])
+	}
+
+	runner.Stop()
+	<-runDone
+}
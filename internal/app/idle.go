@@ -0,0 +1,86 @@
+package app
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"codetap/internal/domain"
+)
+
+// minIdleCheckInterval keeps tiny idle timeouts from yielding a zero ticker
+// interval, which time.NewTicker rejects with a panic.
+const minIdleCheckInterval = 10 * time.Millisecond
+
+// idleCheckInterval returns how often the lifecycle goroutine checks for an
+// idle session: a quarter of the timeout, but at least once a minute and at
+// most every minIdleCheckInterval.
+func idleCheckInterval(timeout time.Duration) time.Duration {
+	interval := timeout / 4
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	if interval < minIdleCheckInterval {
+		interval = minIdleCheckInterval
+	}
+	return interval
+}
+
+// enterIdle marks the session idle if no lease has been held for timeout.
+// Marking happens under the lock before code-server is stopped, so a CONNECT
+// racing with the shutdown takes the start path instead of being handed the
+// token of a dying server.
+func (st *sessionState) enterIdle(timeout time.Duration) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.stopped() || st.restartInProgress || len(st.leases) > 0 || time.Since(st.idleSince) < timeout {
+		return false
+	}
+	st.idle = true
+	st.serverPID = 0
+	return true
+}
+
+// stopped reports whether no primary code-server is running, either because
+// the session went idle or because a lazy session has not started one yet.
+// The caller must hold st.mu.
+func (st *sessionState) stopped() bool {
+	return st.idle || st.waiting
+}
+
+// status reports the INFO status of the session. The caller must hold st.mu.
+func (st *sessionState) status() string {
+	if st.waiting {
+		return domain.StatusWaiting
+	}
+	if st.idle {
+		return domain.StatusIdle
+	}
+	return domain.StatusRunning
+}
+
+// watchServer waits for a code-server process in the background.
+func watchServer(wait func() error) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- wait()
+	}()
+	return done
+}
+
+// waitForWake blocks while no code-server is running until a CONNECT asks for
+// one. With no child to forward signals to, SIGINT, SIGTERM and SIGHUP end
+// the session here instead; it then returns false.
+func waitForWake(restartCh chan restartReq) (restartReq, bool) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+
+	select {
+	case req := <-restartCh:
+		return req, true
+	case <-sigCh:
+		return restartReq{}, false
+	}
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"codetap/internal/domain"
+)
+
+func TestRun_IdleShutdownAndWake(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	runner := &multiRunner{}
+
+	svc := newTestService(
+		&mockDownloader{downloadFn: func(_, _ string) (string, error) { return "", nil }},
+		&mockExtractor{extractFn: func(_, _ string) error { return nil }},
+		&mockProvisioner{provisioned: true, binPath: "/bin/cs"},
+		runner, st,
+		&mockTokenGen{token: "tok"},
+	)
+
+	cfg := testConfig(dir)
+	cfg.IdleTimeout = 100 * time.Millisecond
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- svc.Run(cfg)
+	}()
+	defer func() {
+		runner.stopAll()
+		<-runDone
+	}()
+
+	ctlPath := st.CtlSocketPath("test-session")
+	waitForCtlSocket(t, ctlPath)
+
+	// A held lease keeps the server up past the timeout.
+	c1, line := connectCTAP1(t, ctlPath, "abc123", "client-1")
+	if line != "OK tok\n" {
+		t.Fatalf("CONNECT = %q", line)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if info := queryInfo(t, ctlPath); info.Status != domain.StatusRunning {
+		t.Fatalf("status with a lease = %q, want running", info.Status)
+	}
+
+	// Without leases the server is stopped but the control socket stays up.
+	c1.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for queryInfo(t, ctlPath).Status != domain.StatusIdle {
+		if time.Now().After(deadline) {
+			t.Fatal("session did not go idle")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if health, ok := QueryCtlHealth(ctlPath); !ok || health.State != domain.HealthIdle {
+		t.Errorf("HEALTH while idle = %+v, %v", health, ok)
+	}
+
+	// The next CONNECT starts code-server again before answering.
+	c2, line := connectCTAP1(t, ctlPath, "abc123", "client-2")
+	defer c2.Close()
+	if line != "OK tok\n" {
+		t.Fatalf("CONNECT after idle = %q", line)
+	}
+	if n := len(runner.started()); n != 2 {
+		t.Errorf("started %d servers, want 2", n)
+	}
+	if info := queryInfo(t, ctlPath); info.Status != domain.StatusRunning {
+		t.Errorf("status after wake = %q, want running", info.Status)
+	}
+}
+
+func TestRun_LazyStartsOnFirstConnect(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	runner := &multiRunner{}
+
+	svc := newTestService(
+		&mockDownloader{downloadFn: func(_, _ string) (string, error) { return "", nil }},
+		&mockExtractor{extractFn: func(_, _ string) error { return nil }},
+		&mockProvisioner{provisioned: true, binPath: "/bin/cs"},
+		runner, st,
+		&mockTokenGen{token: "tok"},
+	)
+
+	cfg := testConfig(dir)
+	cfg.Commit = ""
+	cfg.Lazy = true
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- svc.Run(cfg)
+	}()
+	defer func() {
+		runner.stopAll()
+		<-runDone
+	}()
+
+	ctlPath := st.CtlSocketPath("test-session")
+	waitForCtlSocket(t, ctlPath)
+
+	if info := queryInfo(t, ctlPath); info.Status != domain.StatusWaiting {
+		t.Errorf("status before first CONNECT = %q, want waiting", info.Status)
+	}
+	if health, ok := QueryCtlHealth(ctlPath); !ok || health.State != domain.HealthWaiting {
+		t.Errorf("HEALTH before first CONNECT = %+v, %v", health, ok)
+	}
+	if n := len(runner.started()); n != 0 {
+		t.Fatalf("started %d servers before any CONNECT, want 0", n)
+	}
+
+	// The first CONNECT picks the commit.
+	c1, line := connectCTAP1(t, ctlPath, "def456", "client-1")
+	defer c1.Close()
+	if line != "OK tok\n" {
+		t.Fatalf("first CONNECT = %q", line)
+	}
+	if n := len(runner.started()); n != 1 {
+		t.Errorf("started %d servers, want 1", n)
+	}
+	info := queryInfo(t, ctlPath)
+	if info.Status != domain.StatusRunning || info.Commit != "def456" {
+		t.Errorf("INFO after first CONNECT = status %q commit %q, want running def456", info.Status, info.Commit)
+	}
+}
+
+func TestIdleCheckInterval(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{time.Nanosecond, minIdleCheckInterval},
+		{3 * time.Nanosecond, minIdleCheckInterval},
+		{100 * time.Millisecond, 25 * time.Millisecond},
+		{2 * time.Minute, 30 * time.Second},
+		{time.Hour, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := idleCheckInterval(tt.timeout); got != tt.want {
+			t.Errorf("idleCheckInterval(%v) = %v, want %v", tt.timeout, got, tt.want)
+		}
+	}
+}
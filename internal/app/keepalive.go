@@ -0,0 +1,103 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keepalive interval limits accepted in CONNECT, and the default number of
+// intervals a client may stay silent before its lease expires.
+const (
+	minKeepalive    = 1    // seconds
+	maxKeepalive    = 3600 // seconds
+	keepaliveMisses = 3
+)
+
+// keepalive pings a lease holder at the negotiated interval. Every inbound
+// line pushes the connection's read deadline out by timeout, so a client that
+// stops answering (suspended laptop, frozen relay transport) surfaces as a
+// timeout error on its next read instead of holding the lease forever.
+type keepalive struct {
+	interval time.Duration
+	timeout  time.Duration
+	done     chan struct{}
+	once     sync.Once
+}
+
+// newKeepalive returns a keepalive for the given interval. A zero timeout
+// allows keepaliveMisses silent intervals; anything shorter than two
+// intervals is raised so a single late PONG does not expire the lease.
+func newKeepalive(interval, timeout time.Duration) *keepalive {
+	if timeout <= 0 {
+		timeout = keepaliveMisses * interval
+	}
+	if timeout < 2*interval {
+		timeout = 2 * interval
+	}
+	return &keepalive{interval: interval, timeout: timeout, done: make(chan struct{})}
+}
+
+// start calls ping every interval until stop is called or ping fails.
+func (k *keepalive) start(ping func() error) {
+	go func() {
+		ticker := time.NewTicker(k.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-k.done:
+				return
+			case <-ticker.C:
+				if err := ping(); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// extend gives the client another timeout to send its next line.
+func (k *keepalive) extend(conn net.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(k.timeout))
+}
+
+// stop ends the ping loop. It is safe to call more than once.
+func (k *keepalive) stop() {
+	k.once.Do(func() { close(k.done) })
+}
+
+// seconds returns the negotiated interval as sent on the wire.
+func (k *keepalive) seconds() int {
+	return int(k.interval / time.Second)
+}
+
+// parseKeepalive validates a requested keepalive interval in seconds.
+func parseKeepalive(secs int) (time.Duration, error) {
+	if secs < minKeepalive || secs > maxKeepalive {
+		return 0, fmt.Errorf("keepalive must be between %d and %d seconds", minKeepalive, maxKeepalive)
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// parseKeepaliveField parses the optional CTAP1 CONNECT field "keepalive=<secs>".
+func parseKeepaliveField(field string) (time.Duration, error) {
+	v, ok := strings.CutPrefix(field, "keepalive=")
+	if !ok {
+		return 0, fmt.Errorf("unknown CONNECT option %q", field)
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid keepalive %q", v)
+	}
+	return parseKeepalive(secs)
+}
+
+// isTimeout reports whether err is a read deadline expiry.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,174 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKeepaliveField(t *testing.T) {
+	if d, err := parseKeepaliveField("keepalive=15"); err != nil || d != 15*time.Second {
+		t.Errorf("parseKeepaliveField(keepalive=15) = %v, %v", d, err)
+	}
+	for _, bad := range []string{"keepalive=0", "keepalive=3601", "keepalive=x", "ttl=5"} {
+		if _, err := parseKeepaliveField(bad); err == nil {
+			t.Errorf("parseKeepaliveField(%q) should fail", bad)
+		}
+	}
+}
+
+func TestNewKeepalive_Timeout(t *testing.T) {
+	if k := newKeepalive(10*time.Second, 0); k.timeout != 30*time.Second {
+		t.Errorf("default timeout = %v, want 30s", k.timeout)
+	}
+	if k := newKeepalive(10*time.Second, time.Second); k.timeout != 20*time.Second {
+		t.Errorf("short timeout = %v, want raised to 20s", k.timeout)
+	}
+	if k := newKeepalive(10*time.Second, time.Minute); k.timeout != time.Minute {
+		t.Errorf("configured timeout = %v, want 1m", k.timeout)
+	}
+}
+
+func TestRun_KeepaliveExpiresSilentLease(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	runner := newBlockingRunner()
+
+	svc := newTestService(
+		&mockDownloader{downloadFn: func(_, _ string) (string, error) { return "", nil }},
+		&mockExtractor{extractFn: func(_, _ string) error { return nil }},
+		&mockProvisioner{provisioned: true, binPath: "/bin/cs"},
+		runner, st,
+		&mockTokenGen{token: "ka-token"},
+	)
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- svc.Run(testConfig(dir))
+	}()
+	defer func() {
+		runner.Stop()
+		<-runDone
+	}()
+
+	ctlPath := st.CtlSocketPath("test-session")
+	waitForCtlSocket(t, ctlPath)
+
+	watcher := dialCTAP2(t, ctlPath)
+	defer watcher.conn.Close()
+	_ = watcher.conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// Out-of-range intervals are refused.
+	bad, err := net.DialTimeout("unix", ctlPath, time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_, _ = fmt.Fprintf(bad, "CTAP1 CONNECT abc123 c0 keepalive=0\n")
+	line, _ := bufio.NewReader(bad).ReadString('\n')
+	bad.Close()
+	if !strings.HasPrefix(line, "ERR keepalive must be between") {
+		t.Errorf("invalid keepalive response = %q", line)
+	}
+
+	conn, err := net.DialTimeout("unix", ctlPath, time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	_, _ = fmt.Fprintf(conn, "CTAP1 CONNECT abc123 c1 keepalive=1\n")
+	reader := bufio.NewReader(conn)
+
+	line, _ = reader.ReadString('\n')
+	if line != "OK ka-token keepalive=1\n" {
+		t.Fatalf("CONNECT response = %q", line)
+	}
+
+	// Answering pings keeps the lease alive past the timeout.
+	for i := 0; i < 3; i++ {
+		line, err = reader.ReadString('\n')
+		if err != nil || line != "PING\n" {
+			t.Fatalf("expected PING, got %q (%v)", line, err)
+		}
+		_, _ = fmt.Fprintf(conn, "PONG\n")
+	}
+
+	// Going silent expires it: the server closes the connection and tells watchers.
+	start := time.Now()
+	for {
+		ev := watcher.read(t)
+		if ev["method"] != "lease.released" {
+			continue
+		}
+		params := ev["params"].(map[string]any)
+		if params["client_id"] != "c1" || params["reason"] != "expired" {
+			t.Errorf("lease.released params = %v", params)
+		}
+		break
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("lease expired after %v, want about 3s", elapsed)
+	}
+	for {
+		if _, err := reader.ReadString('\n'); err != nil {
+			break // drained pending PINGs, then EOF
+		}
+	}
+}
+
+func TestRun_KeepaliveCTAP2(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	runner := newBlockingRunner()
+
+	svc := newTestService(
+		&mockDownloader{downloadFn: func(_, _ string) (string, error) { return "", nil }},
+		&mockExtractor{extractFn: func(_, _ string) error { return nil }},
+		&mockProvisioner{provisioned: true, binPath: "/bin/cs"},
+		runner, st,
+		&mockTokenGen{token: "ka-token"},
+	)
+
+	cfg := testConfig(dir)
+	cfg.LeaseTimeout = time.Millisecond // raised to two intervals
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- svc.Run(cfg)
+	}()
+	defer func() {
+		runner.Stop()
+		<-runDone
+	}()
+
+	ctlPath := st.CtlSocketPath("test-session")
+	waitForCtlSocket(t, ctlPath)
+
+	c := dialCTAP2(t, ctlPath)
+	defer c.conn.Close()
+
+	resp := c.call(t, "connect", map[string]any{"commit": "abc123", "client_id": "c1", "keepalive": 1})
+	result, _ := resp["result"].(map[string]any)
+	if result == nil || result["keepalive"] != float64(1) {
+		t.Fatalf("connect response = %v", resp)
+	}
+
+	if ping := c.read(t); ping["method"] != "ping" {
+		t.Fatalf("expected ping notification, got %v", ping)
+	}
+	_, _ = fmt.Fprintf(c.conn, `{"jsonrpc":"2.0","method":"pong"}`+"\n")
+
+	// Silence after that closes the connection.
+	for {
+		if _, err := c.reader.ReadString('\n'); err != nil {
+			break
+		}
+	}
+
+	if _, alive := QueryCtlInfo(ctlPath); !alive {
+		t.Error("session should outlive an expired client")
+	}
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"codetap/internal/domain"
+)
+
+// labelKeyRe and labelValueRe restrict labels to characters that survive
+// shells, comma-separated flags and selector syntax unquoted.
+var (
+	labelKeyRe   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+	labelValueRe = regexp.MustCompile(`^[A-Za-z0-9._:/@+-]*$`)
+)
+
+// ParseLabels parses "key=value" pairs as given to --label. A later pair
+// overrides an earlier one with the same key.
+func ParseLabels(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("label %q: expected key=value", pair)
+		}
+		if !labelKeyRe.MatchString(key) {
+			return nil, fmt.Errorf("label %q: invalid key", pair)
+		}
+		if !labelValueRe.MatchString(value) {
+			return nil, fmt.Errorf("label %q: invalid value", pair)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// FormatLabels renders labels as sorted "key=value" pairs joined by commas.
+func FormatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Selector is a conjunction of label requirements, as given to --selector:
+//
+//	key=value, key==value   label present with that value
+//	key!=value              label absent or with another value
+//	key                     label present
+//	!key                    label absent
+//
+// An empty selector matches every session.
+type Selector []requirement
+
+type requirement struct {
+	key    string
+	value  string
+	negate bool // != or !key
+	exists bool // key or !key: only presence matters
+}
+
+// ParseSelector parses a comma-separated list of requirements.
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		var r requirement
+		switch {
+		case strings.Contains(term, "!="):
+			r.key, r.value, _ = strings.Cut(term, "!=")
+			r.negate = true
+		case strings.Contains(term, "=="):
+			r.key, r.value, _ = strings.Cut(term, "==")
+		case strings.Contains(term, "="):
+			r.key, r.value, _ = strings.Cut(term, "=")
+		case strings.HasPrefix(term, "!"):
+			r.key = strings.TrimPrefix(term, "!")
+			r.negate, r.exists = true, true
+		default:
+			r.key = term
+			r.exists = true
+		}
+		r.key = strings.TrimSpace(r.key)
+		r.value = strings.TrimSpace(r.value)
+		if !labelKeyRe.MatchString(r.key) {
+			return nil, fmt.Errorf("selector %q: invalid key", term)
+		}
+		if !labelValueRe.MatchString(r.value) {
+			return nil, fmt.Errorf("selector %q: invalid value", term)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// Matches reports whether labels satisfy every requirement.
+func (sel Selector) Matches(labels map[string]string) bool {
+	for _, r := range sel {
+		value, ok := labels[r.key]
+		var match bool
+		if r.exists {
+			match = ok
+		} else {
+			match = ok && value == r.value
+		}
+		if match == r.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter returns the entries whose labels match. Sessions that do not answer
+// INFO have no known labels.
+func (sel Selector) Filter(entries []domain.SocketEntry) []domain.SocketEntry {
+	if len(sel) == 0 {
+		return entries
+	}
+	var out []domain.SocketEntry
+	for _, e := range entries {
+		if sel.Matches(e.Metadata.Labels) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"testing"
+
+	"codetap/internal/domain"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels([]string{"project=foo", "env=ci", "env=dev", "owner="})
+	if err != nil {
+		t.Fatalf("ParseLabels() error: %v", err)
+	}
+	if labels["project"] != "foo" || labels["env"] != "dev" || len(labels) != 3 {
+		t.Errorf("ParseLabels() = %v", labels)
+	}
+	if got := FormatLabels(labels); got != "env=dev,owner=,project=foo" {
+		t.Errorf("FormatLabels() = %q", got)
+	}
+
+	for _, bad := range []string{"project", "=foo", "bad key=x", "k=v v", "k=a!b"} {
+		if _, err := ParseLabels([]string{bad}); err == nil {
+			t.Errorf("ParseLabels(%q) should fail", bad)
+		}
+	}
+}
+
+func TestSelector_Matches(t *testing.T) {
+	labels := map[string]string{"project": "foo", "env": "dev"}
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"", true},
+		{"project=foo", true},
+		{"project==foo", true},
+		{"project=bar", false},
+		{"project=foo,env!=ci", true},
+		{"project=foo,env!=dev", false},
+		{"owner!=alice", true},
+		{"env", true},
+		{"owner", false},
+		{"!owner", true},
+		{"!env", false},
+	}
+	for _, tt := range tests {
+		sel, err := ParseSelector(tt.selector)
+		if err != nil {
+			t.Fatalf("ParseSelector(%q) error: %v", tt.selector, err)
+		}
+		if got := sel.Matches(labels); got != tt.want {
+			t.Errorf("%q.Matches(%v) = %v, want %v", tt.selector, labels, got, tt.want)
+		}
+	}
+
+	if _, err := ParseSelector("project=foo,=bar"); err == nil {
+		t.Error("ParseSelector() should reject an empty key")
+	}
+}
+
+func TestSelector_Filter(t *testing.T) {
+	entries := []domain.SocketEntry{
+		{Name: "a", Alive: true, Metadata: domain.Metadata{Labels: map[string]string{"project": "foo"}}},
+		{Name: "b", Alive: true, Metadata: domain.Metadata{Labels: map[string]string{"project": "bar"}}},
+		{Name: "c"}, // stale: labels unknown
+	}
+
+	sel, _ := ParseSelector("project=foo")
+	if got := sel.Filter(entries); len(got) != 1 || got[0].Name != "a" {
+		t.Errorf("Filter(project=foo) = %v", got)
+	}
+	sel, _ = ParseSelector("project!=foo")
+	if got := sel.Filter(entries); len(got) != 2 || got[0].Name != "b" || got[1].Name != "c" {
+		t.Errorf("Filter(project!=foo) = %v", got)
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
+
+	"codetap/internal/domain"
 )
 
 // mockDownloader records calls and returns configured values.
@@ -56,20 +60,59 @@ type mockRunner struct {
 	lastToken  string
 }
 
-func (m *mockRunner) Start(bin, sock, token string) (func() error, func(), error) {
+func (m *mockRunner) Start(bin, sock, token string) (int, func() error, func(), error) {
 	m.called = true
 	m.lastBin = bin
 	m.lastSocket = sock
 	m.lastToken = token
 	err := m.startFn(bin, sock, token)
 	if err != nil {
-		return nil, nil, err
+		return 0, nil, nil, err
 	}
 	// Create the socket file so waitForSocket succeeds in tests.
 	_ = os.WriteFile(sock, nil, 0600)
 	wait := func() error { return nil }
 	stop := func() {}
-	return wait, stop, nil
+	return syscall.Getpgrp(), wait, stop, nil
+}
+
+// mockCache is an in-memory ServerCache.
+type mockCache struct {
+	entries  []domain.CacheEntry
+	removed  []string
+	used     []string
+	inUse    map[string]bool // Remove fails with ErrServerInUse
+	mu       sync.Mutex
+	held     []string // Use calls
+	released []string // released Use locks
+}
+
+func (m *mockCache) Entries() ([]domain.CacheEntry, error) {
+	return append([]domain.CacheEntry(nil), m.entries...), nil
+}
+
+func (m *mockCache) Remove(commit string) error {
+	if m.inUse[commit] {
+		return domain.ErrServerInUse
+	}
+	m.removed = append(m.removed, commit)
+	return nil
+}
+
+func (m *mockCache) MarkUsed(commit string) error {
+	m.used = append(m.used, commit)
+	return nil
+}
+
+func (m *mockCache) Use(commit string) (func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.held = append(m.held, commit)
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.released = append(m.released, commit)
+	}, nil
 }
 
 // mockStore is an in-memory MetadataStore.
@@ -88,6 +131,10 @@ func (m *mockStore) SocketPath(name string) string {
 	return filepath.Join(m.socketDir, name+".sock")
 }
 
+func (m *mockStore) VersionSocketPath(name, commit string) string {
+	return filepath.Join(m.socketDir, name+"."+commit+".sock")
+}
+
 func (m *mockStore) CtlSocketPath(name string) string {
 	return filepath.Join(m.socketDir, name+".ctl.sock")
 }
@@ -122,6 +169,21 @@ func (m *mockStore) EnsureDir() error {
 	return os.MkdirAll(m.socketDir, 0755)
 }
 
+func (m *mockStore) PrepareSocket(path string) error {
+	return nil
+}
+
+func (m *mockStore) SessionForSocket(path string) (string, bool) {
+	if filepath.Dir(path) != filepath.Clean(m.socketDir) {
+		return "", false
+	}
+	name, ok := strings.CutSuffix(filepath.Base(path), ".sock")
+	if !ok || strings.HasSuffix(name, ".ctl") {
+		return "", false
+	}
+	return name, true
+}
+
 // mockTokenGen returns a fixed token.
 type mockTokenGen struct {
 	token string
@@ -132,10 +194,21 @@ func (m *mockTokenGen) Generate() (string, error) {
 	return m.token, m.err
 }
 
-// mockLogger is a no-op logger.
+// mockLogger records messages. It is safe for concurrent use because control
+// connections log from their own goroutines.
 type mockLogger struct {
+	mu       sync.Mutex
 	messages []string
 }
 
-func (m *mockLogger) Info(msg string, args ...any)  { m.messages = append(m.messages, msg) }
-func (m *mockLogger) Error(msg string, args ...any) { m.messages = append(m.messages, "ERROR: "+msg) }
+func (m *mockLogger) Info(msg string, args ...any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+}
+
+func (m *mockLogger) Error(msg string, args ...any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, "ERROR: "+msg)
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"syscall"
+	"time"
+
+	"codetap/internal/adapter/procscan"
+)
+
+// orphanKillTimeout is how long killOrphan waits after SIGTERM before it
+// sends SIGKILL to the process group.
+var orphanKillTimeout = 5 * time.Second
+
+// orphan is a code-server process group serving a session socket that no
+// codetap process controls any more.
+type orphan struct {
+	session string
+	pgid    int
+	pids    []int
+	command string
+	socket  string
+}
+
+// findOrphans returns the code-server process groups whose --socket-path lies
+// in a scanned session directory but whose session is not in live, and
+// whose codetap is gone. One group, such as the code-server shell script and
+// its node child, is reported once.
+//
+// A session that does not answer on its control socket is not enough: Run
+// starts code-server before it listens there, so a session still starting
+// looks the same. A group whose processes still have a codetap parent is
+// therefore kept.
+func (s *Service) findOrphans(live map[string]bool) ([]orphan, error) {
+	procs, err := procscan.Find()
+	if err != nil {
+		return nil, err
+	}
+	groups := map[int]*orphan{}
+	owned := map[int]bool{}
+	for _, p := range procs {
+		name, ok := s.store.SessionForSocket(p.SocketPath)
+		if !ok || live[name] {
+			continue
+		}
+		o, ok := groups[p.PGID]
+		if !ok {
+			o = &orphan{session: name, pgid: p.PGID, command: p.Command, socket: p.SocketPath}
+			groups[p.PGID] = o
+		}
+		o.pids = append(o.pids, p.PID)
+		if procscan.IsCodetap(p.PPID) {
+			owned[p.PGID] = true
+		}
+	}
+
+	orphans := make([]orphan, 0, len(groups))
+	for pgid, o := range groups {
+		if owned[pgid] {
+			s.logger.Info("code-server of a session that is not answering yet, still owned by codetap", "session", o.session, "pgid", pgid)
+			continue
+		}
+		sort.Ints(o.pids)
+		orphans = append(orphans, *o)
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].pgid < orphans[j].pgid })
+	return orphans, nil
+}
+
+// killOrphan terminates an orphaned process group: SIGTERM first, SIGKILL if
+// any of its code-server processes still runs after orphanKillTimeout. It refuses groups that
+// could not belong to a code-server, such as init's or our own.
+func killOrphan(o orphan) error {
+	if o.pgid <= 1 || o.pgid == syscall.Getpgrp() {
+		return fmt.Errorf("refusing to signal process group %d", o.pgid)
+	}
+	if err := syscall.Kill(-o.pgid, syscall.SIGTERM); err != nil {
+		if errors.Is(err, syscall.ESRCH) {
+			return nil
+		}
+		return fmt.Errorf("signal process group %d: %w", o.pgid, err)
+	}
+	deadline := time.Now().Add(orphanKillTimeout)
+	for time.Now().Before(deadline) {
+		if !anyRunning(o.pids) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := syscall.Kill(-o.pgid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("kill process group %d: %w", o.pgid, err)
+	}
+	return nil
+}
+
+// anyRunning reports whether any of pids is still running.
+func anyRunning(pids []int) bool {
+	for _, pid := range pids {
+		if procscan.Running(pid) {
+			return true
+		}
+	}
+	return false
+}
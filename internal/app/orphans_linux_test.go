@@ -0,0 +1,111 @@
+package app
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"codetap/internal/adapter/procscan"
+)
+
+// startFakeServer starts a process group whose command line carries
+// --socket-path like code-server's, and returns a channel closed on exit.
+func startFakeServer(t *testing.T, socketPath string) (*exec.Cmd, <-chan struct{}) {
+	t.Helper()
+	cmd := exec.Command("/bin/sh", "-c", "sleep 30; :", "code-server", "--socket-path="+socketPath)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start sh: %v", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+	t.Cleanup(func() {
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-exited
+	})
+	return cmd, exited
+}
+
+// startOrphanServer starts a fake code-server whose parent exits at once, as
+// if its codetap had crashed, and returns its process group and a channel
+// closed on exit.
+func startOrphanServer(t *testing.T, socketPath string) (int, <-chan struct{}) {
+	t.Helper()
+	cmd := exec.Command("/bin/sh", "-c", `sh -c "sleep 30; :" code-server --socket-path="$1" >/dev/null 2>&1 & echo $!`, "sh", socketPath)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Skipf("cannot start sh: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		t.Fatalf("read orphan pid: %v", err)
+	}
+	pgid := cmd.Process.Pid
+	exited := make(chan struct{})
+	go func() {
+		for procscan.Running(pid) {
+			time.Sleep(20 * time.Millisecond)
+		}
+		close(exited)
+	}()
+	t.Cleanup(func() {
+		_ = syscall.Kill(-pgid, syscall.SIGKILL)
+		<-exited
+	})
+	return pgid, exited
+}
+
+func TestClean_ReportsAndKillsOrphanedServers(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	serveCtl(t, filepath.Join(dir, "live.ctl.sock"), `{"name":"live"}`)
+
+	_, liveExited := startFakeServer(t, st.SocketPath("live"))
+	// A session that has started code-server but does not answer on its
+	// control socket yet is not an orphan: its codetap, here the test, runs.
+	_, startingExited := startFakeServer(t, st.SocketPath("starting"))
+	orphan, orphanExited := startOrphanServer(t, st.SocketPath("ghost"))
+
+	logger := &mockLogger{}
+	svc := NewService(nil, nil, nil, nil, nil, st, &mockTokenGen{}, logger)
+
+	// Without Kill the orphan is only reported.
+	if err := svc.Clean(CleanOptions{}); err != nil {
+		t.Fatalf("Clean() error: %v", err)
+	}
+	select {
+	case <-orphanExited:
+		t.Fatal("orphan was killed without Kill")
+	case <-time.After(100 * time.Millisecond):
+	}
+	logger.mu.Lock()
+	reported := strings.Join(logger.messages, "\n")
+	logger.mu.Unlock()
+	if strings.Count(reported, "orphaned code-server") != 1 {
+		t.Errorf("want exactly one orphan reported, got log:\n%s", reported)
+	}
+
+	if err := svc.Clean(CleanOptions{Kill: true}); err != nil {
+		t.Fatalf("Clean(Kill) error: %v", err)
+	}
+	select {
+	case <-orphanExited:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("orphan process group %d still running after Clean(Kill)", orphan)
+	}
+	select {
+	case <-liveExited:
+		t.Error("server of a live session was killed")
+	case <-startingExited:
+		t.Error("server of a session that is still starting was killed")
+	default:
+	}
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"codetap/internal/domain"
+)
+
+// probeWorkers bounds how many control sockets List and Clean query at once.
+var probeWorkers = 8
+
+// probeTimeout bounds a whole List or Clean probe round, so a directory full
+// of hung sessions cannot stall it for long. Sessions not answered by then
+// are reported as timed out.
+var probeTimeout = 5 * time.Second
+
+// dialCtl connects to a control socket, spending at most a second on the
+// dial, and bounds all further I/O on the connection by ioTimeout or
+// deadline, whichever comes first.
+func dialCtl(ctlPath string, ioTimeout time.Duration, deadline time.Time) (net.Conn, error) {
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return nil, os.ErrDeadlineExceeded
+	}
+	conn, err := net.DialTimeout("unix", ctlPath, min(time.Second, remaining))
+	if err != nil {
+		return nil, err
+	}
+	ioDeadline := time.Now().Add(ioTimeout)
+	if deadline.Before(ioDeadline) {
+		ioDeadline = deadline
+	}
+	_ = conn.SetDeadline(ioDeadline)
+	return conn, nil
+}
+
+// probeState classifies the outcome of an INFO query. Nothing listening on
+// the socket means the session is definitely dead; a full accept backlog or
+// a missing answer means it may only be hung.
+func probeState(err error) string {
+	switch {
+	case err == nil:
+		return domain.ProbeOK
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ENOENT):
+		return domain.ProbeRefused
+	case errors.Is(err, syscall.EAGAIN), errors.Is(err, os.ErrDeadlineExceeded), isTimeout(err):
+		return domain.ProbeTimeout
+	default:
+		return domain.ProbeError
+	}
+}
+
+// probeSessions queries the named sessions concurrently with at most
+// probeWorkers connections in flight and returns their entries in the order
+// of names.
+func (s *Service) probeSessions(names []string) []domain.SocketEntry {
+	entries := make([]domain.SocketEntry, len(names))
+	deadline := time.Now().Add(probeTimeout)
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(probeWorkers, len(names)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				entries[i] = s.probeSession(names[i], deadline)
+			}
+		}()
+	}
+	for i := range names {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return entries
+}
+
+// probeSession queries INFO and, for a live session, HEALTH.
+func (s *Service) probeSession(name string, deadline time.Time) domain.SocketEntry {
+	ctlPath := s.store.CtlSocketPath(name)
+	entry := domain.SocketEntry{Name: name, Path: s.store.SocketPath(name)}
+
+	meta, err := queryCtlInfo(ctlPath, deadline)
+	entry.Probe = probeState(err)
+	if err != nil {
+		entry.Metadata.Name = name
+		entry.ProbeError = err.Error()
+		return entry
+	}
+	entry.Metadata = meta
+	entry.Alive = true
+	entry.Health, _ = queryCtlHealth(ctlPath, deadline)
+	return entry
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"codetap/internal/domain"
+)
+
+// serveCtl answers every control connection on path with reply, or never
+// answers if reply is empty.
+func serveCtl(t *testing.T, path, reply string) {
+	t.Helper()
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				_, _ = bufio.NewReader(c).ReadString('\n')
+				if reply == "" {
+					_, _ = c.Read(make([]byte, 1)) // hang until the client gives up
+					return
+				}
+				_, _ = c.Write([]byte(reply + "\n"))
+			}(conn)
+		}
+	}()
+}
+
+func TestList_ProbesConcurrentlyAndClassifies(t *testing.T) {
+	defer func(d time.Duration) { probeTimeout = d }(probeTimeout)
+	probeTimeout = 300 * time.Millisecond
+
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+
+	var want []string
+	// Fewer hung sessions than workers, so the others still get a turn.
+	for i := 0; i < probeWorkers-2; i++ {
+		name := fmt.Sprintf("hung-%02d", i)
+		serveCtl(t, filepath.Join(dir, name+".ctl.sock"), "")
+		want = append(want, name)
+	}
+	serveCtl(t, filepath.Join(dir, "live.ctl.sock"), `{"name":"live"}`)
+	serveCtl(t, filepath.Join(dir, "denied.ctl.sock"), "ERR permission denied")
+	_ = os.WriteFile(filepath.Join(dir, "dead.ctl.sock"), nil, 0644)
+
+	svc := newTestService(nil, nil, nil, nil, st, &mockTokenGen{})
+
+	start := time.Now()
+	entries, err := svc.List()
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("List() took %v with hung sessions, want it bounded by the probe deadline", elapsed)
+	}
+
+	names, _ := st.ListSessionNames()
+	if len(entries) != len(names) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(names))
+	}
+	probes := map[string]string{}
+	for i, e := range entries {
+		if e.Name != names[i] {
+			t.Errorf("entry %d = %q, want %q (order must follow the store)", i, e.Name, names[i])
+		}
+		probes[e.Name] = e.Probe
+	}
+
+	for _, name := range want {
+		if probes[name] != domain.ProbeTimeout {
+			t.Errorf("%s probe = %q, want timeout", name, probes[name])
+		}
+	}
+	if probes["live"] != domain.ProbeOK {
+		t.Errorf("live probe = %q, want ok", probes["live"])
+	}
+	if probes["dead"] != domain.ProbeRefused {
+		t.Errorf("dead probe = %q, want refused", probes["dead"])
+	}
+	if probes["denied"] != domain.ProbeError {
+		t.Errorf("denied probe = %q, want error", probes["denied"])
+	}
+}
+
+func TestClean_KeepsHungUnlessForced(t *testing.T) {
+	defer func(d time.Duration) { probeTimeout = d }(probeTimeout)
+	probeTimeout = 200 * time.Millisecond
+
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	serveCtl(t, filepath.Join(dir, "hung.ctl.sock"), "")
+	_ = os.WriteFile(filepath.Join(dir, "dead.ctl.sock"), nil, 0644)
+
+	svc := newTestService(nil, nil, nil, nil, st, &mockTokenGen{})
+
+	if err := svc.Clean(CleanOptions{}); err != nil {
+		t.Fatalf("Clean() error: %v", err)
+	}
+	if len(st.removed) != 1 || st.removed[0] != "dead" {
+		t.Fatalf("Clean() removed %v, want only dead", st.removed)
+	}
+
+	if err := svc.Clean(CleanOptions{Force: true}); err != nil {
+		t.Fatalf("Clean(force) error: %v", err)
+	}
+	found := false
+	for _, name := range st.removed {
+		if name == "hung" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Clean(force) removed %v, want hung too", st.removed)
+	}
+}
+
+func TestQueryCtlInfo_UnknownUID(t *testing.T) {
+	dir := setupTestDir(t)
+	old := filepath.Join(dir, "old.ctl.sock")
+	serveCtl(t, old, `{"name":"old","pid":1}`)
+	root := filepath.Join(dir, "root.ctl.sock")
+	serveCtl(t, root, `{"name":"root","pid":1,"uid":0}`)
+
+	if meta, alive := QueryCtlInfo(old); !alive || meta.UID != nil {
+		t.Errorf("INFO without uid = %v (alive %v), want it unknown rather than root", meta.UID, alive)
+	}
+	if meta, alive := QueryCtlInfo(root); !alive || meta.UID == nil || *meta.UID != 0 {
+		t.Errorf("INFO with uid 0 = %v (alive %v), want root", meta.UID, alive)
+	}
+}
@@ -3,6 +3,7 @@ package app
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -11,6 +12,8 @@ import (
 	"sync"
 	"time"
 
+	"codetap/internal/adapter/ctap2"
+	"codetap/internal/adapter/peercred"
 	"codetap/internal/adapter/relay"
 	"codetap/internal/domain"
 )
@@ -22,6 +25,34 @@ type Config struct {
 	Arch      string
 	Folder    string
 	SocketDir string
+	Access    domain.AccessPolicy // peers allowed on the control socket; zero means the owner only
+	Version   string              // codetap build, reported by INFO
+	Host      domain.HostInfo     // machine, user and container, reported by INFO
+	Labels    map[string]string   // set with --label, reported by INFO
+
+	// LeaseTimeout is how long a client that negotiated a keepalive may stay
+	// silent before its lease expires. Zero means three keepalive intervals.
+	LeaseTimeout time.Duration
+
+	// NoTakeover refuses CONNECT force, so a shared session is never switched
+	// away from clients that still hold leases.
+	NoTakeover bool
+	// TakeoverGrace is how long evicted leaseholders are warned before their
+	// connections are closed. Zero means defaultTakeoverGrace.
+	TakeoverGrace time.Duration
+
+	// IdleTimeout stops code-server once no lease has been held for this
+	// long; the next CONNECT starts it again. Zero disables idle shutdown.
+	IdleTimeout time.Duration
+
+	// Lazy opens the control socket without starting code-server; the first
+	// CONNECT provisions and starts whatever commit it asks for.
+	Lazy bool
+
+	// MultiVersion serves a CONNECT for another commit from an additional
+	// code-server on <name>.<commit12>.sock instead of restarting the
+	// primary one, so clients on different releases can share the session.
+	MultiVersion bool
 }
 
 // Service orchestrates the codetap lifecycle.
@@ -29,6 +60,7 @@ type Service struct {
 	downloader domain.Downloader
 	extractor  domain.Extractor
 	provision  domain.Provisioner
+	cache      domain.ServerCache
 	runner     domain.ServerRunner
 	store      domain.MetadataStore
 	tokenGen   domain.TokenGenerator
@@ -40,6 +72,7 @@ func NewService(
 	dl domain.Downloader,
 	ex domain.Extractor,
 	pr domain.Provisioner,
+	sc domain.ServerCache,
 	sr domain.ServerRunner,
 	st domain.MetadataStore,
 	tg domain.TokenGenerator,
@@ -49,6 +82,7 @@ func NewService(
 		downloader: dl,
 		extractor:  ex,
 		provision:  pr,
+		cache:      sc,
 		runner:     sr,
 		store:      st,
 		tokenGen:   tg,
@@ -66,10 +100,29 @@ type sessionState struct {
 	token             string
 	pid               int
 	startedAt         time.Time
-	leases            map[string]net.Conn // client_id → control conn
-	waitFn            func() error        // set by doRestart for lifecycle goroutine
-	stopFn            func()              // set by doRestart for lifecycle goroutine
-	restartInProgress bool                // true while a version switch is in flight
+	socketPath        string                    // code-server data socket
+	serverPID         int                       // code-server process group ID
+	readyAt           time.Time                 // first successful health probe since (re)start
+	leases            map[string]*lease         // client_id → lease
+	watchers          map[*ctap2.Conn]net.Conn  // CTAP2 connections receiving notifications
+	access            domain.AccessPolicy       // peers allowed on the control socket
+	leaseTimeout      time.Duration             // keepalive silence before a lease expires
+	noTakeover        bool                      // refuse CONNECT force
+	takeoverGrace     time.Duration             // warning period before evicting leaseholders
+	multiVersion      bool                      // run other commits side by side
+	version           string                    // codetap build
+	host              domain.HostInfo           // machine, user and container
+	labels            map[string]string         // set with --label
+	versions          map[string]*versionServer // commit → side-by-side code-server
+	waitFn            func() error              // set by doRestart for lifecycle goroutine
+	stopFn            func()                    // set by doRestart for lifecycle goroutine
+	restartInProgress bool                      // true while a version switch is in flight
+	idle              bool                      // code-server stopped after the idle timeout
+	waiting           bool                      // lazy session that has not started code-server yet
+	idleSince         time.Time                 // when the last lease was released
+	primaryHeld       string                    // commit the primary server holds with holdServer; lifecycle goroutine only
+	heldMu            sync.Mutex                // guards held; not mu, since taking a use lock may wait
+	held              map[string]*heldServer    // commit → use lock on its server
 }
 
 // restartReq signals the lifecycle goroutine to restart code-server.
@@ -78,9 +131,9 @@ type restartReq struct {
 	result chan error
 }
 
-// Run starts a codetap session with the CTAP1 control socket protocol.
+// Run starts a codetap session with the CTAP1/CTAP2 control socket protocols.
 // It provisions the server, starts code-server on <name>.sock, listens on
-// <name>.ctl.sock for INFO and CONNECT commands, and blocks until the
+// <name>.ctl.sock for INFO, HEALTH and CONNECT commands, and blocks until the
 // server process exits.
 func (s *Service) Run(cfg Config) error {
 	s.logger.Info("starting session", "name", cfg.Name, "commit", cfg.Commit, "arch", cfg.Arch)
@@ -91,11 +144,6 @@ func (s *Service) Run(cfg Config) error {
 		return fmt.Errorf("ensure socket dir: %w", err)
 	}
 
-	binPath, err := s.Provision(cfg.Commit, cfg.Arch)
-	if err != nil {
-		return err
-	}
-
 	socketPath := s.store.SocketPath(cfg.Name)
 	ctlSocketPath := s.store.CtlSocketPath(cfg.Name)
 
@@ -119,28 +167,68 @@ func (s *Service) Run(cfg Config) error {
 	}
 
 	state := &sessionState{
-		name:      cfg.Name,
-		commit:    cfg.Commit,
-		arch:      cfg.Arch,
-		folder:    cfg.Folder,
-		token:     token,
-		pid:       os.Getpid(),
-		startedAt: time.Now(),
-		leases:    make(map[string]net.Conn),
-	}
-
-	// Start code-server
-	wait, stop, err := s.runner.Start(binPath, socketPath, token)
-	if err != nil {
-		return err
+		name:          cfg.Name,
+		commit:        cfg.Commit,
+		arch:          cfg.Arch,
+		folder:        cfg.Folder,
+		token:         token,
+		pid:           os.Getpid(),
+		startedAt:     time.Now(),
+		socketPath:    socketPath,
+		leases:        make(map[string]*lease),
+		watchers:      make(map[*ctap2.Conn]net.Conn),
+		access:        cfg.Access,
+		leaseTimeout:  cfg.LeaseTimeout,
+		noTakeover:    cfg.NoTakeover,
+		takeoverGrace: cfg.TakeoverGrace,
+		multiVersion:  cfg.MultiVersion,
+		version:       cfg.Version,
+		host:          cfg.Host,
+		labels:        cfg.Labels,
+		waiting:       cfg.Lazy,
+		idleSince:     time.Now(),
+		versions:      make(map[string]*versionServer),
+		held:          make(map[string]*heldServer),
+	}
+	defer s.dropAllServers(state)
+	if state.takeoverGrace <= 0 {
+		state.takeoverGrace = defaultTakeoverGrace
+	}
+	if state.access.IsZero() {
+		state.access = domain.AccessPolicy{UIDs: []int{os.Getuid()}}
 	}
 
-	// Wait for code-server to create the data socket before accepting clients.
-	if err := waitForSocket(socketPath); err != nil {
-		stop()
-		return fmt.Errorf("server failed to start: %w", err)
+	// Start code-server, unless a lazy session leaves that to the first CONNECT.
+	var wait func() error
+	stop := func() {}
+	if cfg.Lazy {
+		s.logger.Info("waiting for first CONNECT to start code-server")
+	} else {
+		if err := s.holdServer(state, cfg.Commit); err != nil {
+			return err
+		}
+		state.primaryHeld = cfg.Commit
+		binPath, err := s.Provision(cfg.Commit, cfg.Arch)
+		if err != nil {
+			return err
+		}
+		pgid, startWait, startStop, err := s.runner.Start(binPath, socketPath, token)
+		if err != nil {
+			return err
+		}
+		wait, stop = startWait, startStop
+		state.serverPID = pgid
+
+		// Wait for code-server to create the data socket before accepting clients.
+		if err := waitForSocket(socketPath); err != nil {
+			stop()
+			return fmt.Errorf("server failed to start: %w", err)
+		}
+		if err := s.store.PrepareSocket(socketPath); err != nil {
+			s.logger.Error("prepare data socket failed", "socket", socketPath, "err", err)
+		}
+		s.logger.Info("code-server ready", "socket", socketPath)
 	}
-	s.logger.Info("code-server ready", "socket", socketPath)
 
 	// Listen on control socket
 	ctlListener, err := net.Listen("unix", ctlSocketPath)
@@ -148,11 +236,17 @@ func (s *Service) Run(cfg Config) error {
 		stop()
 		return fmt.Errorf("listen ctl socket: %w", err)
 	}
+	if err := s.store.PrepareSocket(ctlSocketPath); err != nil {
+		_ = ctlListener.Close()
+		stop()
+		return fmt.Errorf("prepare ctl socket: %w", err)
+	}
 
 	// Cleanup on exit
 	defer func() {
 		s.logger.Info("cleaning up session", "name", cfg.Name)
 		_ = ctlListener.Close()
+		s.stopVersions(state)
 		if err := s.store.Remove(cfg.Name); err != nil {
 			s.logger.Error("cleanup failed", "name", cfg.Name, "err", err)
 		}
@@ -161,48 +255,98 @@ func (s *Service) Run(cfg Config) error {
 	restartCh := make(chan restartReq)
 	done := make(chan error, 1)
 
-	// Lifecycle goroutine: watches code-server, handles restart requests.
+	// Lifecycle goroutine: watches code-server, handles restart requests and
+	// stops an idle server until the next CONNECT wakes it.
 	go func() {
-		for {
-			serverDone := make(chan error, 1)
-			go func() {
-				serverDone <- wait()
-			}()
+		var serverDone chan error
+
+		var idleC <-chan time.Time
+		if cfg.IdleTimeout > 0 {
+			ticker := time.NewTicker(idleCheckInterval(cfg.IdleTimeout))
+			defer ticker.Stop()
+			idleC = ticker.C
+		}
+
+		// start runs code-server for req and answers the requesting CONNECT.
+		start := func(req restartReq) error {
+			err := s.doRestart(req, state, socketPath)
+			if err == nil {
+				state.mu.Lock()
+				wait = state.waitFn
+				stop = state.stopFn
+				state.mu.Unlock()
+				serverDone = watchServer(wait)
+			}
+			req.result <- err
+			return err
+		}
 
+		// sleep blocks with no code-server running until a CONNECT starts
+		// one. A failed start leaves the session stopped for the next CONNECT.
+		// It returns false once the session has been told to end.
+		sleep := func() bool {
+			for {
+				req, ok := waitForWake(restartCh)
+				if !ok {
+					done <- nil
+					return false
+				}
+				if start(req) == nil {
+					return true
+				}
+			}
+		}
+
+		if cfg.Lazy {
+			if !sleep() {
+				return
+			}
+		} else {
+			serverDone = watchServer(wait)
+		}
+
+		for {
 			select {
 			case sErr := <-serverDone:
 				// Server exited — check for pending restart.
 				select {
 				case req := <-restartCh:
-					if restartErr := s.doRestart(req, state, socketPath); restartErr != nil {
-						req.result <- restartErr
+					if restartErr := start(req); restartErr != nil {
 						done <- fmt.Errorf("restart failed: %w", restartErr)
 						return
 					}
-					state.mu.Lock()
-					wait = state.waitFn
-					stop = state.stopFn
-					state.mu.Unlock()
-					req.result <- nil
-					// loop: wait on new server
 				default:
 					done <- sErr
 					return
 				}
 			case req := <-restartCh:
-				// Restart while server still running.
+				// Restart while server still running. Provision first, so
+				// that a version which cannot be fetched leaves the running
+				// server, and the leases on it, alone.
+				state.mu.Lock()
+				arch := state.arch
+				state.mu.Unlock()
+				if _, err := s.Provision(req.commit, arch); err != nil {
+					req.result <- fmt.Errorf("provision: %w", err)
+					continue
+				}
 				stop()
 				<-serverDone
-				if restartErr := s.doRestart(req, state, socketPath); restartErr != nil {
-					req.result <- restartErr
+				if restartErr := start(req); restartErr != nil {
 					done <- fmt.Errorf("restart failed: %w", restartErr)
 					return
 				}
-				state.mu.Lock()
-				wait = state.waitFn
-				stop = state.stopFn
-				state.mu.Unlock()
-				req.result <- nil
+			case <-idleC:
+				if !state.enterIdle(cfg.IdleTimeout) {
+					continue
+				}
+				s.logger.Info("stopping idle code-server", "idle_timeout", cfg.IdleTimeout)
+				stop()
+				<-serverDone
+				s.stopVersions(state)
+				if !sleep() {
+					return
+				}
 			}
 		}
 	}()
@@ -226,11 +370,26 @@ func (s *Service) Run(cfg Config) error {
 // We embed them as unexported fields set by doRestart.
 
 // doRestart provisions and starts a new code-server, updating state.
-func (s *Service) doRestart(req restartReq, state *sessionState, socketPath string) error {
+func (s *Service) doRestart(req restartReq, state *sessionState, socketPath string) (err error) {
 	state.mu.Lock()
 	arch := state.arch
 	state.mu.Unlock()
 
+	if err := s.holdServer(state, req.commit); err != nil {
+		return err
+	}
+	defer func() {
+		old := state.primaryHeld
+		if err == nil {
+			state.primaryHeld = req.commit
+		} else {
+			old = req.commit
+		}
+		if old != "" {
+			s.dropServer(state, old)
+		}
+	}()
+
 	newBin, err := s.Provision(req.commit, arch)
 	if err != nil {
 		return fmt.Errorf("provision: %w", err)
@@ -243,7 +402,7 @@ func (s *Service) doRestart(req restartReq, state *sessionState, socketPath stri
 
 	_ = os.Remove(socketPath)
 
-	newWait, newStop, err := s.runner.Start(newBin, socketPath, newToken)
+	newPID, newWait, newStop, err := s.runner.Start(newBin, socketPath, newToken)
 	if err != nil {
 		return fmt.Errorf("start: %w", err)
 	}
@@ -252,11 +411,19 @@ func (s *Service) doRestart(req restartReq, state *sessionState, socketPath stri
 		newStop()
 		return fmt.Errorf("server failed to start after restart: %w", err)
 	}
+	if err := s.store.PrepareSocket(socketPath); err != nil {
+		s.logger.Error("prepare data socket failed", "socket", socketPath, "err", err)
+	}
 
 	state.mu.Lock()
 	state.commit = req.commit
 	state.token = newToken
 	state.startedAt = time.Now()
+	state.serverPID = newPID
+	state.readyAt = time.Time{}
+	state.idle = false
+	state.waiting = false
+	state.idleSince = time.Now()
 	state.waitFn = newWait
 	state.stopFn = newStop
 	state.mu.Unlock()
@@ -265,8 +432,17 @@ func (s *Service) doRestart(req restartReq, state *sessionState, socketPath stri
 	return nil
 }
 
-// handleCtlConn dispatches a single control socket connection.
+// handleCtlConn dispatches a single control socket connection. The first
+// line selects either a one-shot CTAP1 verb or a CTAP2 JSON-RPC session.
 func (s *Service) handleCtlConn(conn net.Conn, state *sessionState, restartCh chan restartReq) {
+	peer, authErr := peercred.Authorize(conn, state.access)
+	if authErr != nil {
+		s.logger.Error("control connection refused", "pid", peer.PID, "uid", peer.UID, "gid", peer.GID, "err", authErr)
+		_, _ = fmt.Fprintf(conn, "ERR permission denied\n")
+		_ = conn.Close()
+		return
+	}
+
 	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
 
 	reader := bufio.NewReader(conn)
@@ -280,8 +456,12 @@ func (s *Service) handleCtlConn(conn net.Conn, state *sessionState, restartCh ch
 	switch {
 	case line == "CTAP1 INFO":
 		s.handleInfo(conn, state)
+	case line == "CTAP1 HEALTH":
+		s.handleHealth(conn, state)
 	case strings.HasPrefix(line, "CTAP1 CONNECT "):
-		s.handleConnect(conn, state, line, restartCh)
+		s.handleConnect(conn, peer, reader, state, line, restartCh)
+	case line == ctap2.Hello:
+		s.serveCTAP2(conn, peer, reader, state, restartCh)
 	default:
 		_, _ = fmt.Fprintf(conn, "ERR unknown command\n")
 		_ = conn.Close()
@@ -292,36 +472,57 @@ func (s *Service) handleCtlConn(conn net.Conn, state *sessionState, restartCh ch
 func (s *Service) handleInfo(conn net.Conn, state *sessionState) {
 	defer conn.Close()
 
+	data, _ := json.Marshal(s.info(state))
+	data = append(data, '\n')
+	_, _ = conn.Write(data)
+}
+
+// info snapshots the session metadata served by INFO.
+func (s *Service) info(state *sessionState) InfoResponse {
 	state.mu.Lock()
-	info := infoResponse{
+	defer state.mu.Unlock()
+	return InfoResponse{
 		Name:      state.name,
 		Commit:    state.commit,
 		Arch:      state.arch,
 		Folder:    state.folder,
 		PID:       state.pid,
 		StartedAt: state.startedAt.Format(time.RFC3339),
+		Status:    state.status(),
+		Version:   state.version,
+		Leases:    len(state.leases),
+		Labels:    state.labels,
+		HostInfo:  state.host,
+		Versions:  state.versionInfos(),
 	}
-	state.mu.Unlock()
-
-	data, _ := json.Marshal(info)
-	data = append(data, '\n')
-	_, _ = conn.Write(data)
 }
 
-// infoResponse is the JSON payload for CTAP1 INFO.
-type infoResponse struct {
-	Name      string `json:"name"`
-	Commit    string `json:"commit"`
-	Arch      string `json:"arch"`
-	Folder    string `json:"folder"`
-	PID       int    `json:"pid"`
-	StartedAt string `json:"started_at"`
+// InfoResponse is the JSON payload for CTAP1 INFO, from both codetap run
+// and codetap relay.
+type InfoResponse struct {
+	Name      string            `json:"name"`
+	Commit    string            `json:"commit"`
+	Arch      string            `json:"arch"`
+	Folder    string            `json:"folder"`
+	PID       int               `json:"pid"`
+	StartedAt string            `json:"started_at"`
+	Status    string            `json:"status"`
+	Version   string            `json:"version,omitempty"`
+	Leases    int               `json:"leases"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	domain.HostInfo
+
+	// Versions lists side-by-side code-servers in multi-version mode.
+	Versions []versionInfo `json:"versions,omitempty"`
 }
 
-// handleConnect performs version negotiation and keeps the connection open as a lease.
-func (s *Service) handleConnect(conn net.Conn, state *sessionState, line string, restartCh chan restartReq) {
+// handleConnect performs version negotiation and keeps the connection open as
+// a lease. Optional trailing fields: "keepalive=<secs>" makes the server send
+// PING every interval and expire the lease if the client stops answering;
+// "force" takes the session over from clients holding another version.
+func (s *Service) handleConnect(conn net.Conn, peer domain.PeerCred, reader *bufio.Reader, state *sessionState, line string, restartCh chan restartReq) {
 	parts := strings.Fields(line)
-	if len(parts) != 4 {
+	if len(parts) < 4 || len(parts) > 6 {
 		_, _ = fmt.Fprintf(conn, "ERR invalid CONNECT syntax\n")
 		_ = conn.Close()
 		return
@@ -329,55 +530,134 @@ func (s *Service) handleConnect(conn net.Conn, state *sessionState, line string,
 	clientCommit := parts[2]
 	clientID := parts[3]
 
+	var ka *keepalive
+	force := false
+	for _, opt := range parts[4:] {
+		if opt == "force" {
+			force = true
+			continue
+		}
+		interval, err := parseKeepaliveField(opt)
+		if err != nil {
+			_, _ = fmt.Fprintf(conn, "ERR %v\n", err)
+			_ = conn.Close()
+			return
+		}
+		ka = newKeepalive(interval, state.leaseTimeout)
+	}
+
+	res, cerr := s.connect(state, clientCommit, clientID, force, &lease{conn: conn, peer: peer}, restartCh)
+	if cerr != nil {
+		_, _ = fmt.Fprintf(conn, "ERR %s\n", cerr.Message)
+		_ = conn.Close()
+		return
+	}
+
+	// Extra fields are only sent to clients that can expect them: socket= when
+	// the lease is on a side-by-side server, keepalive= when one was requested.
+	reply := "OK " + res.Token
+	if res.Socket != state.socketPath {
+		reply += " socket=" + res.Socket
+	}
+	if ka != nil {
+		reply += fmt.Sprintf(" keepalive=%d", ka.seconds())
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	_, _ = fmt.Fprintf(conn, "%s\n", reply)
+	if ka != nil {
+		ka.start(func() error {
+			_, err := io.WriteString(conn, "PING\n")
+			return err
+		})
+	}
+	go s.monitorLease(conn, reader, state, clientID, ka)
+}
+
+// connect performs version negotiation for clientID and registers l as its
+// lease. With force, conflicting leases are taken over instead of refusing
+// the switch. It returns the token and data socket to use, or an error whose
+// message CTAP1 sends verbatim after "ERR ".
+func (s *Service) connect(state *sessionState, clientCommit, clientID string, force bool, l *lease, restartCh chan restartReq) (ConnectResult, *ctap2.Error) {
+	l.commit = clientCommit
 	state.mu.Lock()
 
 	// Replace existing lease for the same client_id (reconnect).
 	if old, ok := state.leases[clientID]; ok {
-		_ = old.Close()
+		if old.conn != l.conn {
+			_ = old.conn.Close()
+		}
 		delete(state.leases, clientID)
+		if old.commit != state.commit && state.versions[old.commit] != nil {
+			s.scheduleReap(state, old.commit)
+		}
 	}
 
-	if clientCommit == state.commit {
+	if clientCommit == state.commit && !state.stopped() {
 		// Same version — grant lease immediately.
-		state.leases[clientID] = conn
-		token := state.token
+		state.leases[clientID] = l
+		res := ConnectResult{Token: state.token, Commit: clientCommit, Socket: state.socketPath}
 		state.mu.Unlock()
 
-		_ = conn.SetReadDeadline(time.Time{})
-		_, _ = fmt.Fprintf(conn, "OK %s\n", token)
-		s.logger.Info("lease granted", "client", clientID, "commit", clientCommit)
-		go s.monitorLease(conn, state, clientID)
-		return
+		s.logger.Info("lease granted", "client", clientID, "commit", clientCommit, "pid", l.peer.PID, "uid", l.peer.UID)
+		state.notify("lease.granted", leaseEvent{ClientID: clientID, Commit: clientCommit})
+		return res, nil
+	}
+
+	if state.multiVersion && !state.stopped() {
+		state.mu.Unlock()
+		return s.connectVersion(state, clientCommit, clientID, l)
 	}
 
 	// Different version — check for conflicting leases from other clients.
-	conflicting := 0
-	for id := range state.leases {
+	conflicting := make(map[string]*lease)
+	for id, held := range state.leases {
 		if id != clientID {
-			conflicting++
+			conflicting[id] = held
 		}
 	}
 
 	currentCommit := state.commit
+	waking := state.idle
+	firstStart := state.waiting
 
-	if conflicting > 0 {
+	if len(conflicting) > 0 && !force {
 		state.mu.Unlock()
-		_, _ = fmt.Fprintf(conn, "ERR version mismatch: %s running, %d client(s) connected\n", currentCommit, conflicting)
-		_ = conn.Close()
-		return
+		return ConnectResult{}, &ctap2.Error{
+			Code:    ctap2.CodeVersionMismatch,
+			Message: fmt.Sprintf("version mismatch: %s running, %d client(s) connected", currentCommit, len(conflicting)),
+			Data:    VersionMismatch{Commit: currentCommit, Clients: len(conflicting)},
+		}
+	}
+	if len(conflicting) > 0 && state.noTakeover {
+		state.mu.Unlock()
+		return ConnectResult{}, &ctap2.Error{
+			Code:    ctap2.CodeTakeoverDisabled,
+			Message: fmt.Sprintf("takeover disabled: %s running, %d client(s) connected", currentCommit, len(conflicting)),
+			Data:    VersionMismatch{Commit: currentCommit, Clients: len(conflicting)},
+		}
 	}
 
 	if state.restartInProgress {
 		state.mu.Unlock()
-		_, _ = fmt.Fprintf(conn, "ERR restart already in progress\n")
-		_ = conn.Close()
-		return
+		return ConnectResult{}, ctap2.Errorf(ctap2.CodeRestartInProgress, "restart already in progress")
 	}
 	state.restartInProgress = true
 	state.mu.Unlock()
 
-	// No conflicting leases — request restart with the new version.
-	s.logger.Info("restart requested", "from", currentCommit, "to", clientCommit, "client", clientID)
+	if len(conflicting) > 0 {
+		s.takeover(state, conflicting, clientCommit, clientID, l.conn)
+	}
+
+	// No conflicting leases left — request restart with the new version.
+	switch {
+	case firstStart:
+		s.logger.Info("starting code-server for first client", "commit", clientCommit, "client", clientID)
+	case waking:
+		s.logger.Info("starting idle code-server", "commit", clientCommit, "client", clientID)
+	default:
+		s.logger.Info("restart requested", "from", currentCommit, "to", clientCommit, "client", clientID)
+	}
+	state.notify("session.restarting", restartEvent{From: currentCommit, To: clientCommit})
 	result := make(chan error, 1)
 	restartCh <- restartReq{commit: clientCommit, result: result}
 
@@ -387,82 +667,193 @@ func (s *Service) handleConnect(conn net.Conn, state *sessionState, line string,
 	state.restartInProgress = false
 	if restartErr != nil {
 		state.mu.Unlock()
-		_, _ = fmt.Fprintf(conn, "ERR restart failed: %v\n", restartErr)
-		_ = conn.Close()
-		return
+		if len(conflicting) > 0 {
+			s.logger.Error("takeover aborted, holders keep their leases", "client", clientID, "commit", clientCommit, "err", restartErr)
+		}
+		state.notify("session.restart_failed", restartEvent{From: currentCommit, To: clientCommit, Error: restartErr.Error()})
+		return ConnectResult{}, ctap2.Errorf(ctap2.CodeRestartFailed, "restart failed: %v", restartErr)
 	}
-	state.leases[clientID] = conn
-	token := state.token
 	state.mu.Unlock()
 
-	_ = conn.SetReadDeadline(time.Time{})
-	_, _ = fmt.Fprintf(conn, "OK %s\n", token)
-	s.logger.Info("lease granted after restart", "client", clientID, "commit", clientCommit)
-	go s.monitorLease(conn, state, clientID)
+	s.evict(state, conflicting, clientID, l.conn)
+
+	state.mu.Lock()
+	state.leases[clientID] = l
+	res := ConnectResult{Token: state.token, Commit: clientCommit, Socket: state.socketPath}
+	state.mu.Unlock()
+
+	s.logger.Info("lease granted after restart", "client", clientID, "commit", clientCommit, "pid", l.peer.PID, "uid", l.peer.UID)
+	state.notify("session.restarted", restartEvent{From: currentCommit, To: clientCommit})
+	state.notify("lease.granted", leaseEvent{ClientID: clientID, Commit: clientCommit})
+	return res, nil
 }
 
-// monitorLease blocks until the control connection closes, then removes the lease.
-func (s *Service) monitorLease(conn net.Conn, state *sessionState, clientID string) {
-	buf := make([]byte, 1)
-	_, _ = conn.Read(buf) // blocks until EOF or error
+// connectVersion grants clientID a lease on a side-by-side code-server for
+// clientCommit, starting one if this is the first client on that commit.
+func (s *Service) connectVersion(state *sessionState, clientCommit, clientID string, l *lease) (ConnectResult, *ctap2.Error) {
+	vs, err := s.ensureVersion(state, clientCommit)
+	if err != nil {
+		return ConnectResult{}, ctap2.Errorf(ctap2.CodeRestartFailed, "start %s failed: %v", clientCommit, err)
+	}
+
 	state.mu.Lock()
-	if state.leases[clientID] == conn {
-		delete(state.leases, clientID)
-		s.logger.Info("lease released", "client", clientID)
+	if state.versions[clientCommit] != vs {
+		// Reaped or exited between starting and granting.
+		state.mu.Unlock()
+		return ConnectResult{}, ctap2.Errorf(ctap2.CodeRestartFailed, "start %s failed: server exited", clientCommit)
 	}
+	state.leases[clientID] = l
 	state.mu.Unlock()
+
+	s.logger.Info("lease granted", "client", clientID, "commit", clientCommit, "socket", vs.socketPath, "pid", l.peer.PID, "uid", l.peer.UID)
+	state.notify("lease.granted", leaseEvent{ClientID: clientID, Commit: clientCommit})
+	return ConnectResult{Token: vs.token, Commit: clientCommit, Socket: vs.socketPath}, nil
+}
+
+// monitorLease blocks until the control connection closes, then removes the
+// lease. With a keepalive, every line from the client (normally PONG) renews
+// the lease and a silent client expires it.
+func (s *Service) monitorLease(conn net.Conn, reader *bufio.Reader, state *sessionState, clientID string, ka *keepalive) {
+	if ka == nil {
+		buf := make([]byte, 1)
+		_, _ = conn.Read(buf) // blocks until EOF or error
+		s.releaseLease(state, clientID, conn, releaseClosed)
+		return
+	}
+
+	defer ka.stop()
+	reason := releaseClosed
+	for {
+		ka.extend(conn)
+		if _, err := reader.ReadString('\n'); err != nil {
+			if isTimeout(err) {
+				s.logger.Error("lease keepalive timed out", "client", clientID, "timeout", ka.timeout)
+				reason = releaseExpired
+			}
+			break
+		}
+	}
+	_ = conn.Close()
+	s.releaseLease(state, clientID, conn, reason)
+}
+
+// Reasons reported in lease.released notifications.
+const (
+	releaseClosed   = "closed"   // client closed the connection
+	releaseExpired  = "expired"  // client stopped answering keepalive pings
+	releaseTakeover = "takeover" // evicted by a forced CONNECT for another version
+)
+
+// releaseLease removes clientID's lease if it is still held on conn.
+func (s *Service) releaseLease(state *sessionState, clientID string, conn net.Conn, reason string) {
+	state.mu.Lock()
+	l, ok := state.leases[clientID]
+	if !ok || l.conn != conn {
+		state.mu.Unlock()
+		return
+	}
+	delete(state.leases, clientID)
+	if len(state.leases) == 0 {
+		state.idleSince = time.Now()
+	}
+	secondary := l.commit != state.commit && state.versions[l.commit] != nil
+	state.mu.Unlock()
+
+	s.logger.Info("lease released", "client", clientID, "reason", reason)
+	state.notify("lease.released", leaseEvent{ClientID: clientID, Commit: l.commit, Reason: reason})
+	if secondary {
+		s.scheduleReap(state, l.commit)
+	}
 }
 
 // List returns all discovered session entries by querying control sockets.
+// Alive sessions are additionally asked for their HEALTH; sessions that
+// predate the command keep a zero Health.
 func (s *Service) List() ([]domain.SocketEntry, error) {
 	names, err := s.store.ListSessionNames()
 	if err != nil {
 		return nil, err
 	}
 
-	var entries []domain.SocketEntry
-	for _, name := range names {
-		ctlPath := s.store.CtlSocketPath(name)
-		meta, alive := QueryCtlInfo(ctlPath)
-		if !alive {
-			meta.Name = name
-		}
-		entries = append(entries, domain.SocketEntry{
-			Name:     name,
-			Path:     s.store.SocketPath(name),
-			Metadata: meta,
-			Alive:    alive,
-		})
-	}
-	return entries, nil
+	return s.probeSessions(names), nil
+}
+
+// CleanOptions selects which stale sessions Clean removes.
+type CleanOptions struct {
+	// Selector restricts cleanup to matching sessions. Stale sessions cannot
+	// report their labels, so Clean rejects a selector that no session
+	// without labels matches.
+	Selector Selector
+	// Force also removes sessions that timed out or answered with an error
+	// instead of only those with nothing listening.
+	Force bool
+	// Kill terminates the process groups of orphaned code-servers instead of
+	// only reporting them.
+	Kill bool
 }
 
-// Clean removes stale session entries whose control sockets are no longer alive.
-func (s *Service) Clean() error {
+// Clean removes stale session entries whose control sockets are no longer
+// alive. Without Force, sessions that did not answer in time are kept: they
+// may only be hung, and removing their sockets would orphan them.
+//
+// Clean then scans for orphaned code-servers: processes whose --socket-path
+// lies in a session directory but whose session has no live control socket,
+// typically left behind when codetap was killed with SIGKILL. They are
+// reported, and with Kill their process groups are terminated.
+func (s *Service) Clean(opts CleanOptions) error {
+	if !opts.Selector.Matches(nil) {
+		return errors.New("selector cannot match stale sessions: they have no known labels")
+	}
 	names, err := s.store.ListSessionNames()
 	if err != nil {
 		return fmt.Errorf("list sessions: %w", err)
 	}
 
-	removed := 0
-	for _, name := range names {
-		ctlPath := s.store.CtlSocketPath(name)
-		_, alive := QueryCtlInfo(ctlPath)
-		if !alive {
-			s.logger.Info("removing stale session", "name", name)
-			if err := s.store.Remove(name); err != nil {
-				s.logger.Error("remove stale session failed", "name", name, "err", err)
-				continue
-			}
-			removed++
+	removed, kept := 0, 0
+	live := map[string]bool{}
+	for _, e := range s.probeSessions(names) {
+		switch {
+		case e.Probe == domain.ProbeOK:
+			live[e.Name] = true
+			continue
+		case e.Probe != domain.ProbeRefused && !opts.Force:
+			s.logger.Info("keeping unresponsive session; use --force to remove it", "name", e.Name, "probe", e.Probe, "err", e.ProbeError)
+			live[e.Name] = true
+			kept++
+			continue
 		}
+		s.logger.Info("removing stale session", "name", e.Name, "probe", e.Probe)
+		if err := s.store.Remove(e.Name); err != nil {
+			s.logger.Error("remove stale session failed", "name", e.Name, "err", err)
+			continue
+		}
+		removed++
 	}
-	s.logger.Info("cleanup complete", "removed", removed)
+
+	orphans, err := s.findOrphans(live)
+	if err != nil {
+		s.logger.Info("orphan scan unavailable", "err", err)
+	}
+	killed := 0
+	for _, o := range orphans {
+		if !opts.Kill {
+			s.logger.Info("orphaned code-server; use --kill to terminate it", "session", o.session, "pgid", o.pgid, "pids", o.pids, "command", o.command, "socket", o.socket)
+			continue
+		}
+		s.logger.Info("killing orphaned code-server", "session", o.session, "pgid", o.pgid, "pids", o.pids, "command", o.command)
+		if err := killOrphan(o); err != nil {
+			s.logger.Error("kill orphaned code-server failed", "session", o.session, "pgid", o.pgid, "err", err)
+			continue
+		}
+		killed++
+	}
+	s.logger.Info("cleanup complete", "removed", removed, "kept", kept, "orphans", len(orphans), "killed", killed)
 	return nil
 }
 
 // Provision ensures the VS Code Server is downloaded and extracted for the
-// given commit and architecture. Returns the path to the server binary.
+// given commit and architecture, and records the use for cache pruning.
+// Returns the path to the server binary.
 func (s *Service) Provision(commit, arch string) (string, error) {
 	if !s.provision.IsProvisioned(commit) {
 		tarball, err := s.downloader.Download(commit, arch)
@@ -474,33 +865,49 @@ func (s *Service) Provision(commit, arch string) (string, error) {
 			return "", fmt.Errorf("extract: %w", err)
 		}
 	}
+	if s.cache != nil {
+		if err := s.cache.MarkUsed(commit); err != nil {
+			s.logger.Error("record server use failed", "commit", commit, "err", err)
+		}
+	}
 	return s.provision.ServerBinPath(commit), nil
 }
 
 // QueryCtlInfo connects to a control socket, sends CTAP1 INFO, and parses
 // the response. Returns the metadata and whether the session is alive.
 func QueryCtlInfo(ctlPath string) (domain.Metadata, bool) {
-	conn, err := net.DialTimeout("unix", ctlPath, time.Second)
+	meta, err := queryCtlInfo(ctlPath, time.Now().Add(3*time.Second))
+	return meta, err == nil
+}
+
+// queryCtlInfo is QueryCtlInfo with every step bounded by deadline. The error
+// keeps the underlying dial or read error so callers can tell a dead session
+// from a hung one.
+func queryCtlInfo(ctlPath string, deadline time.Time) (domain.Metadata, error) {
+	conn, err := dialCtl(ctlPath, 2*time.Second, deadline)
 	if err != nil {
-		return domain.Metadata{}, false
+		return domain.Metadata{}, err
 	}
 	defer conn.Close()
 
-	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
 	_, _ = fmt.Fprintf(conn, "CTAP1 INFO\n")
 
 	reader := bufio.NewReader(conn)
 	line, err := reader.ReadString('\n')
 	if err != nil {
-		return domain.Metadata{}, false
+		return domain.Metadata{}, err
 	}
 
-	var info infoResponse
+	var info InfoResponse
 	if err := json.Unmarshal([]byte(line), &info); err != nil {
-		return domain.Metadata{}, false
+		return domain.Metadata{}, fmt.Errorf("unexpected INFO response %q", strings.TrimSpace(line))
 	}
 
 	startedAt, _ := time.Parse(time.RFC3339, info.StartedAt)
+	var commits []string
+	for _, v := range info.Versions {
+		commits = append(commits, v.Commit)
+	}
 	return domain.Metadata{
 		Name:      info.Name,
 		Commit:    info.Commit,
@@ -508,7 +915,13 @@ func QueryCtlInfo(ctlPath string) (domain.Metadata, bool) {
 		Folder:    info.Folder,
 		PID:       info.PID,
 		StartedAt: startedAt,
-	}, true
+		Status:    info.Status,
+		Version:   info.Version,
+		Leases:    info.Leases,
+		Labels:    info.Labels,
+		Commits:   commits,
+		HostInfo:  info.HostInfo,
+	}, nil
 }
 
 // RunStdio starts VS Code Server on a temporary socket inside the container
@@ -519,11 +932,18 @@ func (s *Service) RunStdio(cfg Config, stdin io.Reader, stdout io.Writer, resolv
 
 	if initPhase {
 		s.logger.Info("waiting for init frame with commit hash")
-		var err error
-		commit, err = readInitCommit(stdin)
+		var (
+			flags uint32
+			err   error
+		)
+		commit, flags, err = readInit(stdin)
 		if err != nil {
 			return err
 		}
+		if h, ok := s.downloader.(hostSupplied); ok && flags&relay.InitSuppliesTarballs != 0 {
+			s.logger.Info("relay host can supply the server")
+			h.EnableHost()
+		}
 		if commit != "" {
 			s.logger.Info("received init frame", "commit", commit)
 		} else {
@@ -555,7 +975,7 @@ func (s *Service) RunStdio(cfg Config, stdin io.Reader, stdout io.Writer, resolv
 		return fmt.Errorf("remove stale temp socket: %w", err)
 	}
 
-	wait, stop, err := s.runner.Start(binPath, tmpSocket, "")
+	_, wait, stop, err := s.runner.Start(binPath, tmpSocket, "")
 	if err != nil {
 		return err
 	}
@@ -599,15 +1019,24 @@ func (s *Service) RunStdio(cfg Config, stdin io.Reader, stdout io.Writer, resolv
 	}
 }
 
-func readInitCommit(r io.Reader) (string, error) {
+// hostSupplied is implemented by downloaders that can fetch the server
+// tarball from the relay host. RunStdio enables it when the host's init frame
+// offers tarballs.
+type hostSupplied interface {
+	EnableHost()
+}
+
+// readInit reads the host's init frame: the commit and the feature flags
+// carried in its ConnID.
+func readInit(r io.Reader) (string, uint32, error) {
 	frame, err := relay.ReadFrame(r)
 	if err != nil {
-		return "", fmt.Errorf("read init frame: %w", err)
+		return "", 0, fmt.Errorf("read init frame: %w", err)
 	}
 	if frame.Type != relay.FrameInit {
-		return "", fmt.Errorf("expected FrameInit (0x%02x), got 0x%02x", relay.FrameInit, frame.Type)
+		return "", 0, fmt.Errorf("expected FrameInit (0x%02x), got 0x%02x", relay.FrameInit, frame.Type)
 	}
-	return string(frame.Data), nil
+	return string(frame.Data), frame.ConnID, nil
 }
 
 func waitForSocket(path string) error {
@@ -6,13 +6,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
+	"codetap/internal/adapter/ctap2"
 	"codetap/internal/adapter/relay"
 	"codetap/internal/domain"
 )
@@ -25,7 +29,7 @@ func newTestService(
 	st *mockStore,
 	tg *mockTokenGen,
 ) *Service {
-	return NewService(dl, ex, pr, sr, st, tg, &mockLogger{})
+	return NewService(dl, ex, pr, nil, sr, st, tg, &mockLogger{})
 }
 
 func testConfig(socketDir string) Config {
@@ -431,6 +435,63 @@ func TestRun_LeaseCleanupOnDisconnect(t *testing.T) {
 	<-runDone
 }
 
+func TestRun_InfoReportsIdentityAndLabels(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	runner := newBlockingRunner()
+
+	svc := newTestService(
+		&mockDownloader{downloadFn: func(_, _ string) (string, error) { return "", nil }},
+		&mockExtractor{extractFn: func(_, _ string) error { return nil }},
+		&mockProvisioner{provisioned: true, binPath: "/bin/cs"},
+		runner, st,
+		&mockTokenGen{token: "tok"},
+	)
+
+	cfg := testConfig(dir)
+	cfg.Version = "1.2.3"
+	uid := 1000
+	cfg.Host = domain.HostInfo{Hostname: "box", UID: &uid, User: "dev", OSID: "debian", OSVersion: "12", ContainerRuntime: "docker", ContainerID: "3f4e8a1b2c5d"}
+	cfg.Labels = map[string]string{"project": "foo"}
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- svc.Run(cfg)
+	}()
+	defer func() {
+		runner.Stop()
+		<-runDone
+	}()
+
+	ctlPath := st.CtlSocketPath("test-session")
+	waitForCtlSocket(t, ctlPath)
+
+	c1, line := connectCTAP1(t, ctlPath, "abc123", "client-1")
+	defer c1.Close()
+	if line != "OK tok\n" {
+		t.Fatalf("CONNECT = %q", line)
+	}
+
+	meta, alive := QueryCtlInfo(ctlPath)
+	if !alive {
+		t.Fatal("session should be alive")
+	}
+	if meta.Version != "1.2.3" || meta.Status != domain.StatusRunning || meta.Leases != 1 {
+		t.Errorf("INFO version/status/leases = %q/%q/%d", meta.Version, meta.Status, meta.Leases)
+	}
+	if meta.Labels["project"] != "foo" {
+		t.Errorf("INFO labels = %v", meta.Labels)
+	}
+	if meta.UID == nil || *meta.UID != uid {
+		t.Errorf("INFO uid = %v, want %d", meta.UID, uid)
+	}
+	host := meta.HostInfo
+	host.UID = cfg.Host.UID
+	if host != cfg.Host {
+		t.Errorf("INFO host = %+v, want %+v", meta.HostInfo, cfg.Host)
+	}
+}
+
 func TestClean_RemovesStale(t *testing.T) {
 	dir := setupTestDir(t)
 	st := newMockStore(dir)
@@ -467,7 +528,7 @@ func TestClean_RemovesStale(t *testing.T) {
 
 	svc := newTestService(nil, nil, nil, nil, st, &mockTokenGen{})
 
-	if err := svc.Clean(); err != nil {
+	if err := svc.Clean(CleanOptions{}); err != nil {
 		t.Fatalf("Clean() error: %v", err)
 	}
 
@@ -512,7 +573,7 @@ func TestClean_KeepsAlive(t *testing.T) {
 
 	svc := newTestService(nil, nil, nil, nil, st, &mockTokenGen{})
 
-	if err := svc.Clean(); err != nil {
+	if err := svc.Clean(CleanOptions{}); err != nil {
 		t.Fatalf("Clean() error: %v", err)
 	}
 
@@ -521,6 +582,31 @@ func TestClean_KeepsAlive(t *testing.T) {
 	}
 }
 
+func TestClean_SelectorNeedsLabels(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	_ = os.WriteFile(filepath.Join(dir, "dead.ctl.sock"), nil, 0644)
+
+	svc := newTestService(nil, nil, nil, nil, st, &mockTokenGen{})
+
+	// A stale session has no known labels, so project=foo can never match.
+	sel, _ := ParseSelector("project=foo")
+	if err := svc.Clean(CleanOptions{Selector: sel}); err == nil {
+		t.Fatal("Clean(project=foo) succeeded, want an error")
+	}
+	if len(st.removed) != 0 {
+		t.Errorf("Clean(project=foo) removed %v", st.removed)
+	}
+
+	sel, _ = ParseSelector("!project")
+	if err := svc.Clean(CleanOptions{Selector: sel}); err != nil {
+		t.Fatalf("Clean() error: %v", err)
+	}
+	if len(st.removed) != 1 {
+		t.Errorf("Clean(!project) removed %v, want dead", st.removed)
+	}
+}
+
 func TestList_ReturnsEntries(t *testing.T) {
 	dir := setupTestDir(t)
 	st := newMockStore(dir)
@@ -617,7 +703,7 @@ func TestProvision_DownloadsAndExtracts(t *testing.T) {
 	}
 }
 
-func TestReadInitCommit_Success(t *testing.T) {
+func TestReadInit_Success(t *testing.T) {
 	var buf bytes.Buffer
 	commit := "abc123def456abc123def456abc123def456abc1"
 	if err := relay.WriteFrame(&buf, relay.Frame{
@@ -626,16 +712,16 @@ func TestReadInitCommit_Success(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	got, err := readInitCommit(&buf)
+	got, flags, err := readInit(&buf)
 	if err != nil {
-		t.Fatalf("readInitCommit() error: %v", err)
+		t.Fatalf("readInit() error: %v", err)
 	}
-	if got != commit {
-		t.Errorf("got %q, want %q", got, commit)
+	if got != commit || flags != 0 {
+		t.Errorf("got %q, flags %d; want %q, 0", got, flags, commit)
 	}
 }
 
-func TestReadInitCommit_WrongFrameType(t *testing.T) {
+func TestReadInit_WrongFrameType(t *testing.T) {
 	var buf bytes.Buffer
 	if err := relay.WriteFrame(&buf, relay.Frame{
 		Type: relay.FrameData, ConnID: 1, Data: []byte("hello"),
@@ -643,13 +729,13 @@ func TestReadInitCommit_WrongFrameType(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	_, err := readInitCommit(&buf)
+	_, _, err := readInit(&buf)
 	if err == nil {
 		t.Fatal("expected error for wrong frame type")
 	}
 }
 
-func TestReadInitCommit_EmptyCommit(t *testing.T) {
+func TestReadInit_EmptyCommit(t *testing.T) {
 	var buf bytes.Buffer
 	if err := relay.WriteFrame(&buf, relay.Frame{
 		Type: relay.FrameInit, ConnID: 0, Data: nil,
@@ -657,19 +743,19 @@ func TestReadInitCommit_EmptyCommit(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	got, err := readInitCommit(&buf)
+	got, _, err := readInit(&buf)
 	if err != nil {
-		t.Fatalf("readInitCommit() error: %v", err)
+		t.Fatalf("readInit() error: %v", err)
 	}
 	if got != "" {
 		t.Errorf("got %q, want empty string", got)
 	}
 }
 
-func TestReadInitCommit_ReadError(t *testing.T) {
+func TestReadInit_ReadError(t *testing.T) {
 	var buf bytes.Buffer
 
-	_, err := readInitCommit(&buf)
+	_, _, err := readInit(&buf)
 	if err == nil {
 		t.Fatal("expected error from empty reader")
 	}
@@ -688,7 +774,7 @@ func newBlockingRunner() *blockingMockRunner {
 	return &blockingMockRunner{stopCh: make(chan struct{})}
 }
 
-func (m *blockingMockRunner) Start(bin, sock, token string) (func() error, func(), error) {
+func (m *blockingMockRunner) Start(bin, sock, token string) (int, func() error, func(), error) {
 	m.mu.Lock()
 	m.called = true
 	m.mu.Unlock()
@@ -707,7 +793,7 @@ func (m *blockingMockRunner) Start(bin, sock, token string) (func() error, func(
 			close(m.stopCh)
 		}
 	}
-	return wait, stop, nil
+	return syscall.Getpgrp(), wait, stop, nil
 }
 
 // Stop unblocks any waiting Start().
@@ -735,3 +821,199 @@ func waitForCtlSocket(t *testing.T, path string) {
 func startsWith(s, prefix string) bool {
 	return len(s) >= len(prefix) && s[:len(prefix)] == prefix
 }
+
+// ctap2Client is a minimal CTAP2 test client.
+type ctap2Client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID int
+}
+
+func dialCTAP2(t *testing.T, ctlPath string) *ctap2Client {
+	t.Helper()
+	conn, err := net.DialTimeout("unix", ctlPath, time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _ = fmt.Fprintf(conn, "CTAP2\n")
+	c := &ctap2Client{conn: conn, reader: bufio.NewReader(conn)}
+	if hello := c.read(t); hello["method"] != "hello" {
+		t.Fatalf("expected hello notification, got %v", hello)
+	}
+	return c
+}
+
+func (c *ctap2Client) read(t *testing.T) map[string]any {
+	t.Helper()
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var msg map[string]any
+	if err := json.Unmarshal([]byte(line), &msg); err != nil {
+		t.Fatalf("decode %q: %v", line, err)
+	}
+	return msg
+}
+
+// call sends a request and returns its response, skipping notifications.
+func (c *ctap2Client) call(t *testing.T, method string, params any) map[string]any {
+	t.Helper()
+	c.nextID++
+	req, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": c.nextID, "method": method, "params": params})
+	_, _ = c.conn.Write(append(req, '\n'))
+	for {
+		msg := c.read(t)
+		if _, ok := msg["id"]; ok {
+			return msg
+		}
+	}
+}
+
+func TestRun_CTAP2(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	runner := newBlockingRunner()
+
+	svc := newTestService(
+		&mockDownloader{downloadFn: func(_, _ string) (string, error) { return "", nil }},
+		&mockExtractor{extractFn: func(_, _ string) error { return nil }},
+		&mockProvisioner{provisioned: true, binPath: "/bin/cs"},
+		runner, st,
+		&mockTokenGen{token: "rpc-token"},
+	)
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- svc.Run(testConfig(dir))
+	}()
+
+	ctlPath := st.CtlSocketPath("test-session")
+	waitForCtlSocket(t, ctlPath)
+
+	c1 := dialCTAP2(t, ctlPath)
+	defer c1.conn.Close()
+
+	info := c1.call(t, "info", nil)
+	if info["result"].(map[string]any)["commit"] != "abc123" {
+		t.Errorf("info result = %v", info)
+	}
+
+	missing := c1.call(t, "nope", nil)
+	if missing["error"].(map[string]any)["code"] != float64(ctap2.CodeMethodNotFound) {
+		t.Errorf("unknown method response = %v", missing)
+	}
+
+	bad := c1.call(t, "connect", map[string]string{"commit": "abc123"})
+	if bad["error"].(map[string]any)["code"] != float64(ctap2.CodeInvalidParams) {
+		t.Errorf("connect without client_id response = %v", bad)
+	}
+
+	ok := c1.call(t, "connect", map[string]string{"commit": "abc123", "client_id": "c1"})
+	if ok["result"].(map[string]any)["token"] != "rpc-token" {
+		t.Fatalf("connect response = %v", ok)
+	}
+
+	// A second client on another version is refused with structured data.
+	c2 := dialCTAP2(t, ctlPath)
+	mismatch := c2.call(t, "connect", map[string]string{"commit": "def456", "client_id": "c2"})
+	e, _ := mismatch["error"].(map[string]any)
+	if e == nil || e["code"] != float64(ctap2.CodeVersionMismatch) {
+		t.Fatalf("expected version mismatch, got %v", mismatch)
+	}
+	if e["data"].(map[string]any)["clients"] != float64(1) {
+		t.Errorf("mismatch data = %v", e["data"])
+	}
+
+	// Closing c1 releases its lease and notifies remaining CTAP2 clients.
+	c1.conn.Close()
+	ev := c2.read(t)
+	for ev["method"] != "lease.released" {
+		ev = c2.read(t)
+	}
+	if ev["params"].(map[string]any)["client_id"] != "c1" {
+		t.Errorf("lease.released params = %v", ev["params"])
+	}
+	c2.conn.Close()
+
+	runner.Stop()
+	<-runDone
+}
+
+func TestRun_PeerCredentialsDenied(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	runner := newBlockingRunner()
+
+	svc := newTestService(
+		&mockDownloader{downloadFn: func(_, _ string) (string, error) { return "", nil }},
+		&mockExtractor{extractFn: func(_, _ string) error { return nil }},
+		&mockProvisioner{provisioned: true, binPath: "/bin/cs"},
+		runner, st,
+		&mockTokenGen{token: "secret"},
+	)
+
+	cfg := testConfig(dir)
+	cfg.Access = domain.AccessPolicy{UIDs: []int{os.Getuid() + 1}}
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- svc.Run(cfg)
+	}()
+
+	ctlPath := st.CtlSocketPath("test-session")
+	waitForCtlSocket(t, ctlPath)
+
+	conn, err := net.DialTimeout("unix", ctlPath, time.Second)
+	if err != nil {
+		runner.Stop()
+		t.Fatalf("dial: %v", err)
+	}
+	_, _ = fmt.Fprintf(conn, "CTAP1 CONNECT abc123 client-1\n")
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	conn.Close()
+
+	if runtime.GOOS == "linux" && line != "ERR permission denied\n" {
+		t.Errorf("CONNECT response = %q, want permission denied", line)
+	}
+
+	runner.Stop()
+	<-runDone
+}
+
+func TestNotify_DropsStalledWatcher(t *testing.T) {
+	defer func(d time.Duration) { ctap2WriteTimeout = d }(ctap2WriteTimeout)
+	ctap2WriteTimeout = 50 * time.Millisecond
+
+	st := &sessionState{watchers: map[*ctap2.Conn]net.Conn{}}
+	watch := func() net.Conn {
+		server, client := net.Pipe()
+		t.Cleanup(func() { client.Close(); server.Close() })
+		rc := ctap2.NewConn(bufio.NewReader(server), server)
+		rc.SetWriteTimeout(ctap2WriteTimeout)
+		st.watchers[rc] = server
+		return client
+	}
+	stalled := watch()
+	healthy := watch()
+	got := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(healthy).ReadString('\n')
+		got <- line
+	}()
+
+	start := time.Now()
+	st.notify("lease.released", leaseEvent{ClientID: "a"})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("notify() blocked for %v on a stalled watcher", elapsed)
+	}
+	if line := <-got; !bytes.Contains([]byte(line), []byte("lease.released")) {
+		t.Errorf("healthy watcher got %q", line)
+	}
+	// The stalled watcher was disconnected.
+	_ = stalled.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := stalled.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("stalled watcher read = %v, want EOF after being dropped", err)
+	}
+}
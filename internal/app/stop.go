@@ -0,0 +1,54 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"codetap/internal/adapter/peercred"
+)
+
+// stopTimeout is how long Stop waits for a session to shut down.
+var stopTimeout = 10 * time.Second
+
+// Stop shuts down the session behind name. It sends SIGTERM to the codetap
+// process serving the control socket, waits for the socket to go away and
+// removes any socket files the process left behind.
+//
+// The process is identified by the kernel (SO_PEERCRED) rather than the PID
+// in INFO, which belongs to the session's own PID namespace when it runs in a
+// container.
+func (s *Service) Stop(name string) error {
+	ctlPath := s.store.CtlSocketPath(name)
+	conn, err := net.DialTimeout("unix", ctlPath, time.Second)
+	if err != nil {
+		return fmt.Errorf("session %q is not running", name)
+	}
+	cred, err := peercred.Lookup(conn)
+	_ = conn.Close()
+	if err != nil {
+		return fmt.Errorf("identify session %q: %w", name, err)
+	}
+	if cred.PID == 0 {
+		return fmt.Errorf("session %q runs in another PID namespace; stop it from there", name)
+	}
+
+	s.logger.Info("stopping session", "name", name, "pid", cred.PID)
+	if err := syscall.Kill(cred.PID, syscall.SIGTERM); err != nil {
+		if errors.Is(err, syscall.EPERM) {
+			return fmt.Errorf("stop session %q (pid %d): permission denied", name, cred.PID)
+		}
+		return fmt.Errorf("stop session %q (pid %d): %w", name, cred.PID, err)
+	}
+
+	deadline := time.Now().Add(stopTimeout)
+	for isSocketAliveNow(ctlPath) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("session %q (pid %d) did not stop within %v", name, cred.PID, stopTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return s.store.Remove(name)
+}
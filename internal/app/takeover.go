@@ -0,0 +1,67 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// defaultTakeoverGrace is how long leaseholders are warned before a forced
+// CONNECT closes their connections.
+const defaultTakeoverGrace = 10 * time.Second
+
+// takeover warns the holders of conflicting leases that a forced CONNECT is
+// switching versions, over their own lease connections — "TAKEOVER <commit>
+// <by> <grace_s>" on CTAP1, a "lease.revoking" notification on CTAP2 — and
+// waits until the grace period ends or they have let go on their own. Leases
+// held on own, the requester's connection, are neither warned nor waited
+// for. The holders keep their leases until evict; the caller must have set
+// state.restartInProgress.
+func (s *Service) takeover(state *sessionState, victims map[string]*lease, commit, by string, own net.Conn) {
+	grace := state.takeoverGrace
+	s.logger.Info("lease takeover", "client", by, "commit", commit, "evicting", len(victims), "grace", grace)
+
+	others := make(map[string]*lease)
+	for id, l := range victims {
+		if l.conn == own {
+			continue
+		}
+		others[id] = l
+		ev := takeoverEvent{ClientID: id, Commit: commit, By: by, GraceS: int(grace / time.Second)}
+		if l.rpc != nil {
+			_ = l.rpc.Notify("lease.revoking", ev)
+		} else {
+			_, _ = fmt.Fprintf(l.conn, "TAKEOVER %s %s %d\n", ev.Commit, ev.By, ev.GraceS)
+		}
+	}
+
+	deadline := time.Now().Add(grace)
+	for time.Now().Before(deadline) && s.holdsAny(state, others) {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// evict revokes the leases a takeover warned about, once the new version
+// is running, and closes their connections. The requester's own connection
+// stays open: only its other leases are released.
+func (s *Service) evict(state *sessionState, victims map[string]*lease, by string, own net.Conn) {
+	for id, l := range victims {
+		s.logger.Info("lease revoked", "client", id, "by", by, "pid", l.peer.PID, "uid", l.peer.UID)
+		s.releaseLease(state, id, l.conn, releaseTakeover)
+		if l.conn != own {
+			_ = l.conn.Close()
+		}
+	}
+}
+
+// holdsAny reports whether any of the given leases is still registered.
+func (s *Service) holdsAny(state *sessionState, leases map[string]*lease) bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	for id, l := range leases {
+		if cur, ok := state.leases[id]; ok && cur == l {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,271 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRun_ForcedTakeover(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	runner := newBlockingRunner()
+
+	svc := newTestService(
+		&mockDownloader{downloadFn: func(_, _ string) (string, error) { return "", nil }},
+		&mockExtractor{extractFn: func(_, _ string) error { return nil }},
+		&mockProvisioner{provisioned: true, binPath: "/bin/cs"},
+		runner, st,
+		&mockTokenGen{token: "tok"},
+	)
+
+	cfg := testConfig(dir)
+	cfg.TakeoverGrace = time.Second
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- svc.Run(cfg)
+	}()
+	defer func() {
+		runner.Stop()
+		<-runDone
+	}()
+
+	ctlPath := st.CtlSocketPath("test-session")
+	waitForCtlSocket(t, ctlPath)
+
+	watcher := dialCTAP2(t, ctlPath)
+	defer watcher.conn.Close()
+
+	// A stale window holds a lease on the current version.
+	conn1, err := net.DialTimeout("unix", ctlPath, time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn1.Close()
+	_ = conn1.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _ = fmt.Fprintf(conn1, "CTAP1 CONNECT abc123 client-1\n")
+	reader1 := bufio.NewReader(conn1)
+	if line, _ := reader1.ReadString('\n'); !startsWith(line, "OK") {
+		t.Fatalf("first CONNECT should succeed, got %q", line)
+	}
+
+	// Forcing another version warns the holder, then closes it.
+	conn2, err := net.DialTimeout("unix", ctlPath, time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn2.Close()
+	_, _ = fmt.Fprintf(conn2, "CTAP1 CONNECT def456 client-2 force\n")
+
+	line, err := reader1.ReadString('\n')
+	if err != nil || line != "TAKEOVER def456 client-2 1\n" {
+		t.Fatalf("holder should be warned, got %q (%v)", line, err)
+	}
+	start := time.Now()
+	if _, err := reader1.ReadString('\n'); err == nil {
+		t.Fatal("holder connection should be closed after the grace period")
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("holder closed after %v, before the grace period", elapsed)
+	}
+
+	for {
+		ev := watcher.read(t)
+		if ev["method"] != "lease.released" {
+			continue
+		}
+		params := ev["params"].(map[string]any)
+		if params["client_id"] != "client-1" || params["reason"] != "takeover" {
+			t.Errorf("lease.released params = %v", params)
+		}
+		break
+	}
+
+	line2, _ := bufio.NewReader(conn2).ReadString('\n')
+	if startsWith(line2, "ERR version mismatch") {
+		t.Errorf("forced CONNECT should not be refused for a version mismatch, got %q", line2)
+	}
+}
+
+func TestRun_TakeoverDisabled(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	runner := newBlockingRunner()
+
+	svc := newTestService(
+		&mockDownloader{downloadFn: func(_, _ string) (string, error) { return "", nil }},
+		&mockExtractor{extractFn: func(_, _ string) error { return nil }},
+		&mockProvisioner{provisioned: true, binPath: "/bin/cs"},
+		runner, st,
+		&mockTokenGen{token: "tok"},
+	)
+
+	cfg := testConfig(dir)
+	cfg.NoTakeover = true
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- svc.Run(cfg)
+	}()
+	defer func() {
+		runner.Stop()
+		<-runDone
+	}()
+
+	ctlPath := st.CtlSocketPath("test-session")
+	waitForCtlSocket(t, ctlPath)
+
+	conn1, _ := net.DialTimeout("unix", ctlPath, time.Second)
+	defer conn1.Close()
+	_, _ = fmt.Fprintf(conn1, "CTAP1 CONNECT abc123 client-1\n")
+	if line, _ := bufio.NewReader(conn1).ReadString('\n'); !startsWith(line, "OK") {
+		t.Fatalf("first CONNECT should succeed, got %q", line)
+	}
+
+	conn2, _ := net.DialTimeout("unix", ctlPath, time.Second)
+	defer conn2.Close()
+	_, _ = fmt.Fprintf(conn2, "CTAP1 CONNECT def456 client-2 force\n")
+	line, _ := bufio.NewReader(conn2).ReadString('\n')
+	if line != "ERR takeover disabled: abc123 running, 1 client(s) connected\n" {
+		t.Errorf("forced CONNECT response = %q", line)
+	}
+}
+
+func TestRun_TakeoverKeepsLeasesWhenRestartFails(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	runner := newBlockingRunner()
+
+	svc := newTestService(
+		&mockDownloader{downloadFn: func(commit, _ string) (string, error) {
+			if commit == "def456" {
+				return "", fmt.Errorf("no such build")
+			}
+			return "", nil
+		}},
+		&mockExtractor{extractFn: func(_, _ string) error { return nil }},
+		&mockProvisioner{binPath: "/bin/cs"},
+		runner, st,
+		&mockTokenGen{token: "tok"},
+	)
+
+	cfg := testConfig(dir)
+	cfg.TakeoverGrace = 200 * time.Millisecond
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- svc.Run(cfg)
+	}()
+	defer func() {
+		runner.Stop()
+		<-runDone
+	}()
+
+	ctlPath := st.CtlSocketPath("test-session")
+	waitForCtlSocket(t, ctlPath)
+
+	conn1, err := net.DialTimeout("unix", ctlPath, time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn1.Close()
+	_ = conn1.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _ = fmt.Fprintf(conn1, "CTAP1 CONNECT abc123 client-1\n")
+	reader1 := bufio.NewReader(conn1)
+	if line, _ := reader1.ReadString('\n'); !startsWith(line, "OK") {
+		t.Fatalf("first CONNECT should succeed, got %q", line)
+	}
+
+	watcher := dialCTAP2(t, ctlPath)
+	defer watcher.conn.Close()
+
+	conn2, err := net.DialTimeout("unix", ctlPath, time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn2.Close()
+	_ = conn2.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _ = fmt.Fprintf(conn2, "CTAP1 CONNECT def456 client-2 force\n")
+	if line, _ := bufio.NewReader(conn2).ReadString('\n'); !startsWith(line, "ERR restart failed") {
+		t.Fatalf("forced CONNECT = %q, want the restart failure", line)
+	}
+	if line, _ := reader1.ReadString('\n'); line != "TAKEOVER def456 client-2 0\n" {
+		t.Fatalf("holder should be warned, got %q", line)
+	}
+
+	for {
+		ev := watcher.read(t)
+		if ev["method"] == "lease.released" {
+			t.Fatalf("lease released although the restart failed: %v", ev["params"])
+		}
+		if ev["method"] == "session.restart_failed" {
+			break
+		}
+	}
+
+	// The holder is still connected, and its lease still blocks a switch.
+	_ = conn1.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := reader1.ReadString('\n'); !isTimeout(err) {
+		t.Errorf("holder connection closed after a failed takeover: %v", err)
+	}
+	res := watcher.call(t, "info", nil)
+	info := res["result"].(map[string]any)
+	if info["commit"] != "abc123" || info["leases"] != float64(1) {
+		t.Errorf("info after failed takeover = %v", info)
+	}
+}
+
+func TestRun_TakeoverKeepsRequesterConnection(t *testing.T) {
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	runner := newBlockingRunner()
+
+	svc := newTestService(
+		&mockDownloader{downloadFn: func(_, _ string) (string, error) { return "", nil }},
+		&mockExtractor{extractFn: func(_, _ string) error { return nil }},
+		&mockProvisioner{provisioned: true, binPath: "/bin/cs"},
+		runner, st,
+		&mockTokenGen{token: "tok"},
+	)
+
+	cfg := testConfig(dir)
+	cfg.TakeoverGrace = 10 * time.Second
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- svc.Run(cfg)
+	}()
+	defer func() {
+		runner.Stop()
+		<-runDone
+	}()
+
+	ctlPath := st.CtlSocketPath("test-session")
+	waitForCtlSocket(t, ctlPath)
+
+	// One connection holds a lease under another client_id, as a window
+	// reloaded onto a new client_id would.
+	c := dialCTAP2(t, ctlPath)
+	defer c.conn.Close()
+	if res := c.call(t, "connect", map[string]any{"commit": "abc123", "client_id": "window-old"}); res["error"] != nil {
+		t.Fatalf("first connect error: %v", res["error"])
+	}
+
+	start := time.Now()
+	res := c.call(t, "connect", map[string]any{"commit": "def456", "client_id": "window-new", "force": true})
+	if res["error"] != nil {
+		t.Fatalf("forced connect error: %v", res["error"])
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("forced connect took %v, waiting out the grace period for its own lease", elapsed)
+	}
+
+	res = c.call(t, "info", nil)
+	info, _ := res["result"].(map[string]any)
+	if info == nil || info["commit"] != "def456" || info["leases"] != float64(1) {
+		t.Errorf("info on the requester's connection = %v, want it open with only the new lease", res)
+	}
+}
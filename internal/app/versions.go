@@ -0,0 +1,194 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// versionLinger is how long an additional code-server stays up after its last
+// lease is released, so a reloading window does not pay for a fresh start.
+var versionLinger = 30 * time.Second
+
+// versionServer is a code-server for a commit other than the session's
+// primary one. In multi-version mode it is started on the first CONNECT for
+// its commit and reaped once no lease uses it any more.
+type versionServer struct {
+	commit     string
+	socketPath string
+	token      string
+	pgid       int
+	stop       func()
+	startedAt  time.Time
+	ready      chan struct{} // closed once the start attempt has finished
+	err        error         // start error, valid after ready is closed
+}
+
+// versionInfo describes a side-by-side code-server in INFO.
+type versionInfo struct {
+	Commit  string `json:"commit"`
+	Socket  string `json:"socket"`
+	PID     int    `json:"server_pid"`
+	Clients int    `json:"clients"`
+}
+
+// ensureVersion returns the running code-server for commit, starting it if
+// needed. Concurrent callers for the same commit share one start attempt.
+func (s *Service) ensureVersion(state *sessionState, commit string) (*versionServer, error) {
+	state.mu.Lock()
+	vs, ok := state.versions[commit]
+	if ok {
+		state.mu.Unlock()
+		<-vs.ready
+		return vs, vs.err
+	}
+	vs = &versionServer{
+		commit:     commit,
+		socketPath: s.store.VersionSocketPath(state.name, commit),
+		ready:      make(chan struct{}),
+	}
+	state.versions[commit] = vs
+	arch := state.arch
+	state.mu.Unlock()
+
+	wait, err := s.startVersion(state, vs, arch)
+	vs.err = err
+	close(vs.ready)
+	if err != nil {
+		state.mu.Lock()
+		delete(state.versions, commit)
+		state.mu.Unlock()
+		return vs, err
+	}
+
+	go func() {
+		waitErr := wait()
+		s.dropServer(state, commit)
+		state.mu.Lock()
+		if state.versions[commit] == vs {
+			delete(state.versions, commit)
+		}
+		state.mu.Unlock()
+		_ = os.Remove(vs.socketPath)
+		s.logger.Info("code-server exited", "commit", commit, "err", waitErr)
+	}()
+	return vs, nil
+}
+
+// startVersion provisions and starts code-server for vs.commit on its own
+// data socket, holding the commit's server until the process exits. It
+// returns the process wait function.
+func (s *Service) startVersion(state *sessionState, vs *versionServer, arch string) (wait func() error, err error) {
+	if err := s.holdServer(state, vs.commit); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			s.dropServer(state, vs.commit)
+		}
+	}()
+
+	binPath, err := s.Provision(vs.commit, arch)
+	if err != nil {
+		return nil, fmt.Errorf("provision: %w", err)
+	}
+	token, err := s.tokenGen.Generate()
+	if err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+
+	_ = os.Remove(vs.socketPath)
+	pgid, wait, stop, err := s.runner.Start(binPath, vs.socketPath, token)
+	if err != nil {
+		return nil, fmt.Errorf("start: %w", err)
+	}
+	if err := waitForSocket(vs.socketPath); err != nil {
+		stop()
+		return nil, fmt.Errorf("server failed to start: %w", err)
+	}
+	if err := s.store.PrepareSocket(vs.socketPath); err != nil {
+		s.logger.Error("prepare data socket failed", "socket", vs.socketPath, "err", err)
+	}
+
+	vs.token = token
+	vs.pgid = pgid
+	vs.stop = stop
+	vs.startedAt = time.Now()
+	s.logger.Info("code-server started", "commit", vs.commit, "socket", vs.socketPath)
+	return wait, nil
+}
+
+// scheduleReap stops the code-server for commit after versionLinger unless a
+// lease has picked it up again by then.
+func (s *Service) scheduleReap(state *sessionState, commit string) {
+	time.AfterFunc(versionLinger, func() {
+		state.mu.Lock()
+		vs, ok := state.versions[commit]
+		if !ok || state.leaseCount(commit) > 0 {
+			state.mu.Unlock()
+			return
+		}
+		select {
+		case <-vs.ready:
+		default:
+			state.mu.Unlock()
+			return // still starting for a new CONNECT
+		}
+		delete(state.versions, commit)
+		state.mu.Unlock()
+
+		s.logger.Info("reaping idle code-server", "commit", commit, "socket", vs.socketPath)
+		vs.stop()
+	})
+}
+
+// stopVersions stops every side-by-side code-server when the session ends.
+func (s *Service) stopVersions(state *sessionState) {
+	state.mu.Lock()
+	servers := make([]*versionServer, 0, len(state.versions))
+	for commit, vs := range state.versions {
+		servers = append(servers, vs)
+		delete(state.versions, commit)
+	}
+	state.mu.Unlock()
+
+	for _, vs := range servers {
+		<-vs.ready
+		if vs.err == nil {
+			vs.stop()
+			_ = os.Remove(vs.socketPath)
+		}
+	}
+}
+
+// leaseCount returns the number of leases held on commit. The caller must
+// hold st.mu.
+func (st *sessionState) leaseCount(commit string) int {
+	n := 0
+	for _, l := range st.leases {
+		if l.commit == commit {
+			n++
+		}
+	}
+	return n
+}
+
+// versionInfos lists the side-by-side code-servers for INFO. The caller must
+// hold st.mu.
+func (st *sessionState) versionInfos() []versionInfo {
+	var infos []versionInfo
+	for commit, vs := range st.versions {
+		select {
+		case <-vs.ready:
+		default:
+			continue
+		}
+		if vs.err != nil {
+			continue
+		}
+		infos = append(infos, versionInfo{Commit: commit, Socket: vs.socketPath, PID: vs.pgid, Clients: st.leaseCount(commit)})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Commit < infos[j].Commit })
+	return infos
+}
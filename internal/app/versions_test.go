@@ -0,0 +1,162 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// multiRunner starts independent fake servers, one stop channel per Start.
+type multiRunner struct {
+	mu      sync.Mutex
+	sockets []string
+	stops   []chan struct{}
+}
+
+func (m *multiRunner) Start(bin, sock, token string) (int, func() error, func(), error) {
+	_ = os.WriteFile(sock, nil, 0600)
+	stopCh := make(chan struct{})
+	m.mu.Lock()
+	m.sockets = append(m.sockets, sock)
+	m.stops = append(m.stops, stopCh)
+	m.mu.Unlock()
+
+	wait := func() error {
+		<-stopCh
+		return nil
+	}
+	stop := func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		closeOnce(stopCh)
+	}
+	return syscall.Getpgrp(), wait, stop, nil
+}
+
+func (m *multiRunner) started() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.sockets...)
+}
+
+// stopAll ends the session by stopping every server started so far.
+func (m *multiRunner) stopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.stops {
+		closeOnce(ch)
+	}
+}
+
+func closeOnce(ch chan struct{}) {
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+func connectCTAP1(t *testing.T, ctlPath, commit, clientID string) (net.Conn, string) {
+	t.Helper()
+	conn, err := net.DialTimeout("unix", ctlPath, time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _ = fmt.Fprintf(conn, "CTAP1 CONNECT %s %s\n", commit, clientID)
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	return conn, line
+}
+
+func TestRun_MultiVersion(t *testing.T) {
+	defer func(d time.Duration) { versionLinger = d }(versionLinger)
+	versionLinger = 50 * time.Millisecond
+
+	dir := setupTestDir(t)
+	st := newMockStore(dir)
+	runner := &multiRunner{}
+
+	svc := newTestService(
+		&mockDownloader{downloadFn: func(_, _ string) (string, error) { return "", nil }},
+		&mockExtractor{extractFn: func(_, _ string) error { return nil }},
+		&mockProvisioner{provisioned: true, binPath: "/bin/cs"},
+		runner, st,
+		&mockTokenGen{token: "tok"},
+	)
+
+	cfg := testConfig(dir)
+	cfg.MultiVersion = true
+
+	runDone := make(chan error, 1)
+	go func() {
+		runDone <- svc.Run(cfg)
+	}()
+
+	ctlPath := st.CtlSocketPath("test-session")
+	waitForCtlSocket(t, ctlPath)
+
+	// The primary commit keeps the plain reply and data socket.
+	c1, line := connectCTAP1(t, ctlPath, "abc123", "client-1")
+	defer c1.Close()
+	if line != "OK tok\n" {
+		t.Fatalf("primary CONNECT = %q", line)
+	}
+
+	// Another commit gets its own server instead of a version mismatch.
+	versionSock := st.VersionSocketPath("test-session", "def456")
+	c2, line := connectCTAP1(t, ctlPath, "def456", "client-2")
+	if line != "OK tok socket="+versionSock+"\n" {
+		t.Fatalf("side-by-side CONNECT = %q", line)
+	}
+	c3, line := connectCTAP1(t, ctlPath, "def456", "client-3")
+	if line != "OK tok socket="+versionSock+"\n" {
+		t.Fatalf("second side-by-side CONNECT = %q", line)
+	}
+	if n := len(runner.started()); n != 2 {
+		t.Errorf("started %d servers, want 2 (primary + def456)", n)
+	}
+
+	meta := queryInfo(t, ctlPath)
+	if len(meta.Versions) != 1 || meta.Versions[0].Commit != "def456" || meta.Versions[0].Clients != 2 {
+		t.Errorf("INFO versions = %+v", meta.Versions)
+	}
+
+	// Releasing the last lease on def456 reaps its server.
+	c2.Close()
+	c3.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(versionSock); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("idle side-by-side server was not reaped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if meta := queryInfo(t, ctlPath); len(meta.Versions) != 0 {
+		t.Errorf("INFO versions after reap = %+v", meta.Versions)
+	}
+
+	runner.stopAll()
+	<-runDone
+}
+
+func queryInfo(t *testing.T, ctlPath string) InfoResponse {
+	t.Helper()
+	c := dialCTAP2(t, ctlPath)
+	defer c.conn.Close()
+	resp := c.call(t, "info", nil)
+	data, _ := json.Marshal(resp["result"])
+	var info InfoResponse
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatalf("decode info: %v", err)
+	}
+	return info
+}
@@ -4,18 +4,130 @@ import "time"
 
 // Metadata describes a running codetap instance.
 type Metadata struct {
-	Name      string    `json:"name"`
-	Commit    string    `json:"commit"`
-	Arch      string    `json:"arch"`
-	Folder    string    `json:"folder"`
-	PID       int       `json:"pid"`
-	StartedAt time.Time `json:"started_at"`
+	Name      string            `json:"name"`
+	Commit    string            `json:"commit"`
+	Arch      string            `json:"arch"`
+	Folder    string            `json:"folder"`
+	PID       int               `json:"pid"`
+	StartedAt time.Time         `json:"started_at"`
+	Status    string            `json:"status,omitempty"`
+	Version   string            `json:"version,omitempty"` // codetap build serving the session
+	Leases    int               `json:"leases"`
+	Labels    map[string]string `json:"labels,omitempty"`  // set with --label
+	Commits   []string          `json:"commits,omitempty"` // side-by-side commits in multi-version mode
+	HostInfo
+}
+
+// HostInfo identifies the machine, user and container a session runs in.
+// Fields are empty when they cannot be detected or when the session predates
+// them.
+type HostInfo struct {
+	Hostname         string `json:"hostname,omitempty"`
+	UID              *int   `json:"uid,omitempty"` // nil if unknown, not root
+	User             string `json:"user,omitempty"`
+	OSID             string `json:"os_id,omitempty"`             // os-release ID, e.g. "debian"
+	OSVersion        string `json:"os_version,omitempty"`        // os-release VERSION_ID, e.g. "12"
+	ContainerRuntime string `json:"container_runtime,omitempty"` // docker, podman, kubernetes, containerd or lxc
+	ContainerID      string `json:"container_id,omitempty"`
+}
+
+// Session statuses reported by CTAP1 INFO.
+const (
+	StatusRunning = "running" // code-server is up
+	StatusIdle    = "idle"    // code-server stopped after the idle timeout; CONNECT restarts it
+	StatusWaiting = "waiting" // lazy session; the first CONNECT starts code-server
+)
+
+// Readiness states reported by the CTAP1 HEALTH command.
+const (
+	HealthStarting   = "starting"   // server launched, not yet answering probes
+	HealthReady      = "ready"      // process group alive and data socket answering
+	HealthRestarting = "restarting" // version switch in flight
+	HealthDegraded   = "degraded"   // process group gone or data socket not answering
+	HealthIdle       = "idle"       // stopped after the idle timeout
+	HealthWaiting    = "waiting"    // lazy session not started yet
+)
+
+// Health describes the readiness of the code-server behind a session.
+type Health struct {
+	State        string        `json:"state"`
+	ServerPID    int           `json:"server_pid"`
+	ProcessAlive bool          `json:"process_alive"`
+	SocketOK     bool          `json:"socket_ok"`
+	ProbeLatency time.Duration `json:"probe_latency"`
+	Uptime       time.Duration `json:"uptime"`
+	ReadyAt      time.Time     `json:"ready_at"`
+	Error        string        `json:"error,omitempty"`
 }
 
 // SocketEntry is a discovered socket with its metadata and liveness state.
 type SocketEntry struct {
-	Name     string
-	Path     string
-	Metadata Metadata
-	Alive    bool
+	Name       string   `json:"name"`
+	Path       string   `json:"path"`
+	Metadata   Metadata `json:"metadata"`
+	Health     Health   `json:"health"`
+	Alive      bool     `json:"alive"`
+	Probe      string   `json:"probe"`                 // outcome of the INFO query, see Probe*
+	ProbeError string   `json:"probe_error,omitempty"` // why the INFO query failed
+}
+
+// Outcomes of querying a session's control socket.
+const (
+	ProbeOK      = "ok"      // INFO answered
+	ProbeRefused = "refused" // nothing listening: the session is dead
+	ProbeTimeout = "timeout" // no answer in time: the session may be hung
+	ProbeError   = "error"   // refused or garbled answer, e.g. permission denied
+)
+
+// CacheEntry describes one commit in the server cache: its extracted server,
+// its downloaded tarballs, or both.
+type CacheEntry struct {
+	Commit      string    `json:"commit"`
+	Version     string    `json:"version,omitempty"` // VS Code version, when extracted
+	Extracted   bool      `json:"extracted"`
+	Size        int64     `json:"size"`         // bytes on disk, server and tarballs
+	TarballSize int64     `json:"tarball_size"` // part of Size taken by tarballs
+	LastUsed    time.Time `json:"last_used"`
+	InUse       []string  `json:"in_use,omitempty"` // live sessions running this commit
+}
+
+// PeerCred identifies the process on the other end of a Unix socket.
+type PeerCred struct {
+	PID    int
+	UID    int
+	GID    int
+	Groups []int // supplementary groups, if known
+}
+
+// AccessPolicy lists the users and groups allowed to talk to a session.
+// A peer is allowed if its UID is listed, or if its primary or any
+// supplementary group is listed.
+type AccessPolicy struct {
+	UIDs []int
+	GIDs []int
+}
+
+// IsZero reports whether the policy lists nobody.
+func (p AccessPolicy) IsZero() bool {
+	return len(p.UIDs) == 0 && len(p.GIDs) == 0
+}
+
+// Allows reports whether the peer satisfies the policy.
+func (p AccessPolicy) Allows(c PeerCred) bool {
+	for _, uid := range p.UIDs {
+		if c.UID == uid {
+			return true
+		}
+	}
+	for _, gid := range p.GIDs {
+		if c.GID == gid {
+			return true
+		}
+		for _, g := range c.Groups {
+			if g == gid {
+				return true
+			}
+		}
+	}
+	return false
 }
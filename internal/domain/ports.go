@@ -1,5 +1,7 @@
 package domain
 
+import "errors"
+
 // Downloader fetches the VS Code Server tarball for a given commit and arch.
 // If already cached, it returns the cached path immediately.
 type Downloader interface {
@@ -18,21 +20,46 @@ type Provisioner interface {
 	ServerDir(commit string) string
 }
 
+// ServerCache manages downloaded tarballs and extracted servers on disk.
+// MarkUsed records that a commit was just provisioned for a session, which
+// is what Entries reports as LastUsed. Use marks a commit as run by this
+// process until release is called; Remove fails with ErrServerInUse while
+// any process, whatever its socket directory, holds it.
+type ServerCache interface {
+	Entries() ([]CacheEntry, error)
+	Remove(commit string) error
+	MarkUsed(commit string) error
+	Use(commit string) (release func(), err error)
+}
+
+// ErrServerInUse is returned by ServerCache.Remove for a commit that a
+// running session uses.
+var ErrServerInUse = errors.New("server is in use by a running session")
+
 // ServerRunner starts the VS Code Server process on a Unix socket.
-// Start launches the process and returns a wait function that blocks until the
-// process exits and a stop function that terminates the process group.
+// Start launches the process and returns its process group ID, a wait function
+// that blocks until the process exits and a stop function that terminates the
+// process group.
 type ServerRunner interface {
-	Start(binPath, socketPath, token string) (wait func() error, stop func(), err error)
+	Start(binPath, socketPath, token string) (pgid int, wait func() error, stop func(), err error)
 }
 
 // MetadataStore manages socket paths and session discovery in the socket directory.
 // All session metadata is served over the CTAP1 control protocol rather than files.
+// EnsureDir creates and verifies the directory; PrepareSocket sets ownership and
+// mode on a socket after it has been created in it. VersionSocketPath names the
+// data socket of an additional code-server running another commit side by side.
+// SessionForSocket maps a data socket path back to its session name, so that
+// code-server processes can be matched to sessions by their --socket-path.
 type MetadataStore interface {
 	SocketPath(name string) string
+	VersionSocketPath(name, commit string) string
 	CtlSocketPath(name string) string
 	ListSessionNames() ([]string, error)
 	Remove(name string) error
 	EnsureDir() error
+	PrepareSocket(path string) error
+	SessionForSocket(path string) (name string, ok bool)
 }
 
 // TokenGenerator creates cryptographically secure connection tokens.